@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"birthdayGreetings/internal/clock"
+	"birthdayGreetings/internal/db"
+	h "birthdayGreetings/internal/handle"
+
+	"github.com/joho/godotenv"
+)
+
+// Синхронизация сотрудников с ночной выгрузкой HR-системы (.csv, .xlsx или .json):
+// новые добавляются, изменившиеся обновляются, ушедшие деактивируются вместе с подписками.
+// Печатает отчет; при ошибках в выгрузке ничего не меняет и завершается с кодом 1.
+// Запуск из каталога cmd (как и бот), например из cron:
+//
+//	go run ./hris -file /var/hr/employees.json
+func main() {
+	file := flag.String("file", "", "выгрузка HR-системы")
+	dryRun := flag.Bool("dry-run", false, "только показать, что изменится")
+	maxRemoved := flag.Float64("max-removed", 0.2, "какую долю активных сотрудников можно деактивировать за запуск")
+	flag.Parse()
+
+	if *file == "" {
+		log.Fatal("Укажите выгрузку: -file")
+	}
+	if err := godotenv.Load("../configs/.env"); err != nil {
+		log.Fatal("Ошибка загрузки файла .env")
+	}
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		log.Fatalf("Ошибка чтения выгрузки: %s", err)
+	}
+
+	store := db.NewDB()
+	handle := h.NewHandle(&store, clock.Real{})
+	defer handle.CloseDB()
+
+	report, err := handle.SyncEmployees(*file, data, *dryRun, *maxRemoved)
+	fmt.Print(report)
+	if err != nil {
+		if !errors.Is(err, h.ErrSyncAborted) {
+			err = fmt.Errorf("ошибка синхронизации: %w", err)
+		}
+		handle.CloseDB()
+		log.Fatal(err)
+	}
+}
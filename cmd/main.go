@@ -1,6 +1,8 @@
 package main
 
 import (
+	"birthdayGreetings/internal/clock"
+	"birthdayGreetings/internal/db"
 	h "birthdayGreetings/internal/handle"
 	"log"
 	"os"
@@ -18,7 +20,7 @@ func init() {
 }
 
 func main() {
-	h := h.NewHandle()
+	h := h.NewHandle(NewStore(), clock.Real{})
 	defer h.CloseDB()
 
 	b := RunTelegramBot()
@@ -26,15 +28,40 @@ func main() {
 	b.Handle("/start", h.BotStart)
 	b.Handle("/help", h.BotHelp)
 	b.Handle("/login", h.Login)
+	b.Handle("/cancel", h.Cancel)
+	b.Handle("/logout", h.Logout)
+	b.Handle("/addemployee", h.AddEmployee)
+	b.Handle("/editemployee", h.EditEmployee)
+	b.Handle("/deactivate", h.DeactivateEmployee)
+	b.Handle("/activate", h.ActivateEmployee)
+	b.Handle("/leave", h.LeaveEmployee)
+	b.Handle("/deadletters", h.DeadLetters)
+	b.Handle("/runscheduler", h.RunScheduler)
 	b.Handle("/subscribe", h.SubscribeToNotifications)
 	b.Handle("/unsubscribe", h.UnsubscribeFromNotifications)
 	b.Handle("/list", h.List)
 	b.Handle("/subscribed", h.Subscribed)
+	b.Handle("/timezone", h.Timezone)
+	b.Handle("/find", h.Find)
+	b.Handle("/teams", h.Teams)
+	b.Handle("/newteam", h.NewTeam)
+	b.Handle("/newdepartment", h.NewDepartment)
+	b.Handle("/deleteteam", h.DeleteTeam)
+	b.Handle("/teamadd", h.TeamAdd)
+	b.Handle("/teamremove", h.TeamRemove)
+	b.Handle("/events", h.Events)
+	b.Handle("/addevent", h.AddEvent)
+	b.Handle("/deleteevent", h.DeleteEvent)
+	b.Handle("/digest", h.Digest)
+	h.RegisterCallbacks(b)
 
 	// Обработка ответов
 	b.Handle(tb.OnText, h.WaitUserResponse)
+	// Таблица сотрудников от отдела кадров
+	b.Handle(tb.OnDocument, h.ImportEmployees)
 
-	go h.Scheduler(b)
+	go h.Scheduler()
+	go h.Dispatcher(b)
 	log.Println("Бот запущен...")
 	b.Start()
 }
@@ -51,3 +78,18 @@ func RunTelegramBot() *tb.Bot {
 
 	return bot
 }
+
+// NewStore выбирает хранилище: Postgres или память (STORAGE=memory, демо без базы)
+func NewStore() db.Store {
+	if os.Getenv("STORAGE") != "memory" {
+		store := db.NewDB()
+		return &store
+	}
+
+	snapshot, err := db.LoadCSV("../csv/employees.csv")
+	if err != nil {
+		log.Fatalf("Ошибка загрузки сотрудников из csv: %s", err)
+	}
+
+	return db.NewMemoryDB(snapshot, clock.Real{})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"birthdayGreetings/internal/clock"
+	"birthdayGreetings/internal/db"
+	h "birthdayGreetings/internal/handle"
+
+	"github.com/joho/godotenv"
+)
+
+// Симуляция расписания: прогоняет планировщик по копии данных за указанный период
+// и печатает все поздравления, напоминания и дайджесты, ничего не отправляя.
+// Запуск из каталога cmd (как и бот):
+//
+//	go run ./simulate -from 2025-01-01 -to 2026-01-01
+func main() {
+	from := flag.String("from", "", "начало периода, YYYY-MM-DD (по умолчанию - текущий час)")
+	to := flag.String("to", "", "конец периода, YYYY-MM-DD (по умолчанию - год от начала)")
+	step := flag.Duration("step", time.Hour, "шаг планировщика")
+	source := flag.String("source", "", "откуда взять данные: postgres или csv (по умолчанию - STORAGE из .env)")
+	flag.Parse()
+
+	if err := godotenv.Load("../configs/.env"); err != nil {
+		log.Fatal("Ошибка загрузки файла .env")
+	}
+
+	start := time.Now().Truncate(time.Hour)
+	if *from != "" {
+		var err error
+		if start, err = time.ParseInLocation(time.DateOnly, *from, time.Local); err != nil {
+			log.Fatalf("Невалидный -from: %s", err)
+		}
+	}
+
+	end := start.AddDate(1, 0, 0)
+	if *to != "" {
+		var err error
+		if end, err = time.ParseInLocation(time.DateOnly, *to, time.Local); err != nil {
+			log.Fatalf("Невалидный -to: %s", err)
+		}
+	}
+
+	if *source == "" {
+		*source = os.Getenv("STORAGE")
+	}
+	snapshot, err := loadSnapshot(*source)
+	if err != nil {
+		log.Fatalf("Ошибка загрузки данных: %s", err)
+	}
+
+	clk := clock.NewFake(start)
+	handle := h.NewHandle(db.NewMemoryDB(snapshot, clk), clk)
+
+	count, digests := 0, 0
+	err = handle.Simulate(end, *step, func(n db.Notification) {
+		count++
+		at := n.FireAt.In(n.Recipient.Location())
+		event := "День рождения"
+		switch n.Event {
+		case db.EventAnniversary:
+			event = "годовщина работы"
+		case db.EventCustom:
+			event = n.Custom.Title
+		}
+		switch n.Kind {
+		case db.KindGreeting:
+			fmt.Printf("%s  поздравление  %s: %s\n", at.Format("2006-01-02 15:04 MST"), fullName(n.Recipient), event)
+		case db.KindReminder:
+			if n.Event != db.EventCustom || n.Custom.EmployeeID.Valid {
+				event += " у " + fullName(n.Target)
+			}
+			fmt.Printf("%s  напоминание   %s: %s\n", at.Format("2006-01-02 15:04 MST"), fullName(n.Recipient), event)
+		}
+	}, func(d h.QueuedDigest) {
+		digests++
+		at := d.Digest.NextFireAt.In(d.Recipient.Location())
+		period, _, _ := strings.Cut(d.Text, "\n") // «Дни рождения с ... по ...:»
+		fmt.Printf("%s  дайджест      %s: %s\n", at.Format("2006-01-02 15:04 MST"), fullName(d.Recipient),
+			strings.TrimSuffix(period, ":"))
+	})
+	if err != nil {
+		log.Fatalf("Ошибка симуляции: %s", err)
+	}
+
+	fmt.Printf("\nС %s по %s сработало бы оповещаний: %d, дайджестов: %d\n",
+		start.Format(time.DateOnly), end.Format(time.DateOnly), count, digests)
+}
+
+// loadSnapshot копия данных из Postgres или из csv/employees.csv
+func loadSnapshot(source string) (db.Snapshot, error) {
+	if source == "memory" || source == "csv" {
+		return db.LoadCSV("../csv/employees.csv")
+	}
+
+	store := db.NewDB()
+	defer store.Close()
+
+	return store.Snapshot()
+}
+
+// fullName ФИО сотрудника
+func fullName(e db.Employee) string {
+	return fmt.Sprintf("%s %s %s", e.LastName, e.FirstName, e.Patronymic)
+}
@@ -75,6 +75,23 @@ func (t *TDlib) AddUserToGroup(groupID, userID int64) error {
 	return nil
 }
 
+// RemoveUserFromGroup удаляет пользователя из группы: в супергруппе это бан с немедленным
+// снятием, чтобы пользователя можно было снова добавить
+func (t *TDlib) RemoveUserFromGroup(groupID, userID int64) error {
+	member := &client.MessageSenderUser{UserId: userID}
+	_, err := t.client.BanChatMember(&client.BanChatMemberRequest{ChatId: groupID, MemberId: member})
+	if err != nil {
+		return fmt.Errorf("ошибка удаления участника %d из группы: %s", userID, err)
+	}
+
+	req := &client.SetChatMemberStatusRequest{ChatId: groupID, MemberId: member, Status: &client.ChatMemberStatusLeft{}}
+	if _, err = t.client.SetChatMemberStatus(req); err != nil {
+		return fmt.Errorf("ошибка снятия бана участника %d: %s", userID, err)
+	}
+
+	return nil
+}
+
 // GetGroup ищет группу (чат группы) в telegram
 func (t *TDlib) GetGroup(groupID int64) (*client.Chat, error) {
 	req := &client.GetChatRequest{ChatId: groupID}
@@ -0,0 +1,84 @@
+package calendar
+
+import (
+	"fmt"
+	"time"
+)
+
+// LeapPolicy когда праздновать 29 февраля в невисокосный год
+type LeapPolicy string
+
+const (
+	LeapFeb28 LeapPolicy = "feb28" // 28 февраля
+	LeapMar1  LeapPolicy = "mar1"  // 1 марта
+)
+
+// ParseLeapPolicy разберет правило для 29 февраля (пустая строка - LeapFeb28)
+func ParseLeapPolicy(s string) (LeapPolicy, error) {
+	switch policy := LeapPolicy(s); policy {
+	case "":
+		return LeapFeb28, nil
+	case LeapFeb28, LeapMar1:
+		return policy, nil
+	}
+
+	return "", fmt.Errorf("неизвестное правило для 29 февраля: %q (ожидается %s или %s)", s, LeapFeb28, LeapMar1)
+}
+
+// BirthdayIn начало Дня рождения (полночь) в указанном году в часовом поясе loc.
+// 29 февраля в невисокосный год переносится по правилу policy (так же считает birthday_in в Postgres)
+func BirthdayIn(birthDate time.Time, year int, loc *time.Location, policy LeapPolicy) time.Time {
+	month, day := birthDate.Month(), birthDate.Day()
+	if month == time.February && day == 29 && !IsLeap(year) {
+		if policy == LeapMar1 {
+			month, day = time.March, 1
+		} else {
+			day = 28
+		}
+	}
+
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// NextReminder ближайшее позже after время напоминания за lead до Дня рождения в часовом поясе loc.
+// Целые сутки lead отсчитываются по календарю, остаток - по часам на стене,
+// поэтому переход на летнее/зимнее время не сдвигает напоминание
+func NextReminder(birthDate time.Time, lead time.Duration, after time.Time, loc *time.Location, policy LeapPolicy) time.Time {
+	for year := after.In(loc).Year(); ; year++ {
+		if t := Before(BirthdayIn(birthDate, year, loc, policy), lead); t.After(after) {
+			return t
+		}
+	}
+}
+
+// Before момент за lead до t по календарю и часам на стене в часовом поясе t
+func Before(t time.Time, lead time.Duration) time.Time {
+	days := int(lead / (24 * time.Hour))
+	rest := lead % (24 * time.Hour)
+
+	return time.Date(t.Year(), t.Month(), t.Day()-days,
+		t.Hour()-int(rest/time.Hour), t.Minute()-int(rest%time.Hour/time.Minute),
+		t.Second()-int(rest%time.Minute/time.Second), t.Nanosecond()-int(rest%time.Second), t.Location())
+}
+
+// After момент через lead после t по календарю и часам на стене (обратное к Before)
+func After(t time.Time, lead time.Duration) time.Time {
+	return Before(t, -lead)
+}
+
+// NthWeekday полночь week-го дня недели weekday месяца month в часовом поясе loc
+// (week от 1; -1 - последний такой день месяца)
+func NthWeekday(year int, month time.Month, week int, weekday time.Weekday, loc *time.Location) time.Time {
+	if week < 0 {
+		last := time.Date(year, month+1, 0, 0, 0, 0, 0, loc)
+		return last.AddDate(0, 0, -(int(last.Weekday()-weekday)+7)%7)
+	}
+
+	first := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	return first.AddDate(0, 0, (int(weekday-first.Weekday())+7)%7+(week-1)*7)
+}
+
+// IsLeap високосный ли год
+func IsLeap(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
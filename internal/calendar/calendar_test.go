@@ -0,0 +1,136 @@
+package calendar
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // Europe/Berlin без системной базы часовых поясов
+)
+
+// Случаи для 29 февраля повторяют birthday_in из migrations/000006: Go и Postgres
+// должны переносить День рождения одинаково
+func TestBirthdayIn(t *testing.T) {
+	msk := time.FixedZone("MSK", 3*60*60)
+	leapDay := date(2000, time.February, 29, time.UTC)
+
+	tests := []struct {
+		name   string
+		birth  time.Time
+		year   int
+		loc    *time.Location
+		policy LeapPolicy
+		want   time.Time
+	}{
+		{"29.02 в високосный, feb28", leapDay, 2024, time.UTC, LeapFeb28, date(2024, time.February, 29, time.UTC)},
+		{"29.02 в високосный, mar1", leapDay, 2024, time.UTC, LeapMar1, date(2024, time.February, 29, time.UTC)},
+		{"29.02 в невисокосный, feb28", leapDay, 2025, time.UTC, LeapFeb28, date(2025, time.February, 28, time.UTC)},
+		{"29.02 в невисокосный, mar1", leapDay, 2025, time.UTC, LeapMar1, date(2025, time.March, 1, time.UTC)},
+		{"29.02 в 2100 (не високосный), mar1", leapDay, 2100, time.UTC, LeapMar1, date(2100, time.March, 1, time.UTC)},
+		{"29.02 в 2000 (високосный), feb28", leapDay, 2000, time.UTC, LeapFeb28, date(2000, time.February, 29, time.UTC)},
+		{"пустое правило - как feb28", leapDay, 2025, time.UTC, "", date(2025, time.February, 28, time.UTC)},
+		{"обычная дата не переносится", date(1990, time.March, 1, time.UTC), 2025, time.UTC, LeapMar1, date(2025, time.March, 1, time.UTC)},
+		{"полночь в часовом поясе loc", leapDay, 2025, msk, LeapMar1, date(2025, time.March, 1, msk)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := BirthdayIn(tt.birth, tt.year, tt.loc, tt.policy); !got.Equal(tt.want) {
+				t.Errorf("BirthdayIn(%s, %d, %s) = %s, want %s", tt.birth.Format("02.01.2006"), tt.year, tt.policy, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextReminder(t *testing.T) {
+	msk := time.FixedZone("MSK", 3*60*60)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leapDay := date(2000, time.February, 29, time.UTC)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name   string
+		birth  time.Time
+		lead   time.Duration
+		after  time.Time
+		loc    *time.Location
+		policy LeapPolicy
+		want   time.Time
+	}{
+		{"в день, невисокосный, feb28", leapDay, 0, date(2025, time.January, 1, time.UTC), time.UTC, LeapFeb28,
+			date(2025, time.February, 28, time.UTC)},
+		{"в день, невисокосный, mar1", leapDay, 0, date(2025, time.January, 1, time.UTC), time.UTC, LeapMar1,
+			date(2025, time.March, 1, time.UTC)},
+		{"в день, високосный", leapDay, 0, date(2027, time.March, 2, time.UTC), time.UTC, LeapMar1,
+			date(2028, time.February, 29, time.UTC)},
+		{"за день через границу февраля, mar1", leapDay, day, date(2025, time.January, 1, time.UTC), time.UTC, LeapMar1,
+			date(2025, time.February, 28, time.UTC)},
+		{"за день через границу февраля, feb28", leapDay, day, date(2025, time.January, 1, time.UTC), time.UTC, LeapFeb28,
+			date(2025, time.February, 27, time.UTC)},
+		{"за два дня до 1 марта, високосный", date(1990, time.March, 1, time.UTC), 2 * day,
+			date(2024, time.January, 1, time.UTC), time.UTC, LeapFeb28, date(2024, time.February, 28, time.UTC)},
+		{"за два дня до 1 марта, невисокосный", date(1990, time.March, 1, time.UTC), 2 * day,
+			date(2025, time.January, 1, time.UTC), time.UTC, LeapFeb28, date(2025, time.February, 27, time.UTC)},
+		{"уже прошло в этом году - в следующем", leapDay, 0, date(2025, time.March, 1, time.UTC), time.UTC, LeapMar1,
+			date(2026, time.March, 1, time.UTC)},
+		{"полночь в часовом поясе подписчика", date(1990, time.June, 15, time.UTC), 0,
+			time.Date(2025, time.June, 14, 20, 59, 0, 0, time.UTC), msk, LeapFeb28, date(2025, time.June, 15, msk)},
+		{"ровно в момент напоминания - уже следующее", date(1990, time.June, 15, time.UTC), 0,
+			time.Date(2025, time.June, 14, 21, 0, 0, 0, time.UTC), msk, LeapFeb28, date(2026, time.June, 15, msk)},
+		{"переход на летнее время не сдвигает часы на стене", date(1990, time.March, 31, time.UTC), 21 * time.Hour,
+			date(2025, time.January, 1, time.UTC), berlin, LeapFeb28, time.Date(2025, time.March, 30, 3, 0, 0, 0, berlin)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NextReminder(tt.birth, tt.lead, tt.after, tt.loc, tt.policy); !got.Equal(tt.want) {
+				t.Errorf("NextReminder() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBeforeAfter(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name string
+		t    time.Time
+		lead time.Duration
+		want time.Time // Before(t, lead); After(want, lead) должен вернуть t
+	}{
+		{"за день до 1 марта, невисокосный", date(2025, time.March, 1, time.UTC), day, date(2025, time.February, 28, time.UTC)},
+		{"за день до 1 марта, високосный", date(2024, time.March, 1, time.UTC), day, date(2024, time.February, 29, time.UTC)},
+		{"за неделю через границу месяца", date(2025, time.March, 3, time.UTC), 7 * day, date(2025, time.February, 24, time.UTC)},
+		{"дни и часы", date(2025, time.March, 1, time.UTC), 2*day - 10*time.Hour,
+			time.Date(2025, time.February, 27, 10, 0, 0, 0, time.UTC)},
+		{"отрицательное смещение - в день события", date(2025, time.March, 1, time.UTC), -9 * time.Hour,
+			time.Date(2025, time.March, 1, 9, 0, 0, 0, time.UTC)},
+		{"через переход на летнее время", date(2025, time.March, 31, berlin), 21 * time.Hour,
+			time.Date(2025, time.March, 30, 3, 0, 0, 0, berlin)},
+		{"целые сутки через переход на летнее время", date(2025, time.March, 31, berlin), day,
+			date(2025, time.March, 30, berlin)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Before(tt.t, tt.lead)
+			if !got.Equal(tt.want) {
+				t.Errorf("Before(%s, %s) = %s, want %s", tt.t, tt.lead, got, tt.want)
+			}
+			if back := After(got, tt.lead); !back.Equal(tt.t) {
+				t.Errorf("After(%s, %s) = %s, want %s", got, tt.lead, back, tt.t)
+			}
+		})
+	}
+}
+
+// date полночь дня в часовом поясе loc
+func date(year int, month time.Month, day int, loc *time.Location) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// BindingReason почему изменилась привязка telegram
+type BindingReason string
+
+const (
+	BindLogin    BindingReason = "login"    // первый вход, привязки еще не было
+	BindRebind   BindingReason = "rebind"   // вход с нового устройства, подтвержденный пользователем
+	BindReleased BindingReason = "released" // этот telegram ID привязали к другому сотруднику
+)
+
+// Binding запись аудита: смена telegram ID сотрудника
+type Binding struct {
+	EmployeeID    uuid.UUID     `json:"employee_id"`
+	OldTelegramID int64         `json:"old_telegram_id"`
+	NewTelegramID int64         `json:"new_telegram_id"`
+	Reason        BindingReason `json:"reason"`
+	ChangedAt     time.Time     `json:"changed_at"`
+}
+
+// BindingStore привязка сотрудников к telegram с аудитом каждого изменения
+type BindingStore interface {
+	BindTelegram(employeeID uuid.UUID, telegramID int64, reason BindingReason, at time.Time) (int64, error)
+	GetBindings(employeeID uuid.UUID) ([]Binding, error)
+}
+
+// BindTelegram в одной транзакции привяжет сотрудника к telegramID, отвяжет этот telegram ID
+// от других сотрудников и запишет все изменения в аудит. Вернет прежний telegram ID сотрудника
+func (d *DB) BindTelegram(employeeID uuid.UUID, telegramID int64, reason BindingReason, at time.Time) (int64, error) {
+	tx, err := d.dB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var previous int64
+	err = tx.QueryRow(
+		`SELECT COALESCE(e.telegram_id, 0) FROM employees e WHERE e.id = $1 FOR UPDATE`,
+		employeeID).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	if previous == telegramID {
+		return previous, nil
+	}
+
+	_, err = tx.Exec(
+		`WITH released AS (
+			UPDATE employees e SET telegram_id = 0, token = '', in_tg_group = FALSE
+			WHERE e.telegram_id = $1 AND e.id <> $2
+			RETURNING e.id)
+		INSERT INTO telegram_bindings (employee_id, old_telegram_id, new_telegram_id, reason, changed_at)
+		SELECT r.id, $1, 0, $3, $4 FROM released r`,
+		telegramID, employeeID, BindReleased, at)
+	if err != nil {
+		return 0, err
+	}
+
+	// новый аккаунт еще не в группе telegram - его добавит планировщик
+	_, err = tx.Exec(
+		`UPDATE employees e SET telegram_id = $1, in_tg_group = FALSE WHERE e.id = $2`,
+		telegramID, employeeID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO telegram_bindings (employee_id, old_telegram_id, new_telegram_id, reason, changed_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		employeeID, previous, telegramID, reason, at)
+	if err != nil {
+		return 0, err
+	}
+
+	return previous, tx.Commit()
+}
+
+// GetBindings история привязок сотрудника, последние - первыми
+func (d *DB) GetBindings(employeeID uuid.UUID) ([]Binding, error) {
+	rows, err := d.dB.Query(
+		`SELECT b.employee_id, b.old_telegram_id, b.new_telegram_id, b.reason, b.changed_at
+		FROM telegram_bindings b
+		WHERE b.employee_id = $1
+		ORDER BY b.changed_at DESC, b.id DESC`,
+		employeeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bindings []Binding
+	for rows.Next() {
+		var b Binding
+		if err = rows.Scan(&b.EmployeeID, &b.OldTelegramID, &b.NewTelegramID, &b.Reason, &b.ChangedAt); err != nil {
+			return nil, err
+		}
+		bindings = append(bindings, b)
+	}
+
+	return bindings, rows.Err()
+}
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"birthdayGreetings/internal/dialog"
+
+	"github.com/gofrs/uuid"
+)
+
+// GetConversation текущий диалог пользователя telegram (Idle, если его нет)
+func (d *DB) GetConversation(telegramID int64) (dialog.Conversation, error) {
+	c := dialog.Conversation{TelegramID: telegramID}
+	var employeeID uuid.NullUUID
+	err := d.dB.QueryRow(
+		`SELECT c.state, c.employee_id, c.data, c.expires_at
+		FROM conversations c
+		WHERE c.telegram_id = $1`,
+		telegramID).Scan(&c.State, &employeeID, &c.Data, &c.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, nil
+	}
+	c.EmployeeID = employeeID.UUID
+
+	return c, err
+}
+
+// SaveConversation создаст или заменит диалог пользователя
+func (d *DB) SaveConversation(c dialog.Conversation) error {
+	employeeID := uuid.NullUUID{UUID: c.EmployeeID, Valid: c.EmployeeID != uuid.Nil}
+	_, err := d.dB.Exec(
+		`INSERT INTO conversations (telegram_id, state, employee_id, data, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (telegram_id) DO UPDATE SET state = EXCLUDED.state,
+			employee_id = EXCLUDED.employee_id, data = EXCLUDED.data, expires_at = EXCLUDED.expires_at`,
+		c.TelegramID, c.State, employeeID, c.Data, c.ExpiresAt)
+
+	return err
+}
+
+// DeleteConversation завершит диалог пользователя
+func (d *DB) DeleteConversation(telegramID int64) error {
+	_, err := d.dB.Exec(`DELETE FROM conversations c WHERE c.telegram_id = $1`, telegramID)
+
+	return err
+}
@@ -2,39 +2,123 @@ package db
 
 import (
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"birthdayGreetings/internal/dialog"
+	"birthdayGreetings/internal/search"
+
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gofrs/uuid"
 	"github.com/golang-migrate/migrate"
 	"github.com/golang-migrate/migrate/database/postgres"
 	_ "github.com/golang-migrate/migrate/source/file"
-	tb "gopkg.in/telebot.v3"
 )
 
 const LIMIT = 10
 
 type Employee struct {
-	ID              uuid.UUID               `json:"id"`
-	TelegramID      int64                   `json:"telegram_id"`
-	Token           string                  `json:"token"`
-	FirstName       string                  `json:"first_name"`
-	Patronymic      string                  `json:"patronymic"`
-	LastName        string                  `json:"last_name"`
-	Email           string                  `json:"email"`
-	BirthDate       time.Time               `json:"birth_date"`
-	TempPassword    string                  `json:"temp_password"`
-	Subscribe       map[uuid.UUID]time.Time `json:"subscribe"`
-	WaitLogin       bool                    `json:"wait_login"`
-	WaitSubscribe   bool                    `json:"wait_subscribe"`
-	WaitUnsubscribe bool                    `json:"wait_unsubscribe"`
-	InTgGroup       bool                    `json:"in_tg_group"`
+	ID         uuid.UUID    `json:"id"`
+	TelegramID int64        `json:"telegram_id"`
+	Token      string       `json:"token"`
+	FirstName  string       `json:"first_name"`
+	Patronymic string       `json:"patronymic"`
+	LastName   string       `json:"last_name"`
+	Email      string       `json:"email"`
+	BirthDate  time.Time    `json:"birth_date"`
+	InTgGroup  bool         `json:"in_tg_group"`
+	Timezone   string       `json:"timezone"`
+	Role       Role         `json:"role"`
+	Status     Status       `json:"status"`
+	ExternalID string       `json:"external_id"` // идентификатор в HR-системе (пустой, если не из выгрузки)
+	HireDate   sql.NullTime `json:"hire_date"`   // дата приема на работу, если известна
+}
+
+// Location часовой пояс сотрудника (UTC, если не задан или неизвестен)
+func (e Employee) Location() *time.Location {
+	if e.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
+
+// employeeColumnNames колонки employees в порядке employeeFields
+var employeeColumnNames = []string{"id", "telegram_id", "token", "first_name", "patronymic", "last_name", "email",
+	"birth_date", "in_tg_group", "timezone", "role", "status", "external_id", "hire_date"}
+
+// employeeColumns колонки employees (алиас e) в порядке сканирования scanEmployee
+var employeeColumns = employeeColumnsAs("e")
+
+// employeeColumnsAs колонки employees с указанным алиасом таблицы
+func employeeColumnsAs(alias string) string {
+	columns := make([]string, len(employeeColumnNames))
+	for i, c := range employeeColumnNames {
+		columns[i] = alias + "." + c
+	}
+
+	return strings.Join(columns, ", ")
+}
+
+// employeeFields указатели на поля сотрудника в порядке employeeColumnNames
+func employeeFields(e *Employee) []interface{} {
+	return []interface{}{
+		&e.ID, &e.TelegramID, &e.Token, &e.FirstName, &e.Patronymic, &e.LastName, &e.Email,
+		&e.BirthDate, &e.InTgGroup, &e.Timezone, &e.Role, &e.Status, &e.ExternalID, &e.HireDate}
+}
+
+// scanner общий интерфейс *sql.Row и *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanEmployee читает сотрудника, выбранного через employeeColumns
+func scanEmployee(row scanner) (Employee, error) {
+	var e Employee
+	err := row.Scan(employeeFields(&e)...)
+
+	return e, err
+}
+
+// ErrNotFound сотрудник не найден
+var ErrNotFound = errors.New("сотрудник не найден")
+
+// EmployeeStore хранилище сотрудников
+type EmployeeStore interface {
+	GetEmployee(e Employee) (Employee, error)
+	GetPage(page int) ([]Employee, error)
+	GetCount() (int, error)
+	AddEmployee(e Employee) (Employee, error)
+	GetEmployees() ([]Employee, error)
+	ImportEmployees(im EmployeeImport) error
+	SearchEmployees(query string, limit int) ([]Employee, error)
+	PatchEmployee(id uuid.UUID, u EmployeeUpdate) error
+	SetStatus(id uuid.UUID, status Status) error
+	Close()
+}
+
+// Store хранилище сотрудников, их подписок и оповещений
+type Store interface {
+	EmployeeStore
+	SubscriptionStore
+	NotificationStore
+	OutboxStore
+	LoginStore
+	SessionStore
+	BindingStore
+	TeamStore
+	EventStore
+	DigestStore
+	dialog.Store
 }
 
 type DB struct {
@@ -68,12 +152,9 @@ func NewDB() DB {
 		log.Fatal("Ошибка migrate: ", err)
 	}
 
-	row := db.QueryRow("SELECT COUNT( * ) FROM employees")
-	var count int
-	if err := row.Scan(&count); err != nil {
-		if err := m.Up(); err != nil {
-			log.Fatal(err)
-		}
+	// Применяем новые миграции (в том числе к уже существующей базе)
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		log.Fatal(err)
 	}
 
 	return DB{dB: db}
@@ -83,86 +164,33 @@ func (d *DB) Close() {
 	d.dB.Close()
 }
 
-// AuthenticateUser проверит авторизацию пользователя
-func (d *DB) AuthenticateUser(c tb.Context, email string) (Employee, error) {
-	employee, err := d.GetEmployee(Employee{Email: email})
-	if err != nil {
-		return Employee{}, errors.New("email не найден")
+// PatchEmployee сохраняет в db заданные в обновлении поля
+func (d *DB) PatchEmployee(id uuid.UUID, u EmployeeUpdate) error {
+	if u.IsEmpty() {
+		return ErrEmptyUpdate
 	}
 
-	if err = JwtParse(employee.Token); err != nil {
-		employee.TelegramID = c.Sender().ID
-		if err := d.PatchEmployee(Employee{ID: employee.ID, TelegramID: employee.TelegramID}, "TelegramID"); err != nil {
-			log.Println(err)
-			return Employee{}, errors.New("ошибка аутентификации. Пожалуйста, повторите")
-		}
+	sets := make([]string, 0, len(u.fields))
+	args := make([]interface{}, 0, len(u.fields)+1)
+	for _, f := range u.fields {
+		args = append(args, f.value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", f.column, len(args)))
 	}
+	args = append(args, id)
 
-	if c.Sender().ID == employee.TelegramID {
-		return employee, nil
+	result, err := d.dB.Exec(
+		fmt.Sprintf(`UPDATE employees e SET %s
+		     WHERE e.id = $%d`, strings.Join(sets, ", "), len(args)), args...)
+	if err != nil {
+		return err
 	}
 
-	return Employee{}, errors.New("telegram ID не от вашей учетной записи")
-}
-
-// PatchEmployee сохраняет изменения в db
-func (d *DB) PatchEmployee(e Employee, field string) error {
-	if field == "TelegramID" {
-		_, err := d.dB.Exec(
-			`UPDATE employees e SET telegram_id = $1
-		     WHERE e.id = $2`, e.TelegramID, e.ID)
-		if err != nil {
-			return err
-		}
-	}
-	if field == "Token" {
-		_, err := d.dB.Exec(
-			`UPDATE employees e
-			 SET token = $1, wait_login = $2, wait_subscribe = $3, wait_unsubscribe = $4
-		     WHERE e.id = $5`, e.Token, e.WaitLogin, e.WaitSubscribe, e.WaitUnsubscribe, e.ID)
-		if err != nil {
-			return err
-		}
-	}
-	if field == "Wait" {
-		_, err := d.dB.Exec(
-			`UPDATE employees e
-			 SET wait_login = $1, wait_subscribe = $2, wait_unsubscribe = $3
-		     WHERE e.id = $4`, e.WaitLogin, e.WaitSubscribe, e.WaitUnsubscribe, e.ID)
-		if err != nil {
-			return err
-		}
-	}
-	if field == "InTgGroup" {
-		_, err := d.dB.Exec(
-			`UPDATE employees e SET in_tg_group = $1
-		     WHERE e.id = $2`, e.InTgGroup, e.ID)
-		if err != nil {
-			return err
-		}
-	}
-	if field == "TempPassword" {
-		_, err := d.dB.Exec(
-			`UPDATE employees e
-			 SET temp_password = $1, wait_login = $2, wait_subscribe = $3, wait_unsubscribe = $4
-		     WHERE e.id = $5`, e.TempPassword, e.WaitLogin, e.WaitSubscribe, e.WaitUnsubscribe, e.ID)
-		if err != nil {
-			return err
-		}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
 	}
-	if field == "Subscribe" {
-		var err error
-		var subscribeBytes []byte
-		if subscribeBytes, err = json.Marshal(&e.Subscribe); err != nil {
-			return err
-		}
-		_, err = d.dB.Exec(
-			`UPDATE employees e
-			 SET subscribe = $1, wait_login = $2, wait_subscribe = $3, wait_unsubscribe = $4
-		     WHERE e.id = $5`, subscribeBytes, e.WaitLogin, e.WaitSubscribe, e.WaitUnsubscribe, e.ID)
-		if err != nil {
-			return err
-		}
+	if count == 0 {
+		return ErrNotFound
 	}
 
 	return nil
@@ -173,30 +201,21 @@ func (d *DB) GetPage(page int) ([]Employee, error) {
 	offset := page * LIMIT
 
 	rows, err := d.dB.Query(
-		`SELECT * 
+		`SELECT `+employeeColumns+`
 		FROM employees e
+		WHERE e.status <> 'terminated'
+		ORDER BY e.last_name, e.first_name, e.id
 		LIMIT $1 OFFSET $2`,
 		LIMIT, offset)
 
 	var employees []Employee
 	if err == nil {
+		defer rows.Close()
 		for rows.Next() {
-			var e Employee
-			var subscribeBytes []byte
-
-			err = rows.Scan(
-				&e.ID, &e.TelegramID, &e.Token, &e.FirstName, &e.Patronymic, &e.LastName, &e.Email, &e.BirthDate,
-				&e.TempPassword, &subscribeBytes, &e.WaitLogin, &e.WaitSubscribe, &e.WaitUnsubscribe, &e.InTgGroup)
-
+			e, err := scanEmployee(rows)
 			if err != nil {
 				return []Employee{}, err
 			}
-
-			if len(subscribeBytes) > 0 {
-				if err := json.Unmarshal(subscribeBytes, &e.Subscribe); err != nil {
-					return []Employee{}, err
-				}
-			}
 			employees = append(employees, e)
 		}
 		if err = rows.Err(); err != nil {
@@ -212,12 +231,49 @@ func (d *DB) GetPage(page int) ([]Employee, error) {
 // GetCount возвращает количество пользователей (строк)
 func (d *DB) GetCount() (int, error) {
 	var count int
-	err := d.dB.QueryRow("SELECT COUNT( * ) FROM employees").Scan(&count)
+	err := d.dB.QueryRow("SELECT COUNT( * ) FROM employees WHERE status <> 'terminated'").Scan(&count)
 
 	return count, err
 }
 
-// GetEmployee извлекает данные одного пользователя
+// SearchEmployees нечеткий поиск по ФИО и email: кириллица и латиница равнозначны,
+// опечатки допускаются (триграммы pg_trgm), самые похожие - первыми
+func (d *DB) SearchEmployees(query string, limit int) ([]Employee, error) {
+	query = search.Normalize(query)
+
+	tx, err := d.dB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// порог похожести только для этой транзакции
+	_, err = tx.Exec(`SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`,
+		strconv.FormatFloat(search.Threshold, 'f', -1, 64))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(
+		`SELECT `+employeeColumns+`
+		FROM employees e
+		WHERE $1 <% e.search_text AND e.status <> 'terminated'
+		ORDER BY word_similarity($1, e.search_text) DESC, e.last_name, e.first_name
+		LIMIT $2`,
+		query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	employees, err := readEmployees(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return employees, tx.Commit()
+}
+
+// GetEmployee извлекает данные одного пользователя (email сравнивается без учета регистра)
 func (d *DB) GetEmployee(e Employee) (Employee, error) {
 	var err error
 	var rows *sql.Rows
@@ -225,49 +281,167 @@ func (d *DB) GetEmployee(e Employee) (Employee, error) {
 	switch {
 	case e.Email != "":
 		rows, err = d.dB.Query(
-			`SELECT *
+			`SELECT `+employeeColumns+`
 			FROM employees e
-			WHERE e.email = $1`,
+			WHERE lower(e.email) = lower($1)`,
 			e.Email)
 	case e.TelegramID != 0:
 		rows, err = d.dB.Query(
-			`SELECT *
+			`SELECT `+employeeColumns+`
 			FROM employees e
 			WHERE e.telegram_id = $1`,
 			e.TelegramID)
 	case e.ID != uuid.Nil:
 		rows, err = d.dB.Query(
-			`SELECT *
+			`SELECT `+employeeColumns+`
 				FROM employees e
 				WHERE e.id = $1`,
 			e.ID)
+	default:
+		return e, ErrNotFound
 	}
 
 	if err == nil {
-		rows.Next()
-		var subscribeBytes []byte
+		defer rows.Close()
+		if !rows.Next() {
+			return e, ErrNotFound
+		}
+		return scanEmployee(rows)
+	}
 
-		err = rows.Scan(
-			&e.ID, &e.TelegramID, &e.Token, &e.FirstName, &e.Patronymic, &e.LastName, &e.Email, &e.BirthDate,
-			&e.TempPassword, &subscribeBytes, &e.WaitLogin, &e.WaitSubscribe, &e.WaitUnsubscribe, &e.InTgGroup)
+	return e, err
+}
 
-		if len(subscribeBytes) > 0 {
-			if err := json.Unmarshal(subscribeBytes, &e.Subscribe); err != nil {
-				return e, err
-			}
+// AddEmployee добавит сотрудника (ID, часовой пояс и роль по умолчанию, если не заданы)
+func (d *DB) AddEmployee(e Employee) (Employee, error) {
+	e = newEmployee(e)
+
+	return e, insertEmployee(d.dB, e)
+}
+
+// execer общий интерфейс *sql.DB и *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertEmployee вставит сотрудника, подготовленного newEmployee
+func insertEmployee(x execer, e Employee) error {
+	_, err := x.Exec(
+		`INSERT INTO employees (id, telegram_id, token, first_name, patronymic, last_name, email,
+			birth_date, in_tg_group, timezone, role, status, external_id, hire_date)
+		VALUES ($1, 0, '', $2, $3, $4, $5, $6, FALSE, $7, $8, $9, $10, $11)`,
+		e.ID, e.FirstName, e.Patronymic, e.LastName, e.Email, e.BirthDate, e.Timezone, e.Role, e.Status, e.ExternalID,
+		e.HireDate)
+
+	return err
+}
+
+// newEmployee заполнит у нового сотрудника незаданные поля
+func newEmployee(e Employee) Employee {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.Must(uuid.NewV4())
+	}
+	if e.Timezone == "" {
+		e.Timezone = "UTC"
+	}
+	if e.Role == "" {
+		e.Role = RoleEmployee
+	}
+	if e.Status == "" {
+		e.Status = StatusActive
+	}
+	e.TelegramID, e.Token, e.InTgGroup = 0, "", false
+
+	return e
+}
+
+// queryEmployees выполнит запрос и прочитает список сотрудников
+func (d *DB) queryEmployees(query string, args ...interface{}) ([]Employee, error) {
+	rows, err := d.dB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return readEmployees(rows)
+}
+
+// readEmployees прочитает и закроет выборку сотрудников
+func readEmployees(rows *sql.Rows) ([]Employee, error) {
+	defer rows.Close()
+
+	var employees []Employee
+	for rows.Next() {
+		e, err := scanEmployee(rows)
+		if err != nil {
+			return nil, err
 		}
+		employees = append(employees, e)
+	}
+
+	return employees, rows.Err()
+}
 
+// Snapshot копия сотрудников, подписок, команд, событий и дайджестов (например, для симуляции в MemoryDB)
+func (d *DB) Snapshot() (Snapshot, error) {
+	var snapshot Snapshot
+	var err error
+
+	snapshot.Employees, err = d.queryEmployees(`SELECT ` + employeeColumns + ` FROM employees e`)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	snapshot.Subscriptions, err = d.querySubscriptions(`SELECT ` + subscriptionColumns + ` FROM subscriptions s`)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if snapshot.Teams, err = d.GetTeams(); err != nil {
+		return Snapshot{}, err
+	}
+	if snapshot.TeamMembers, err = d.teamMembers(); err != nil {
+		return Snapshot{}, err
+	}
+	snapshot.TeamSubscriptions, err = d.queryTeamSubscriptions(
+		`SELECT ts.subscriber_id, ts.team_id, ts.lead_seconds FROM team_subscriptions ts`)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if snapshot.Events, err = d.queryEvents(`SELECT ` + eventColumns + ` FROM events ev`); err != nil {
+		return Snapshot{}, err
+	}
+	snapshot.EventSubscriptions, err = d.queryEventSubscriptions(
+		`SELECT ` + eventSubscriptionColumns + ` FROM event_subscriptions s JOIN events ev ON ev.id = s.event_id`)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if snapshot.Digests, err = d.queryDigests(`SELECT ` + digestColumns + ` FROM digests dg`); err != nil {
+		return Snapshot{}, err
 	}
 
-	return e, err
+	return snapshot, nil
+}
+
+// Claims содержимое JWT-токена: кому и в какой telegram выдан, ID сессии (jti) и срок действия
+type Claims struct {
+	EmployeeID uuid.UUID `json:"employee_id"`
+	TelegramID int64     `json:"telegram_id"`
+	jwt.StandardClaims
 }
 
-// GenerateJWTToken генерирует токен для авторизации
-func GenerateJWTToken(id uuid.UUID) (string, error) {
-	// Установка claims для JWT-токена
-	claims := jwt.MapClaims{
-		"employee_id": id,
-		"exp":         time.Now().Add(time.Hour * 24).Unix(), // Токен истекает через 24 часа
+// SessionID ID сессии, на которую выдан токен
+func (c Claims) SessionID() (uuid.UUID, error) {
+	return uuid.FromString(c.Id)
+}
+
+// GenerateJWTToken генерирует токен для авторизации сессии s
+func GenerateJWTToken(s Session) (string, error) {
+	claims := Claims{
+		EmployeeID: s.EmployeeID,
+		TelegramID: s.TelegramID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        s.ID.String(),
+			IssuedAt:  s.IssuedAt.Unix(),
+			ExpiresAt: s.ExpiresAt.Unix(),
+		},
 	}
 
 	// Создает и подписывает JWT-токен
@@ -280,19 +454,22 @@ func GenerateJWTToken(id uuid.UUID) (string, error) {
 	return signedToken, nil
 }
 
-// JwtParse проверит токен пользователя
-func JwtParse(jwtToken string) error {
-	token, err := jwt.Parse(jwtToken, func(token *jwt.Token) (interface{}, error) {
+// JwtParse проверит подпись и срок токена на момент now и вернет его claims
+func JwtParse(jwtToken string, now time.Time) (Claims, error) {
+	var claims Claims
+	// срок проверяем сами: jwt-go сверяет его с настоящим временем, а не с часами Handle
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(jwtToken, &claims, func(token *jwt.Token) (interface{}, error) {
 		// Проверит подпись токена
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("неверный метод подписи токена: %v", token.Header["alg"])
 		}
 		return []byte(os.Getenv("SECRET")), nil
 	})
-	if err != nil || !token.Valid {
+	if err != nil || !token.Valid || !claims.VerifyExpiresAt(now.Unix(), true) || !claims.VerifyIssuedAt(now.Unix(), false) {
 		// Отклонит сообщение, если токен недействителен
-		return errors.New("токен недействителен")
+		return Claims{}, errors.New("токен недействителен")
 	}
 
-	return nil
+	return claims, nil
 }
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq"
+)
+
+// DigestSchedule как часто приходит дайджест
+type DigestSchedule string
+
+const (
+	DigestWeekly  DigestSchedule = "weekly"  // по понедельникам
+	DigestMonthly DigestSchedule = "monthly" // 1-го числа
+)
+
+// DigestScope чьи Дни рождения попадают в дайджест
+type DigestScope string
+
+const (
+	DigestSubscriptions DigestScope = "subscriptions" // сотрудники, на которых подписан получатель
+	DigestCompany       DigestScope = "company"       // вся компания
+	DigestTeam          DigestScope = "team"          // участники одной команды
+)
+
+// ErrDigestNotFound дайджест не настроен
+var ErrDigestNotFound = errors.New("дайджест не настроен")
+
+// Digest настройки дайджеста сотрудника: одно сообщение со списком предстоящих Дней рождения
+// вместо (или вместе с) оповещаний по каждому. Дайджест у сотрудника один
+type Digest struct {
+	EmployeeID uuid.UUID      `json:"employee_id"`
+	Schedule   DigestSchedule `json:"schedule"`
+	Scope      DigestScope    `json:"scope"`
+	TeamID     uuid.NullUUID  `json:"team_id"` // для DigestTeam
+	NextFireAt time.Time      `json:"next_fire_at"`
+}
+
+// DigestStore хранилище настроек дайджеста
+type DigestStore interface {
+	SetDigest(d Digest) error
+	GetDigest(employeeID uuid.UUID) (Digest, error)
+	RemoveDigest(employeeID uuid.UUID) error
+	GetDueDigests(to time.Time) ([]Digest, error)
+	EnqueueDigest(m OutboxMessage, advance Digest) error
+}
+
+// digestColumns колонки digests в порядке сканирования scanDigest
+const digestColumns = `dg.employee_id, dg.schedule, dg.scope, dg.team_id, dg.next_fire_at`
+
+// scanDigest читает дайджест, выбранный через digestColumns
+func scanDigest(row scanner) (Digest, error) {
+	var d Digest
+	err := row.Scan(&d.EmployeeID, &d.Schedule, &d.Scope, &d.TeamID, &d.NextFireAt)
+
+	return d, err
+}
+
+// SetDigest включит дайджест или изменит его настройки
+func (d *DB) SetDigest(dg Digest) error {
+	_, err := d.dB.Exec(
+		`INSERT INTO digests (employee_id, schedule, scope, team_id, next_fire_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (employee_id) DO UPDATE SET schedule = EXCLUDED.schedule, scope = EXCLUDED.scope,
+			team_id = EXCLUDED.team_id, next_fire_at = EXCLUDED.next_fire_at`,
+		dg.EmployeeID, dg.Schedule, dg.Scope, dg.TeamID, dg.NextFireAt)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation" {
+		return ErrTeamNotFound
+	}
+
+	return err
+}
+
+// GetDigest настройки дайджеста сотрудника
+func (d *DB) GetDigest(employeeID uuid.UUID) (Digest, error) {
+	dg, err := scanDigest(d.dB.QueryRow(
+		`SELECT `+digestColumns+` FROM digests dg WHERE dg.employee_id = $1`, employeeID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Digest{}, ErrDigestNotFound
+	}
+
+	return dg, err
+}
+
+// RemoveDigest выключит дайджест
+func (d *DB) RemoveDigest(employeeID uuid.UUID) error {
+	result, err := d.dB.Exec(`DELETE FROM digests dg WHERE dg.employee_id = $1`, employeeID)
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return ErrDigestNotFound
+	}
+
+	return nil
+}
+
+// GetDueDigests дайджесты, время которых наступило раньше to (в том числе пропущенные).
+// Как и напоминания, получают только работающие (не в отпуске)
+func (d *DB) GetDueDigests(to time.Time) ([]Digest, error) {
+	return d.queryDigests(
+		`SELECT `+digestColumns+`
+		FROM digests dg
+		JOIN employees e ON e.id = dg.employee_id
+		WHERE dg.next_fire_at < $1 AND e.status = 'active'
+		ORDER BY dg.next_fire_at`,
+		to)
+}
+
+// queryDigests выполняет запрос, выбирающий digestColumns
+func (d *DB) queryDigests(query string, args ...interface{}) ([]Digest, error) {
+	rows, err := d.dB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var digests []Digest
+	for rows.Next() {
+		dg, err := scanDigest(rows)
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, dg)
+	}
+
+	return digests, rows.Err()
+}
+
+// EnqueueDigest в одной транзакции ставит дайджест в очередь (повтор с тем же DedupKey
+// игнорируется) и переносит время следующего на advance.NextFireAt
+func (d *DB) EnqueueDigest(m OutboxMessage, advance Digest) error {
+	tx, err := d.dB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = insertOutbox(tx, m); err != nil {
+		return err
+	}
+	result, err := tx.Exec(`UPDATE digests dg SET next_fire_at = $1 WHERE dg.employee_id = $2`,
+		advance.NextFireAt, advance.EmployeeID)
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return ErrDigestNotFound
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,327 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"birthdayGreetings/internal/calendar"
+
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq"
+)
+
+// EventKind событие сотрудника, о котором оповещают подписчиков
+type EventKind string
+
+const (
+	EventBirthday    EventKind = "birthday"    // День рождения (birth_date)
+	EventAnniversary EventKind = "anniversary" // годовщина работы в компании (hire_date), с первой
+	EventCustom      EventKind = "custom"      // произвольное событие из events (см. CustomEvent)
+)
+
+// EventDate дата события сотрудника (false, если дата приема неизвестна)
+func (e Employee) EventDate(kind EventKind) (time.Time, bool) {
+	if kind == EventAnniversary {
+		return e.HireDate.Time, e.HireDate.Valid
+	}
+
+	return e.BirthDate, true
+}
+
+// EventRule правило повторения произвольного события
+type EventRule string
+
+const (
+	RuleYearly  EventRule = "yearly"  // ежегодно в день Date
+	RuleOnce    EventRule = "once"    // однократно в день Date
+	RuleWeekday EventRule = "weekday" // ежегодно в Week-й Weekday месяца Month
+)
+
+// ErrEventNotFound событие не найдено
+var ErrEventNotFound = errors.New("событие не найдено")
+
+// CustomEvent произвольное событие сотрудника (свадьба, именины) или всей компании (праздник):
+// оповещения о нем идут так же, как о Днях рождения
+type CustomEvent struct {
+	ID         uuid.UUID     `json:"id"`
+	EmployeeID uuid.NullUUID `json:"employee_id"` // чье событие (пусто - всей компании)
+	Title      string        `json:"title"`
+	Rule       EventRule     `json:"rule"`
+	Date       time.Time     `json:"date"`    // для RuleYearly и RuleOnce
+	Month      time.Month    `json:"month"`   // для RuleWeekday
+	Week       int           `json:"week"`    // для RuleWeekday: 1-4 или -1 (последний)
+	Weekday    time.Weekday  `json:"weekday"` // для RuleWeekday
+}
+
+// In полночь события в году year в часовом поясе loc (false, если в этом году его нет).
+// Так же считает event_in в Postgres
+func (e CustomEvent) In(year int, loc *time.Location, policy calendar.LeapPolicy) (time.Time, bool) {
+	switch e.Rule {
+	case RuleOnce:
+		return time.Date(year, e.Date.Month(), e.Date.Day(), 0, 0, 0, 0, loc), year == e.Date.Year()
+	case RuleWeekday:
+		return calendar.NthWeekday(year, e.Month, e.Week, e.Weekday, loc), true
+	default:
+		return calendar.BirthdayIn(e.Date, year, loc, policy), true
+	}
+}
+
+// EventStore хранилище произвольных событий и подписок на них. Подписка на событие -
+// Subscription с Event = EventCustom и EventID (TargetID - сотрудник события или uuid.Nil)
+type EventStore interface {
+	CreateEvent(e CustomEvent) (CustomEvent, error)
+	GetEvent(id uuid.UUID) (CustomEvent, error)
+	GetEvents() ([]CustomEvent, error)
+	DeleteEvent(id uuid.UUID) error
+	AddEventSubscription(s Subscription) error
+	RemoveEventSubscription(subscriberID, eventID uuid.UUID) error
+	RemoveEventSubscriptionLead(subscriberID, eventID uuid.UUID, lead time.Duration) error
+	GetEventSubscriptions(subscriberID uuid.UUID) ([]Subscription, error)
+	SetEventNextFireAt(s Subscription) error
+}
+
+// eventColumns колонки events в порядке сканирования scanEvent
+const eventColumns = `ev.id, ev.employee_id, ev.title, ev.rule, ev.day, ev.month, ev.week, ev.weekday`
+
+// scanEvent читает событие, выбранное через eventColumns
+func scanEvent(row scanner) (CustomEvent, error) {
+	var e CustomEvent
+	var day sql.NullTime
+	err := row.Scan(&e.ID, &e.EmployeeID, &e.Title, &e.Rule, &day, &e.Month, &e.Week, &e.Weekday)
+	e.Date = day.Time
+
+	return e, err
+}
+
+// CreateEvent создаст событие (ErrNotFound, если нет сотрудника EmployeeID)
+func (d *DB) CreateEvent(e CustomEvent) (CustomEvent, error) {
+	e.ID = uuid.Must(uuid.NewV4())
+
+	_, err := d.dB.Exec(
+		`INSERT INTO events (id, employee_id, title, rule, day, month, week, weekday)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		e.ID, e.EmployeeID, e.Title, e.Rule, sql.NullTime{Time: e.Date, Valid: e.Rule != RuleWeekday},
+		int(e.Month), e.Week, int(e.Weekday))
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation" {
+		return CustomEvent{}, ErrNotFound
+	}
+
+	return e, err
+}
+
+// GetEvent найдет событие по ID
+func (d *DB) GetEvent(id uuid.UUID) (CustomEvent, error) {
+	e, err := scanEvent(d.dB.QueryRow(`SELECT `+eventColumns+` FROM events ev WHERE ev.id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return e, ErrEventNotFound
+	}
+
+	return e, err
+}
+
+// GetEvents события компании и неуволенных сотрудников: сначала общие, затем по названию
+func (d *DB) GetEvents() ([]CustomEvent, error) {
+	return d.queryEvents(
+		`SELECT ` + eventColumns + `
+		FROM events ev
+		LEFT JOIN employees e ON e.id = ev.employee_id
+		WHERE e.status IS DISTINCT FROM 'terminated'
+		ORDER BY ev.employee_id IS NOT NULL, ev.title`)
+}
+
+// queryEvents выполнит запрос и прочитает события, выбранные через eventColumns
+func (d *DB) queryEvents(query string, args ...interface{}) ([]CustomEvent, error) {
+	rows, err := d.dB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []CustomEvent
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// DeleteEvent удалит событие вместе с подписками на него
+func (d *DB) DeleteEvent(id uuid.UUID) error {
+	result, err := d.dB.Exec(`DELETE FROM events ev WHERE ev.id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return ErrEventNotFound
+	}
+
+	return nil
+}
+
+// AddEventSubscription добавит к подписке на событие оповещание за s.LeadTime или обновит его время
+func (d *DB) AddEventSubscription(s Subscription) error {
+	_, err := d.dB.Exec(
+		`INSERT INTO event_subscriptions (subscriber_id, event_id, lead_seconds, next_fire_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (subscriber_id, event_id, lead_seconds)
+		DO UPDATE SET next_fire_at = EXCLUDED.next_fire_at`,
+		s.SubscriberID, s.EventID.UUID, int64(s.LeadTime/time.Second), s.NextFireAt)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation" {
+		return ErrEventNotFound
+	}
+
+	return err
+}
+
+// RemoveEventSubscription удаляет подписку на событие со всеми ее оповещаниями
+func (d *DB) RemoveEventSubscription(subscriberID, eventID uuid.UUID) error {
+	return d.removeSubscriptions(
+		`DELETE FROM event_subscriptions s WHERE s.subscriber_id = $1 AND s.event_id = $2`,
+		subscriberID, eventID)
+}
+
+// RemoveEventSubscriptionLead удаляет одно оповещание подписки на событие
+func (d *DB) RemoveEventSubscriptionLead(subscriberID, eventID uuid.UUID, lead time.Duration) error {
+	return d.removeSubscriptions(
+		`DELETE FROM event_subscriptions s WHERE s.subscriber_id = $1 AND s.event_id = $2 AND s.lead_seconds = $3`,
+		subscriberID, eventID, int64(lead/time.Second))
+}
+
+// eventSubscriptionColumns колонки event_subscriptions (с владельцем события из events ev)
+// в порядке сканирования queryEventSubscriptions
+const eventSubscriptionColumns = `s.subscriber_id, ev.employee_id, s.event_id, s.lead_seconds, s.next_fire_at`
+
+// GetEventSubscriptions подписки сотрудника на события, по времени оповещания
+func (d *DB) GetEventSubscriptions(subscriberID uuid.UUID) ([]Subscription, error) {
+	return d.queryEventSubscriptions(
+		`SELECT `+eventSubscriptionColumns+`
+		FROM event_subscriptions s
+		JOIN events ev ON ev.id = s.event_id
+		WHERE s.subscriber_id = $1
+		ORDER BY s.next_fire_at`,
+		subscriberID)
+}
+
+// queryEventSubscriptions выполнит запрос и прочитает подписки на события, выбранные через eventSubscriptionColumns
+func (d *DB) queryEventSubscriptions(query string, args ...interface{}) ([]Subscription, error) {
+	rows, err := d.dB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []Subscription
+	for rows.Next() {
+		s := Subscription{Event: EventCustom}
+		var targetID uuid.NullUUID
+		var leadSeconds int64
+		if err = rows.Scan(&s.SubscriberID, &targetID, &s.EventID, &leadSeconds, &s.NextFireAt); err != nil {
+			return nil, err
+		}
+		s.TargetID, s.LeadTime = targetID.UUID, time.Duration(leadSeconds)*time.Second
+		subscriptions = append(subscriptions, s)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+// SetEventNextFireAt переносит на s.NextFireAt время оповещания s.LeadTime по подписке на событие
+func (d *DB) SetEventNextFireAt(s Subscription) error {
+	result, err := d.dB.Exec(
+		`UPDATE event_subscriptions s SET next_fire_at = $1
+		WHERE s.subscriber_id = $2 AND s.event_id = $3 AND s.lead_seconds = $4`,
+		s.NextFireAt, s.SubscriberID, s.EventID.UUID, int64(s.LeadTime/time.Second))
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return ErrSubscriptionNotFound
+	}
+
+	return nil
+}
+
+// dueEventNotifications оповещания о произвольных событиях для GetDueNotifications: напоминания
+// по подпискам и поздравления в полночь события (сотруднику события или, для событий компании,
+// всем, кто пользуется подписками)
+func (d *DB) dueEventNotifications(from, to time.Time, policy calendar.LeapPolicy) ([]Notification, error) {
+	rows, err := d.dB.Query(
+		`SELECT 'reminder', s.next_fire_at, s.lead_seconds, `+eventColumns+`, `+employeeColumnsAs("r")+`
+		FROM event_subscriptions s
+		JOIN events ev ON ev.id = s.event_id
+		JOIN employees r ON r.id = s.subscriber_id
+		LEFT JOIN employees t ON t.id = ev.employee_id
+		WHERE s.next_fire_at < $2::TIMESTAMPTZ AND r.status = 'active' AND t.status IS DISTINCT FROM 'terminated'
+		UNION ALL
+		SELECT 'greeting', g.fire_at, 0, `+eventColumns+`, `+employeeColumnsAs("e")+`
+		FROM events ev
+		JOIN employees e ON e.id = ev.employee_id OR ev.employee_id IS NULL
+		CROSS JOIN LATERAL (
+			SELECT event_in(ev.rule, ev.day, ev.month, ev.week, ev.weekday, y, $3)::TIMESTAMP
+				AT TIME ZONE e.timezone AS fire_at
+			FROM generate_series(
+				EXTRACT(YEAR FROM $1::TIMESTAMPTZ AT TIME ZONE 'UTC')::INT - 1,
+				EXTRACT(YEAR FROM $2::TIMESTAMPTZ AT TIME ZONE 'UTC')::INT + 1) y
+		) g
+		WHERE g.fire_at >= $1::TIMESTAMPTZ AND g.fire_at < $2::TIMESTAMPTZ
+			AND e.status <> 'terminated'
+			AND EXISTS (SELECT 1 FROM subscriptions s WHERE s.subscriber_id = e.id)`,
+		from, to, string(policy))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		n := Notification{Event: EventCustom}
+		var leadSeconds int64
+		var day sql.NullTime
+		fields := []interface{}{&n.Kind, &n.FireAt, &leadSeconds, &n.Custom.ID, &n.Custom.EmployeeID, &n.Custom.Title,
+			&n.Custom.Rule, &day, &n.Custom.Month, &n.Custom.Week, &n.Custom.Weekday}
+		if err = rows.Scan(append(fields, employeeFields(&n.Recipient)...)...); err != nil {
+			return nil, err
+		}
+		n.Custom.Date, n.LeadTime = day.Time, time.Duration(leadSeconds)*time.Second
+		notifications = append(notifications, n)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// сотрудник события: у поздравлений это сам получатель
+	for i, n := range notifications {
+		switch {
+		case !n.Custom.EmployeeID.Valid:
+		case n.Kind == KindGreeting:
+			notifications[i].Target = n.Recipient
+		default:
+			if notifications[i].Target, err = d.GetEmployee(Employee{ID: n.Custom.EmployeeID.UUID}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return notifications, nil
+}
+
+// sortNotifications упорядочит оповещания по времени
+func sortNotifications(notifications []Notification) {
+	sort.SliceStable(notifications, func(i, j int) bool {
+		return notifications[i].FireAt.Before(notifications[j].FireAt)
+	})
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq"
+)
+
+// EmployeeImport изменения сотрудников из таблицы отдела кадров
+type EmployeeImport struct {
+	Add        []Employee  // новые сотрудники
+	Update     []Employee  // ФИО, email, даты рождения и приема, статус и внешний ID по ID
+	Deactivate []uuid.UUID // сотрудники, которых нет в таблице: увольняются (см. StatusTerminated)
+}
+
+// ImportEmployees применит импорт в одной транзакции: или все изменения, или ничего.
+// Повторный импорт тех же изменений ничего не меняет
+func (d *DB) ImportEmployees(im EmployeeImport) error {
+	tx, err := d.dB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, e := range im.Add {
+		if err = insertEmployee(tx, newEmployee(e)); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range im.Update {
+		result, err := tx.Exec(
+			`UPDATE employees e SET first_name = $1, patronymic = $2, last_name = $3, email = $4,
+				birth_date = $5, status = $6, external_id = $7, hire_date = $8
+			WHERE e.id = $9`,
+			e.FirstName, e.Patronymic, e.LastName, e.Email, e.BirthDate, e.Status, e.ExternalID, e.HireDate, e.ID)
+		if err != nil {
+			return err
+		}
+		if count, err := result.RowsAffected(); err != nil {
+			return err
+		} else if count == 0 {
+			return ErrNotFound
+		}
+	}
+
+	if err = terminate(tx, im.Deactivate); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetStatus сменит статус сотрудника; при увольнении в той же транзакции удалит его подписки
+// и подписки на него
+func (d *DB) SetStatus(id uuid.UUID, status Status) error {
+	if status == StatusTerminated {
+		tx, err := d.dB.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err = terminate(tx, []uuid.UUID{id}); err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	}
+
+	result, err := d.dB.Exec(`UPDATE employees e SET status = $1 WHERE e.id = $2`, status, id)
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// terminate уволит сотрудников: уволенный не получает напоминаний и о нем больше не напоминают
+func terminate(x execer, ids []uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	array := pq.Array(uuidStrings(ids))
+	result, err := x.Exec(`UPDATE employees e SET status = 'terminated' WHERE e.id = ANY($1)`, array)
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return ErrNotFound
+	}
+	_, err = x.Exec(`DELETE FROM subscriptions s WHERE s.subscriber_id = ANY($1) OR s.target_id = ANY($1)`, array)
+	if err != nil {
+		return err
+	}
+	_, err = x.Exec(
+		`DELETE FROM event_subscriptions s
+		WHERE s.subscriber_id = ANY($1) OR s.event_id IN (SELECT ev.id FROM events ev WHERE ev.employee_id = ANY($1))`,
+		array)
+	if err != nil {
+		return err
+	}
+	// в командах уволенный остается (вернется - снова попадет в подписки на команду), свои подписки на команды теряет
+	_, err = x.Exec(`DELETE FROM team_subscriptions ts WHERE ts.subscriber_id = ANY($1)`, array)
+	if err != nil {
+		return err
+	}
+	_, err = x.Exec(`DELETE FROM digests dg WHERE dg.employee_id = ANY($1)`, array)
+
+	return err
+}
+
+// GetEmployees все сотрудники
+func (d *DB) GetEmployees() ([]Employee, error) {
+	return d.queryEmployees(`SELECT ` + employeeColumns + ` FROM employees e ORDER BY e.last_name, e.first_name, e.id`)
+}
+
+// uuidStrings uuid строками (для pq.Array)
+func uuidStrings(ids []uuid.UUID) []string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = id.String()
+	}
+
+	return s
+}
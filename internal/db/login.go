@@ -0,0 +1,80 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"birthdayGreetings/internal/otp"
+
+	"github.com/gofrs/uuid"
+)
+
+// ErrCodeNotFound код входа не запрашивался или уже использован
+var ErrCodeNotFound = errors.New("код входа не найден")
+
+// LoginAction что ограничивается при входе
+type LoginAction string
+
+const (
+	LoginSend    LoginAction = "send"    // отправка кода на email
+	LoginAttempt LoginAction = "attempt" // попытка ввода кода
+)
+
+// LoginStore коды входа и ограничения на их отправку и ввод
+type LoginStore interface {
+	SaveLoginCode(employeeID uuid.UUID, c otp.Code) error
+	GetLoginCode(employeeID uuid.UUID) (otp.Code, error)
+	DeleteLoginCode(employeeID uuid.UUID) error
+	HitLoginLimit(key string, action LoginAction, now time.Time, window time.Duration) (int, time.Time, error)
+}
+
+// SaveLoginCode сохранит новый код сотрудника, заменив прежний
+func (d *DB) SaveLoginCode(employeeID uuid.UUID, c otp.Code) error {
+	_, err := d.dB.Exec(
+		`INSERT INTO login_codes (employee_id, code_hash, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (employee_id) DO UPDATE SET code_hash = EXCLUDED.code_hash, created_at = EXCLUDED.created_at`,
+		employeeID, c.Hash, c.CreatedAt)
+
+	return err
+}
+
+// GetLoginCode действующий код сотрудника
+func (d *DB) GetLoginCode(employeeID uuid.UUID) (otp.Code, error) {
+	var c otp.Code
+	err := d.dB.QueryRow(
+		`SELECT l.code_hash, l.created_at FROM login_codes l WHERE l.employee_id = $1`,
+		employeeID).Scan(&c.Hash, &c.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, ErrCodeNotFound
+	}
+
+	return c, err
+}
+
+// DeleteLoginCode удалит код сотрудника (использован, истек или исчерпаны попытки)
+func (d *DB) DeleteLoginCode(employeeID uuid.UUID) error {
+	_, err := d.dB.Exec(`DELETE FROM login_codes l WHERE l.employee_id = $1`, employeeID)
+
+	return err
+}
+
+// HitLoginLimit учтет действие action для key (сотрудник или telegram ID) в окне window
+// и вернет, сколько таких действий уже было в текущем окне и когда окно закончится
+func (d *DB) HitLoginLimit(key string, action LoginAction, now time.Time, window time.Duration) (int, time.Time, error) {
+	var count int
+	var resetAt time.Time
+	err := d.dB.QueryRow(
+		`INSERT INTO login_limits AS l (key, action, window_start, count)
+		VALUES ($1, $2, $3, 1)
+		ON CONFLICT (key, action) DO UPDATE SET
+			count = CASE WHEN l.window_start <= $3::TIMESTAMPTZ - make_interval(secs => $4)
+				THEN 1 ELSE l.count + 1 END,
+			window_start = CASE WHEN l.window_start <= $3::TIMESTAMPTZ - make_interval(secs => $4)
+				THEN $3 ELSE l.window_start END
+		RETURNING l.count, l.window_start + make_interval(secs => $4)`,
+		key, action, now, window.Seconds()).Scan(&count, &resetAt)
+
+	return count, resetAt, err
+}
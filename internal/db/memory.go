@@ -0,0 +1,1520 @@
+package db
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"birthdayGreetings/internal/calendar"
+	"birthdayGreetings/internal/clock"
+	"birthdayGreetings/internal/dialog"
+	"birthdayGreetings/internal/otp"
+	"birthdayGreetings/internal/search"
+
+	"github.com/gofrs/uuid"
+)
+
+// Snapshot содержимое хранилища: сотрудники, их подписки, команды и подписки на команды,
+// произвольные события и подписки на них, дайджесты
+type Snapshot struct {
+	Employees          []Employee
+	Subscriptions      []Subscription
+	Teams              []Team
+	TeamMembers        []TeamMember
+	TeamSubscriptions  []TeamSubscription
+	Events             []CustomEvent
+	EventSubscriptions []Subscription
+	Digests            []Digest
+}
+
+// MemoryDB хранилище сотрудников в памяти (для тестов и демо-режима без Postgres)
+type MemoryDB struct {
+	mu             sync.RWMutex
+	employees      []Employee
+	subscriptions  []Subscription
+	processedUntil time.Time
+	outbox         []OutboxMessage
+	conversations  map[int64]dialog.Conversation
+	loginCodes     map[uuid.UUID]otp.Code
+	loginLimits    map[string]loginLimit
+	sessions       map[uuid.UUID]Session
+	bindings       []Binding
+	teams          []Team
+	teamMembers    []TeamMember
+	teamSubs       []TeamSubscription
+	events         []CustomEvent
+	eventSubs      []Subscription
+	digests        []Digest
+	// clock часы для меток времени, которые Postgres ставит сам (now())
+	clock clock.Clock
+}
+
+// loginLimit окно ограничения входа: когда началось и сколько действий в нем было
+type loginLimit struct {
+	windowStart time.Time
+	count       int
+}
+
+func NewMemoryDB(snapshot Snapshot, clk clock.Clock) *MemoryDB {
+	m := &MemoryDB{
+		conversations: make(map[int64]dialog.Conversation),
+		loginCodes:    make(map[uuid.UUID]otp.Code),
+		loginLimits:   make(map[string]loginLimit),
+		sessions:      make(map[uuid.UUID]Session),
+		clock:         clk,
+	}
+	for _, e := range snapshot.Employees {
+		if e.ID == uuid.Nil {
+			e.ID = uuid.Must(uuid.NewV4())
+		}
+		m.employees = append(m.employees, e)
+	}
+	m.subscriptions = append(m.subscriptions, snapshot.Subscriptions...)
+	m.teams = append(m.teams, snapshot.Teams...)
+	m.teamMembers = append(m.teamMembers, snapshot.TeamMembers...)
+	m.teamSubs = append(m.teamSubs, snapshot.TeamSubscriptions...)
+	m.events = append(m.events, snapshot.Events...)
+	m.eventSubs = append(m.eventSubs, snapshot.EventSubscriptions...)
+	m.digests = append(m.digests, snapshot.Digests...)
+
+	return m
+}
+
+// LoadCSV читает сотрудников из csv в формате таблицы employees (см. csv/employees.csv).
+// Подписки из колонки subscribe переносятся так же, как в миграции 000002
+func LoadCSV(path string) (Snapshot, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	var snapshot Snapshot
+	subscribes := make([]map[uuid.UUID]time.Time, 0, len(records))
+	for i, r := range records {
+		if len(r) != 14 {
+			return Snapshot{}, fmt.Errorf("строка %d: ожидается 14 колонок, получено %d", i+1, len(r))
+		}
+
+		var e Employee
+		var subscribe map[uuid.UUID]time.Time
+		if e.ID, err = uuid.FromString(r[0]); err != nil {
+			return Snapshot{}, fmt.Errorf("строка %d: %v", i+1, err)
+		}
+		if e.TelegramID, err = strconv.ParseInt(r[1], 10, 64); err != nil {
+			return Snapshot{}, fmt.Errorf("строка %d: %v", i+1, err)
+		}
+		e.Token, e.FirstName, e.Patronymic, e.LastName, e.Email = r[2], r[3], r[4], r[5], r[6]
+		if e.BirthDate, err = time.Parse(time.DateOnly, r[7]); err != nil {
+			return Snapshot{}, fmt.Errorf("строка %d: %v", i+1, err)
+		}
+		// колонка 8 - временный пароль, его заменили коды входа (см. миграцию 000009)
+		e.Timezone, e.Role, e.Status = "UTC", RoleEmployee, StatusActive
+		if err = json.Unmarshal([]byte(r[9]), &subscribe); err != nil {
+			return Snapshot{}, fmt.Errorf("строка %d: %v", i+1, err)
+		}
+
+		// колонки 10-12 - флаги wait_*, их заменили диалоги (см. миграцию 000008)
+		if e.InTgGroup, err = strconv.ParseBool(r[13]); err != nil {
+			return Snapshot{}, fmt.Errorf("строка %d: %v", i+1, err)
+		}
+
+		snapshot.Employees = append(snapshot.Employees, e)
+		subscribes = append(subscribes, subscribe)
+	}
+
+	birthDates := make(map[uuid.UUID]time.Time, len(snapshot.Employees))
+	for _, e := range snapshot.Employees {
+		birthDates[e.ID] = e.BirthDate
+	}
+	for i, subscribe := range subscribes {
+		for targetID, nextFireAt := range subscribe {
+			birthDate, ok := birthDates[targetID]
+			if !ok {
+				continue
+			}
+
+			// ближайший День рождения не раньше даты оповещания
+			birthday := calendar.BirthdayIn(birthDate, nextFireAt.UTC().Year(), time.UTC, calendar.LeapFeb28)
+			if birthday.Before(nextFireAt) {
+				birthday = calendar.BirthdayIn(birthDate, nextFireAt.UTC().Year()+1, time.UTC, calendar.LeapFeb28)
+			}
+
+			snapshot.Subscriptions = append(snapshot.Subscriptions, Subscription{
+				SubscriberID: snapshot.Employees[i].ID,
+				TargetID:     targetID,
+				Event:        EventBirthday,
+				LeadTime:     birthday.Sub(nextFireAt),
+				NextFireAt:   nextFireAt,
+			})
+		}
+	}
+
+	return snapshot, nil
+}
+
+// Snapshot копия сотрудников, подписок, команд, событий и дайджестов
+func (m *MemoryDB) Snapshot() (Snapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return Snapshot{
+		Employees:          append([]Employee(nil), m.employees...),
+		Subscriptions:      append([]Subscription(nil), m.subscriptions...),
+		Teams:              append([]Team(nil), m.teams...),
+		TeamMembers:        append([]TeamMember(nil), m.teamMembers...),
+		TeamSubscriptions:  append([]TeamSubscription(nil), m.teamSubs...),
+		Events:             append([]CustomEvent(nil), m.events...),
+		EventSubscriptions: append([]Subscription(nil), m.eventSubs...),
+		Digests:            append([]Digest(nil), m.digests...),
+	}, nil
+}
+
+func (m *MemoryDB) Close() {}
+
+// PatchEmployee сохраняет заданные в обновлении поля
+func (m *MemoryDB) PatchEmployee(id uuid.UUID, u EmployeeUpdate) error {
+	if u.IsEmpty() {
+		return ErrEmptyUpdate
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.indexOf(func(x Employee) bool { return x.ID == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	u.Apply(&m.employees[i])
+
+	return nil
+}
+
+// GetPage извлекает данные сегментами (страницами)
+func (m *MemoryDB) GetPage(page int) ([]Employee, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// тот же порядок, что и в Postgres: по фамилии, имени и id
+	var sorted []Employee
+	for _, e := range m.employees {
+		if e.Status.Listed() {
+			sorted = append(sorted, e)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.LastName != b.LastName {
+			return a.LastName < b.LastName
+		}
+		if a.FirstName != b.FirstName {
+			return a.FirstName < b.FirstName
+		}
+		return a.ID.String() < b.ID.String()
+	})
+
+	var employees []Employee
+	for i := page * LIMIT; i >= 0 && i < len(sorted) && i < (page+1)*LIMIT; i++ {
+		employees = append(employees, sorted[i])
+	}
+
+	return employees, nil
+}
+
+// GetCount возвращает количество пользователей
+func (m *MemoryDB) GetCount() (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count := 0
+	for _, e := range m.employees {
+		if e.Status.Listed() {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// AddEmployee добавит сотрудника
+func (m *MemoryDB) AddEmployee(e Employee) (Employee, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e = newEmployee(e)
+	m.employees = append(m.employees, e)
+
+	return e, nil
+}
+
+// GetEmployees все сотрудники
+func (m *MemoryDB) GetEmployees() ([]Employee, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]Employee(nil), m.employees...), nil
+}
+
+// ImportEmployees применит импорт целиком: сначала проверка, потом изменения
+func (m *MemoryDB) ImportEmployees(im EmployeeImport) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	updates := make([]int, len(im.Update))
+	for i, e := range im.Update {
+		if updates[i] = m.indexOf(func(x Employee) bool { return x.ID == e.ID }); updates[i] < 0 {
+			return ErrNotFound
+		}
+	}
+
+	for i, e := range im.Update {
+		x := &m.employees[updates[i]]
+		x.FirstName, x.Patronymic, x.LastName = e.FirstName, e.Patronymic, e.LastName
+		x.Email, x.BirthDate, x.Status, x.ExternalID = e.Email, e.BirthDate, e.Status, e.ExternalID
+		x.HireDate = e.HireDate
+	}
+	m.terminate(im.Deactivate)
+	for _, e := range im.Add {
+		m.employees = append(m.employees, newEmployee(e))
+	}
+
+	return nil
+}
+
+// SetStatus сменит статус сотрудника; при увольнении удалит его подписки и подписки на него
+func (m *MemoryDB) SetStatus(id uuid.UUID, status Status) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.indexOf(func(e Employee) bool { return e.ID == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	if status == StatusTerminated {
+		m.terminate([]uuid.UUID{id})
+	} else {
+		m.employees[i].Status = status
+	}
+
+	return nil
+}
+
+// terminate уволит сотрудников и удалит их подписки и подписки на них (вызывать под m.mu)
+func (m *MemoryDB) terminate(ids []uuid.UUID) {
+	terminated := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		terminated[id] = true
+	}
+	for i := range m.employees {
+		if terminated[m.employees[i].ID] {
+			m.employees[i].Status = StatusTerminated
+		}
+	}
+
+	subscriptions := m.subscriptions[:0]
+	for _, s := range m.subscriptions {
+		if !terminated[s.SubscriberID] && !terminated[s.TargetID] {
+			subscriptions = append(subscriptions, s)
+		}
+	}
+	m.subscriptions = subscriptions
+
+	teamSubs := m.teamSubs[:0]
+	for _, s := range m.teamSubs {
+		if !terminated[s.SubscriberID] {
+			teamSubs = append(teamSubs, s)
+		}
+	}
+	m.teamSubs = teamSubs
+
+	owners := make(map[uuid.UUID]uuid.UUID, len(m.events))
+	for _, e := range m.events {
+		owners[e.ID] = e.EmployeeID.UUID
+	}
+	eventSubs := m.eventSubs[:0]
+	for _, s := range m.eventSubs {
+		if !terminated[s.SubscriberID] && !terminated[owners[s.EventID.UUID]] {
+			eventSubs = append(eventSubs, s)
+		}
+	}
+	m.eventSubs = eventSubs
+
+	digests := m.digests[:0]
+	for _, d := range m.digests {
+		if !terminated[d.EmployeeID] {
+			digests = append(digests, d)
+		}
+	}
+	m.digests = digests
+}
+
+// SearchEmployees нечеткий поиск по ФИО и email, самые похожие - первыми
+func (m *MemoryDB) SearchEmployees(query string, limit int) ([]Employee, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	query = search.Normalize(query)
+	scores := make(map[uuid.UUID]float64)
+	var employees []Employee
+	for _, e := range m.employees {
+		if !e.Status.Listed() {
+			continue
+		}
+		text := search.Normalize(strings.Join([]string{e.LastName, e.FirstName, e.Patronymic, e.Email}, " "))
+		if score := search.Score(query, text); score >= search.Threshold {
+			scores[e.ID] = score
+			employees = append(employees, e)
+		}
+	}
+
+	sort.SliceStable(employees, func(i, j int) bool {
+		return scores[employees[i].ID] > scores[employees[j].ID]
+	})
+	if len(employees) > limit {
+		employees = employees[:limit]
+	}
+
+	return employees, nil
+}
+
+// GetEmployee извлекает данные одного пользователя (email сравнивается без учета регистра)
+func (m *MemoryDB) GetEmployee(e Employee) (Employee, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var match func(x Employee) bool
+	switch {
+	case e.Email != "":
+		match = func(x Employee) bool { return strings.EqualFold(x.Email, e.Email) }
+	case e.TelegramID != 0:
+		match = func(x Employee) bool { return x.TelegramID == e.TelegramID }
+	case e.ID != uuid.Nil:
+		match = func(x Employee) bool { return x.ID == e.ID }
+	default:
+		return e, ErrNotFound
+	}
+
+	i := m.indexOf(match)
+	if i < 0 {
+		return e, ErrNotFound
+	}
+
+	return m.employees[i], nil
+}
+
+// indexOf ищет первого подходящего сотрудника (вызывать под блокировкой)
+func (m *MemoryDB) indexOf(match func(Employee) bool) int {
+	for i, e := range m.employees {
+		if match(e) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// AddSubscription добавит к подписке оповещание за s.LeadTime или обновит его время и источник
+func (m *MemoryDB) AddSubscription(s Subscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.indexOf(func(x Employee) bool { return x.ID == s.SubscriberID }) < 0 ||
+		m.indexOf(func(x Employee) bool { return x.ID == s.TargetID }) < 0 {
+		return ErrNotFound
+	}
+
+	if i := subscriptionIndex(m.subscriptions, s); i >= 0 {
+		m.subscriptions[i] = s
+		return nil
+	}
+	m.subscriptions = append(m.subscriptions, s)
+
+	return nil
+}
+
+// RemoveSubscription удаляет подписку на событие со всеми ее оповещаниями
+func (m *MemoryDB) RemoveSubscription(subscriberID, targetID uuid.UUID, event EventKind) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return removeSubscriptions(&m.subscriptions, func(s Subscription) bool {
+		return s.SubscriberID == subscriberID && s.TargetID == targetID && s.Event == event
+	})
+}
+
+// RemoveSubscriptionLead удаляет одно оповещание подписки
+func (m *MemoryDB) RemoveSubscriptionLead(subscriberID, targetID uuid.UUID, event EventKind, lead time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return removeSubscriptions(&m.subscriptions, func(s Subscription) bool {
+		return s.SubscriberID == subscriberID && s.TargetID == targetID && s.Event == event && s.LeadTime == lead
+	})
+}
+
+// removeSubscriptions уберет из списка подходящие подписки (ErrSubscriptionNotFound, если таких нет)
+func removeSubscriptions(subscriptions *[]Subscription, match func(Subscription) bool) error {
+	kept := (*subscriptions)[:0]
+	for _, s := range *subscriptions {
+		if !match(s) {
+			kept = append(kept, s)
+		}
+	}
+	removed := len(*subscriptions) - len(kept)
+	*subscriptions = kept
+
+	if removed == 0 {
+		return ErrSubscriptionNotFound
+	}
+
+	return nil
+}
+
+// GetSubscriptions возвращает подписки сотрудника (на кого он подписан)
+func (m *MemoryDB) GetSubscriptions(subscriberID uuid.UUID) ([]Subscription, error) {
+	return m.filterSubscriptions(func(s Subscription) bool { return s.SubscriberID == subscriberID }), nil
+}
+
+// GetSubscribers возвращает подписки на сотрудника (кто на него подписан)
+func (m *MemoryDB) GetSubscribers(targetID uuid.UUID) ([]Subscription, error) {
+	return m.filterSubscriptions(func(s Subscription) bool { return s.TargetID == targetID }), nil
+}
+
+// filterSubscriptions отберет подписки, упорядочив их по времени оповещания
+func (m *MemoryDB) filterSubscriptions(match func(Subscription) bool) []Subscription {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var subscriptions []Subscription
+	for _, s := range m.subscriptions {
+		if match(s) {
+			subscriptions = append(subscriptions, s)
+		}
+	}
+	sort.SliceStable(subscriptions, func(i, j int) bool {
+		return subscriptions[i].NextFireAt.Before(subscriptions[j].NextFireAt)
+	})
+
+	return subscriptions
+}
+
+// subscriptionIndex ищет оповещание подписки key: по сотруднику или событию из events и смещению
+func subscriptionIndex(subscriptions []Subscription, key Subscription) int {
+	for i, s := range subscriptions {
+		if s.SubscriberID == key.SubscriberID && s.TargetID == key.TargetID && s.Event == key.Event &&
+			s.EventID == key.EventID && s.LeadTime == key.LeadTime {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// GetDueNotifications вернет все напоминания, время которых наступило раньше to,
+// и поздравления, полночь Дня рождения или годовщины работы которых в часовом поясе
+// сотрудника попадает в [from, to).
+// Статусы учитываются так же, как в Postgres
+func (m *MemoryDB) GetDueNotifications(from, to time.Time, policy calendar.LeapPolicy) ([]Notification, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	inWindow := func(t time.Time) bool { return !t.Before(from) && t.Before(to) }
+	employees := make(map[uuid.UUID]Employee, len(m.employees))
+	for _, e := range m.employees {
+		employees[e.ID] = e
+	}
+
+	var notifications []Notification
+	subscribers := make(map[uuid.UUID]bool)
+	for _, s := range m.subscriptions {
+		subscribers[s.SubscriberID] = true
+		recipient, target := employees[s.SubscriberID], employees[s.TargetID]
+		_, dated := target.EventDate(s.Event)
+		if s.NextFireAt.Before(to) && recipient.Status == StatusActive && target.Status.Listed() && dated {
+			notifications = append(notifications, Notification{
+				Kind:      KindReminder,
+				Event:     s.Event,
+				FireAt:    s.NextFireAt,
+				Recipient: recipient,
+				Target:    target,
+				LeadTime:  s.LeadTime,
+			})
+		}
+	}
+
+	for _, e := range m.employees {
+		if !subscribers[e.ID] || !e.Status.Listed() {
+			continue
+		}
+		for _, event := range []EventKind{EventBirthday, EventAnniversary} {
+			date, ok := e.EventDate(event)
+			if !ok {
+				continue
+			}
+			for year := from.UTC().Year() - 1; year <= to.UTC().Year()+1; year++ {
+				if event == EventAnniversary && year <= date.Year() {
+					continue
+				}
+				if fireAt := calendar.BirthdayIn(date, year, e.Location(), policy); inWindow(fireAt) {
+					notifications = append(notifications, Notification{
+						Kind: KindGreeting, Event: event, FireAt: fireAt, Recipient: e, Target: e})
+				}
+			}
+		}
+	}
+	notifications = append(notifications, m.dueEventNotifications(from, to, policy, employees, subscribers)...)
+	sortNotifications(notifications)
+
+	return notifications, nil
+}
+
+// dueEventNotifications оповещания о произвольных событиях (вызывать под блокировкой)
+func (m *MemoryDB) dueEventNotifications(from, to time.Time, policy calendar.LeapPolicy,
+	employees map[uuid.UUID]Employee, subscribers map[uuid.UUID]bool) []Notification {
+	events := make(map[uuid.UUID]CustomEvent, len(m.events))
+	for _, e := range m.events {
+		events[e.ID] = e
+	}
+
+	var notifications []Notification
+	for _, s := range m.eventSubs {
+		event := events[s.EventID.UUID]
+		recipient, target := employees[s.SubscriberID], employees[event.EmployeeID.UUID]
+		if s.NextFireAt.Before(to) && recipient.Status == StatusActive && target.Status != StatusTerminated {
+			notifications = append(notifications, Notification{
+				Kind:      KindReminder,
+				Event:     EventCustom,
+				FireAt:    s.NextFireAt,
+				Recipient: recipient,
+				Target:    target,
+				LeadTime:  s.LeadTime,
+				Custom:    event,
+			})
+		}
+	}
+
+	for _, event := range m.events {
+		for _, e := range m.employees {
+			if event.EmployeeID.Valid && event.EmployeeID.UUID != e.ID || !subscribers[e.ID] || !e.Status.Listed() {
+				continue
+			}
+			var target Employee
+			if event.EmployeeID.Valid {
+				target = e
+			}
+			for year := from.UTC().Year() - 1; year <= to.UTC().Year()+1; year++ {
+				if fireAt, ok := event.In(year, e.Location(), policy); ok && !fireAt.Before(from) && fireAt.Before(to) {
+					notifications = append(notifications, Notification{
+						Kind: KindGreeting, Event: EventCustom, FireAt: fireAt, Recipient: e, Target: target, Custom: event})
+				}
+			}
+		}
+	}
+
+	return notifications
+}
+
+// CreateEvent создаст событие (ErrNotFound, если нет сотрудника EmployeeID)
+func (m *MemoryDB) CreateEvent(e CustomEvent) (CustomEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e.EmployeeID.Valid && m.indexOf(func(x Employee) bool { return x.ID == e.EmployeeID.UUID }) < 0 {
+		return CustomEvent{}, ErrNotFound
+	}
+	e.ID = uuid.Must(uuid.NewV4())
+	m.events = append(m.events, e)
+
+	return e, nil
+}
+
+// GetEvent найдет событие по ID
+func (m *MemoryDB) GetEvent(id uuid.UUID) (CustomEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, e := range m.events {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+
+	return CustomEvent{}, ErrEventNotFound
+}
+
+// GetEvents события компании и неуволенных сотрудников: сначала общие, затем по названию
+func (m *MemoryDB) GetEvents() ([]CustomEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []CustomEvent
+	for _, e := range m.events {
+		i := m.indexOf(func(x Employee) bool { return x.ID == e.EmployeeID.UUID })
+		if !e.EmployeeID.Valid || i >= 0 && m.employees[i].Status != StatusTerminated {
+			events = append(events, e)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].EmployeeID.Valid != events[j].EmployeeID.Valid {
+			return !events[i].EmployeeID.Valid
+		}
+		return events[i].Title < events[j].Title
+	})
+
+	return events, nil
+}
+
+// DeleteEvent удалит событие вместе с подписками на него
+func (m *MemoryDB) DeleteEvent(id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, e := range m.events {
+		if e.ID != id {
+			continue
+		}
+		m.events = append(m.events[:i], m.events[i+1:]...)
+		subscriptions := m.eventSubs[:0]
+		for _, s := range m.eventSubs {
+			if s.EventID.UUID != id {
+				subscriptions = append(subscriptions, s)
+			}
+		}
+		m.eventSubs = subscriptions
+
+		return nil
+	}
+
+	return ErrEventNotFound
+}
+
+// AddEventSubscription создает подписку на событие или обновляет существующую
+func (m *MemoryDB) AddEventSubscription(s Subscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	owner := uuid.Nil
+	found := false
+	for _, e := range m.events {
+		if e.ID == s.EventID.UUID {
+			owner, found = e.EmployeeID.UUID, true
+		}
+	}
+	if !found {
+		return ErrEventNotFound
+	}
+	s.Event, s.TargetID = EventCustom, owner
+
+	if i := subscriptionIndex(m.eventSubs, s); i >= 0 {
+		m.eventSubs[i] = s
+		return nil
+	}
+	m.eventSubs = append(m.eventSubs, s)
+
+	return nil
+}
+
+// RemoveEventSubscription удаляет подписку на событие со всеми ее оповещаниями
+func (m *MemoryDB) RemoveEventSubscription(subscriberID, eventID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return removeSubscriptions(&m.eventSubs, func(s Subscription) bool {
+		return s.SubscriberID == subscriberID && s.EventID.UUID == eventID
+	})
+}
+
+// RemoveEventSubscriptionLead удаляет одно оповещание подписки на событие
+func (m *MemoryDB) RemoveEventSubscriptionLead(subscriberID, eventID uuid.UUID, lead time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return removeSubscriptions(&m.eventSubs, func(s Subscription) bool {
+		return s.SubscriberID == subscriberID && s.EventID.UUID == eventID && s.LeadTime == lead
+	})
+}
+
+// GetEventSubscriptions подписки сотрудника на события, по времени оповещания
+func (m *MemoryDB) GetEventSubscriptions(subscriberID uuid.UUID) ([]Subscription, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var subscriptions []Subscription
+	for _, s := range m.eventSubs {
+		if s.SubscriberID == subscriberID {
+			subscriptions = append(subscriptions, s)
+		}
+	}
+	sort.SliceStable(subscriptions, func(i, j int) bool {
+		return subscriptions[i].NextFireAt.Before(subscriptions[j].NextFireAt)
+	})
+
+	return subscriptions, nil
+}
+
+// SetEventNextFireAt переносит на s.NextFireAt время оповещания s.LeadTime по подписке на событие
+func (m *MemoryDB) SetEventNextFireAt(s Subscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.setNextFireAt(m.eventSubs, s)
+}
+
+// SetNextFireAt переносит на s.NextFireAt время оповещания s.LeadTime по подписке
+func (m *MemoryDB) SetNextFireAt(s Subscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.setNextFireAt(m.subscriptions, s)
+}
+
+// setNextFireAt переносит время оповещания в списке подписок (вызывать под блокировкой)
+func (m *MemoryDB) setNextFireAt(subscriptions []Subscription, s Subscription) error {
+	i := subscriptionIndex(subscriptions, s)
+	if i < 0 {
+		return ErrSubscriptionNotFound
+	}
+	subscriptions[i].NextFireAt = s.NextFireAt
+
+	return nil
+}
+
+// GetUngroupedSubscribers неуволенные сотрудники с подписками, которых еще нет в группе telegram
+func (m *MemoryDB) GetUngroupedSubscribers() ([]Employee, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var employees []Employee
+	for _, e := range m.employees {
+		if e.InTgGroup || !e.Status.Listed() {
+			continue
+		}
+		for _, s := range m.subscriptions {
+			if s.SubscriberID == e.ID {
+				employees = append(employees, e)
+				break
+			}
+		}
+	}
+
+	return employees, nil
+}
+
+// GetGroupedTerminated уволенные сотрудники, которые еще состоят в группе telegram
+func (m *MemoryDB) GetGroupedTerminated() ([]Employee, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var employees []Employee
+	for _, e := range m.employees {
+		if e.InTgGroup && e.Status == StatusTerminated {
+			employees = append(employees, e)
+		}
+	}
+
+	return employees, nil
+}
+
+// GetWatermark до какого момента уже обработаны оповещания
+func (m *MemoryDB) GetWatermark() (time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.processedUntil, nil
+}
+
+// SetWatermark сохранит момент, до которого обработаны оповещания
+func (m *MemoryDB) SetWatermark(processedUntil time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.processedUntil = processedUntil
+
+	return nil
+}
+
+// Enqueue ставит сообщение в очередь (повтор с тем же DedupKey игнорируется)
+// и, если advance задан, переносит время следующего напоминания по подписке
+func (m *MemoryDB) Enqueue(msg OutboxMessage, advance *Subscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var err error
+	switch {
+	case advance == nil:
+	case advance.Event == EventCustom:
+		err = m.setNextFireAt(m.eventSubs, *advance)
+	default:
+		err = m.setNextFireAt(m.subscriptions, *advance)
+	}
+	if err != nil {
+		return err
+	}
+
+	m.insertOutbox(msg)
+
+	return nil
+}
+
+// insertOutbox ставит сообщение в очередь, если с тем же DedupKey его там еще нет (вызывать под m.mu)
+func (m *MemoryDB) insertOutbox(msg OutboxMessage) {
+	for _, x := range m.outbox {
+		if x.DedupKey == msg.DedupKey {
+			return
+		}
+	}
+
+	now := m.clock.Now()
+	m.outbox = append(m.outbox, OutboxMessage{
+		ID:            int64(len(m.outbox) + 1),
+		DedupKey:      msg.DedupKey,
+		ChatID:        msg.ChatID,
+		Text:          msg.Text,
+		Status:        OutboxPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	})
+}
+
+// ClaimOutbox заберет готовые к отправке сообщения, продлив им next_attempt_at до leaseUntil
+func (m *MemoryDB) ClaimOutbox(now, leaseUntil time.Time, limit int) ([]OutboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ready []int
+	for i, x := range m.outbox {
+		if x.Status == OutboxPending && !x.NextAttemptAt.After(now) {
+			ready = append(ready, i)
+		}
+	}
+	sort.SliceStable(ready, func(i, j int) bool {
+		return m.outbox[ready[i]].NextAttemptAt.Before(m.outbox[ready[j]].NextAttemptAt)
+	})
+	if len(ready) > limit {
+		ready = ready[:limit]
+	}
+
+	messages := make([]OutboxMessage, 0, len(ready))
+	for _, i := range ready {
+		m.outbox[i].NextAttemptAt = leaseUntil
+		messages = append(messages, m.outbox[i])
+	}
+
+	return messages, nil
+}
+
+// MarkDelivered отметит сообщение доставленным
+func (m *MemoryDB) MarkDelivered(id int64, at time.Time) error {
+	return m.updateOutbox(id, func(x *OutboxMessage) {
+		x.Status, x.DeliveredAt, x.LastError = OutboxDelivered, at, ""
+		x.Attempts++
+	})
+}
+
+// MarkFailed учтет неудачную попытку: назначит следующую или переведет сообщение в dead
+func (m *MemoryDB) MarkFailed(id int64, lastError string, nextAttemptAt time.Time, dead bool) error {
+	return m.updateOutbox(id, func(x *OutboxMessage) {
+		x.Status, x.NextAttemptAt, x.LastError = OutboxPending, nextAttemptAt, lastError
+		if dead {
+			x.Status = OutboxDead
+		}
+		x.Attempts++
+	})
+}
+
+// GetDeadLetters последние сообщения, которые так и не удалось доставить
+func (m *MemoryDB) GetDeadLetters(limit int) ([]OutboxMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var messages []OutboxMessage
+	for i := len(m.outbox) - 1; i >= 0 && len(messages) < limit; i-- {
+		if m.outbox[i].Status == OutboxDead {
+			messages = append(messages, m.outbox[i])
+		}
+	}
+
+	return messages, nil
+}
+
+// updateOutbox изменит сообщение по id
+func (m *MemoryDB) updateOutbox(id int64, update func(x *OutboxMessage)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.outbox {
+		if m.outbox[i].ID == id {
+			update(&m.outbox[i])
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// GetConversation текущий диалог пользователя telegram (Idle, если его нет)
+func (m *MemoryDB) GetConversation(telegramID int64) (dialog.Conversation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if c, ok := m.conversations[telegramID]; ok {
+		return c, nil
+	}
+
+	return dialog.Conversation{TelegramID: telegramID}, nil
+}
+
+// SaveConversation создаст или заменит диалог пользователя
+func (m *MemoryDB) SaveConversation(c dialog.Conversation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.conversations[c.TelegramID] = c
+
+	return nil
+}
+
+// DeleteConversation завершит диалог пользователя
+func (m *MemoryDB) DeleteConversation(telegramID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.conversations, telegramID)
+
+	return nil
+}
+
+// SaveLoginCode сохранит новый код сотрудника, заменив прежний
+func (m *MemoryDB) SaveLoginCode(employeeID uuid.UUID, c otp.Code) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.loginCodes[employeeID] = c
+
+	return nil
+}
+
+// GetLoginCode действующий код сотрудника
+func (m *MemoryDB) GetLoginCode(employeeID uuid.UUID) (otp.Code, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c, ok := m.loginCodes[employeeID]
+	if !ok {
+		return c, ErrCodeNotFound
+	}
+
+	return c, nil
+}
+
+// DeleteLoginCode удалит код сотрудника
+func (m *MemoryDB) DeleteLoginCode(employeeID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.loginCodes, employeeID)
+
+	return nil
+}
+
+// HitLoginLimit учтет действие action для key в окне window
+func (m *MemoryDB) HitLoginLimit(key string, action LoginAction, now time.Time, window time.Duration) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := key + "|" + string(action)
+	l, ok := m.loginLimits[k]
+	if !ok || !l.windowStart.After(now.Add(-window)) {
+		l = loginLimit{windowStart: now}
+	}
+	l.count++
+	m.loginLimits[k] = l
+
+	return l.count, l.windowStart.Add(window), nil
+}
+
+// CreateSession сохранит новую сессию
+func (m *MemoryDB) CreateSession(s Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[s.ID] = s
+
+	return nil
+}
+
+// GetSession сессия по ID (jti токена)
+func (m *MemoryDB) GetSession(id uuid.UUID) (Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return s, ErrSessionNotFound
+	}
+
+	return s, nil
+}
+
+// RevokeSession отзовет сессию
+func (m *MemoryDB) RevokeSession(id uuid.UUID, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[id]; ok && s.RevokedAt.IsZero() {
+		s.RevokedAt = at
+		m.sessions[id] = s
+	}
+
+	return nil
+}
+
+// RevokeSessions отзовет все сессии сотрудника
+func (m *MemoryDB) RevokeSessions(employeeID uuid.UUID, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, s := range m.sessions {
+		if s.EmployeeID == employeeID && s.RevokedAt.IsZero() {
+			s.RevokedAt = at
+			m.sessions[id] = s
+		}
+	}
+
+	return nil
+}
+
+// BindTelegram привяжет сотрудника к telegramID, отвяжет этот telegram ID от других сотрудников
+// и запишет изменения в аудит. Вернет прежний telegram ID сотрудника
+func (m *MemoryDB) BindTelegram(employeeID uuid.UUID, telegramID int64, reason BindingReason, at time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.indexOf(func(e Employee) bool { return e.ID == employeeID })
+	if i < 0 {
+		return 0, ErrNotFound
+	}
+	previous := m.employees[i].TelegramID
+	if previous == telegramID {
+		return previous, nil
+	}
+
+	for j := range m.employees {
+		if e := &m.employees[j]; j != i && e.TelegramID == telegramID {
+			e.TelegramID, e.Token, e.InTgGroup = 0, "", false
+			m.bindings = append(m.bindings, Binding{
+				EmployeeID: e.ID, OldTelegramID: telegramID, Reason: BindReleased, ChangedAt: at})
+		}
+	}
+
+	m.employees[i].TelegramID, m.employees[i].InTgGroup = telegramID, false
+	m.bindings = append(m.bindings, Binding{
+		EmployeeID: employeeID, OldTelegramID: previous, NewTelegramID: telegramID, Reason: reason, ChangedAt: at})
+
+	return previous, nil
+}
+
+// GetBindings история привязок сотрудника, последние - первыми
+func (m *MemoryDB) GetBindings(employeeID uuid.UUID) ([]Binding, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var bindings []Binding
+	for i := len(m.bindings) - 1; i >= 0; i-- {
+		if m.bindings[i].EmployeeID == employeeID {
+			bindings = append(bindings, m.bindings[i])
+		}
+	}
+
+	return bindings, nil
+}
+
+// CreateTeam создаст команду (название уникально без учета регистра)
+func (m *MemoryDB) CreateTeam(t Team) (Team, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.teamIndex(Team{Name: t.Name}) >= 0 {
+		return Team{}, ErrTeamExists
+	}
+	t.ID = uuid.Must(uuid.NewV4())
+	if t.Kind == "" {
+		t.Kind = TeamKindTeam
+	}
+	m.teams = append(m.teams, t)
+
+	return t, nil
+}
+
+// GetTeam найдет команду по ID или, если он не задан, по названию (без учета регистра)
+func (m *MemoryDB) GetTeam(t Team) (Team, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	i := m.teamIndex(t)
+	if i < 0 {
+		return t, ErrTeamNotFound
+	}
+
+	return m.teams[i], nil
+}
+
+// GetTeams все команды: сначала отделы, затем по названию
+func (m *MemoryDB) GetTeams() ([]Team, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedTeams(), nil
+}
+
+// DeleteTeam удалит команду вместе с участием, подписками на нее, подписками, заведенными по ним,
+// и дайджестами по команде
+func (m *MemoryDB) DeleteTeam(id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.teamIndex(Team{ID: id})
+	if i < 0 {
+		return ErrTeamNotFound
+	}
+	m.teams = append(m.teams[:i], m.teams[i+1:]...)
+
+	members := m.teamMembers[:0]
+	for _, tm := range m.teamMembers {
+		if tm.TeamID != id {
+			members = append(members, tm)
+		}
+	}
+	m.teamMembers = members
+
+	teamSubs := m.teamSubs[:0]
+	for _, s := range m.teamSubs {
+		if s.TeamID != id {
+			teamSubs = append(teamSubs, s)
+		}
+	}
+	m.teamSubs = teamSubs
+
+	subscriptions := m.subscriptions[:0]
+	for _, s := range m.subscriptions {
+		if !s.TeamID.Valid || s.TeamID.UUID != id {
+			subscriptions = append(subscriptions, s)
+		}
+	}
+	m.subscriptions = subscriptions
+
+	digests := m.digests[:0]
+	for _, d := range m.digests {
+		if !d.TeamID.Valid || d.TeamID.UUID != id {
+			digests = append(digests, d)
+		}
+	}
+	m.digests = digests
+
+	return nil
+}
+
+// AddTeamMember добавит сотрудника в команду (повторное добавление ничего не меняет)
+func (m *MemoryDB) AddTeamMember(teamID, employeeID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.teamIndex(Team{ID: teamID}) < 0 || m.indexOf(func(e Employee) bool { return e.ID == employeeID }) < 0 {
+		return ErrNotFound
+	}
+	for _, tm := range m.teamMembers {
+		if tm.TeamID == teamID && tm.EmployeeID == employeeID {
+			return nil
+		}
+	}
+	m.teamMembers = append(m.teamMembers, TeamMember{TeamID: teamID, EmployeeID: employeeID})
+
+	return nil
+}
+
+// RemoveTeamMember уберет сотрудника из команды
+func (m *MemoryDB) RemoveTeamMember(teamID, employeeID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, tm := range m.teamMembers {
+		if tm.TeamID == teamID && tm.EmployeeID == employeeID {
+			m.teamMembers = append(m.teamMembers[:i], m.teamMembers[i+1:]...)
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+// GetTeamMembers участники команды по фамилии и имени (вместе с деактивированными)
+func (m *MemoryDB) GetTeamMembers(teamID uuid.UUID) ([]Employee, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var employees []Employee
+	for _, tm := range m.teamMembers {
+		if tm.TeamID != teamID {
+			continue
+		}
+		if i := m.indexOf(func(e Employee) bool { return e.ID == tm.EmployeeID }); i >= 0 {
+			employees = append(employees, m.employees[i])
+		}
+	}
+	sort.Slice(employees, func(i, j int) bool {
+		a, b := employees[i], employees[j]
+		if a.LastName != b.LastName {
+			return a.LastName < b.LastName
+		}
+		if a.FirstName != b.FirstName {
+			return a.FirstName < b.FirstName
+		}
+		return a.ID.String() < b.ID.String()
+	})
+
+	return employees, nil
+}
+
+// GetEmployeeTeams в каких командах состоит сотрудник
+func (m *MemoryDB) GetEmployeeTeams(employeeID uuid.UUID) ([]Team, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var teams []Team
+	for _, tm := range m.teamMembers {
+		if tm.EmployeeID != employeeID {
+			continue
+		}
+		if i := m.teamIndex(Team{ID: tm.TeamID}); i >= 0 {
+			teams = append(teams, m.teams[i])
+		}
+	}
+	sortTeams(teams)
+
+	return teams, nil
+}
+
+// AddTeamSubscription добавит к подписке на команду оповещание за s.LeadTime (повторное
+// добавление ничего не меняет)
+func (m *MemoryDB) AddTeamSubscription(s TeamSubscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.teamIndex(Team{ID: s.TeamID}) < 0 {
+		return ErrTeamNotFound
+	}
+	for _, x := range m.teamSubs {
+		if x == s {
+			return nil
+		}
+	}
+	m.teamSubs = append(m.teamSubs, s)
+
+	return nil
+}
+
+// RemoveTeamSubscription удалит подписку на команду со всеми оповещаниями и заведенные по ней
+// подписки на участников
+func (m *MemoryDB) RemoveTeamSubscription(subscriberID, teamID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.removeTeamSubscriptions(subscriberID, teamID, func(lead time.Duration) bool { return true })
+}
+
+// RemoveTeamSubscriptionLead удалит одно оповещание подписки на команду и заведенные по нему
+// подписки на участников
+func (m *MemoryDB) RemoveTeamSubscriptionLead(subscriberID, teamID uuid.UUID, lead time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.removeTeamSubscriptions(subscriberID, teamID, func(l time.Duration) bool { return l == lead })
+}
+
+// removeTeamSubscriptions уберет подходящие оповещания подписки на команду и заведенные по ним
+// подписки (ErrSubscriptionNotFound, если таких оповещаний нет)
+func (m *MemoryDB) removeTeamSubscriptions(subscriberID, teamID uuid.UUID, match func(lead time.Duration) bool) error {
+	found := false
+	teamSubs := m.teamSubs[:0]
+	for _, s := range m.teamSubs {
+		if s.SubscriberID == subscriberID && s.TeamID == teamID && match(s.LeadTime) {
+			found = true
+			continue
+		}
+		teamSubs = append(teamSubs, s)
+	}
+	m.teamSubs = teamSubs
+	if !found {
+		return ErrSubscriptionNotFound
+	}
+
+	removeSubscriptions(&m.subscriptions, func(s Subscription) bool {
+		return s.SubscriberID == subscriberID && s.TeamID.Valid && s.TeamID.UUID == teamID && match(s.LeadTime)
+	})
+
+	return nil
+}
+
+// GetTeamSubscriptions на какие команды подписан сотрудник: оповещания одной команды идут подряд,
+// от самого раннего
+func (m *MemoryDB) GetTeamSubscriptions(subscriberID uuid.UUID) ([]TeamSubscription, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var subscriptions []TeamSubscription
+	for _, t := range m.sortedTeams() {
+		first := len(subscriptions)
+		for _, s := range m.teamSubs {
+			if s.SubscriberID == subscriberID && s.TeamID == t.ID {
+				subscriptions = append(subscriptions, s)
+			}
+		}
+		team := subscriptions[first:]
+		sort.Slice(team, func(i, j int) bool { return team[i].LeadTime > team[j].LeadTime })
+	}
+
+	return subscriptions, nil
+}
+
+// GetTeamSubscribers кто подписан на команду (по строке на каждое оповещание)
+func (m *MemoryDB) GetTeamSubscribers(teamID uuid.UUID) ([]TeamSubscription, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var subscriptions []TeamSubscription
+	for _, s := range m.teamSubs {
+		if s.TeamID == teamID {
+			subscriptions = append(subscriptions, s)
+		}
+	}
+
+	return subscriptions, nil
+}
+
+// teamIndex индекс команды по ID или названию, -1 если ее нет
+func (m *MemoryDB) teamIndex(t Team) int {
+	for i, x := range m.teams {
+		if (t.ID != uuid.Nil && x.ID == t.ID) || (t.ID == uuid.Nil && t.Name != "" && strings.EqualFold(x.Name, t.Name)) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// sortedTeams копия команд в порядке GetTeams
+func (m *MemoryDB) sortedTeams() []Team {
+	teams := append([]Team(nil), m.teams...)
+	sortTeams(teams)
+
+	return teams
+}
+
+// sortTeams тот же порядок, что и в Postgres: сначала отделы, затем по названию
+func sortTeams(teams []Team) {
+	sort.Slice(teams, func(i, j int) bool {
+		if teams[i].Kind != teams[j].Kind {
+			return teams[i].Kind < teams[j].Kind
+		}
+		return teams[i].Name < teams[j].Name
+	})
+}
+
+// SetDigest включит дайджест или изменит его настройки
+func (m *MemoryDB) SetDigest(d Digest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if d.TeamID.Valid && m.teamIndex(Team{ID: d.TeamID.UUID}) < 0 {
+		return ErrTeamNotFound
+	}
+	if i := m.digestIndex(d.EmployeeID); i >= 0 {
+		m.digests[i] = d
+		return nil
+	}
+	m.digests = append(m.digests, d)
+
+	return nil
+}
+
+// GetDigest настройки дайджеста сотрудника
+func (m *MemoryDB) GetDigest(employeeID uuid.UUID) (Digest, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	i := m.digestIndex(employeeID)
+	if i < 0 {
+		return Digest{}, ErrDigestNotFound
+	}
+
+	return m.digests[i], nil
+}
+
+// RemoveDigest выключит дайджест
+func (m *MemoryDB) RemoveDigest(employeeID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.digestIndex(employeeID)
+	if i < 0 {
+		return ErrDigestNotFound
+	}
+	m.digests = append(m.digests[:i], m.digests[i+1:]...)
+
+	return nil
+}
+
+// GetDueDigests дайджесты, время которых наступило раньше to (получают только работающие)
+func (m *MemoryDB) GetDueDigests(to time.Time) ([]Digest, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var digests []Digest
+	for _, d := range m.digests {
+		i := m.indexOf(func(e Employee) bool { return e.ID == d.EmployeeID })
+		if d.NextFireAt.Before(to) && i >= 0 && m.employees[i].Status == StatusActive {
+			digests = append(digests, d)
+		}
+	}
+	sort.Slice(digests, func(i, j int) bool { return digests[i].NextFireAt.Before(digests[j].NextFireAt) })
+
+	return digests, nil
+}
+
+// EnqueueDigest ставит дайджест в очередь (повтор с тем же DedupKey игнорируется)
+// и переносит время следующего на advance.NextFireAt
+func (m *MemoryDB) EnqueueDigest(msg OutboxMessage, advance Digest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.digestIndex(advance.EmployeeID)
+	if i < 0 {
+		return ErrDigestNotFound
+	}
+	m.digests[i].NextFireAt = advance.NextFireAt
+	m.insertOutbox(msg)
+
+	return nil
+}
+
+// digestIndex индекс дайджеста сотрудника, -1 если его нет (вызывать под m.mu)
+func (m *MemoryDB) digestIndex(employeeID uuid.UUID) int {
+	for i, d := range m.digests {
+		if d.EmployeeID == employeeID {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,247 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"birthdayGreetings/internal/clock"
+
+	"github.com/gofrs/uuid"
+)
+
+// memoryEmployees n сотрудников с разными email и telegram ID
+func memoryEmployees(n int) []Employee {
+	employees := make([]Employee, n)
+	for i := range employees {
+		employees[i] = Employee{
+			TelegramID: int64(100 + i),
+			FirstName:  fmt.Sprint("Имя", i),
+			LastName:   fmt.Sprint("Фамилия", i),
+			Email:      fmt.Sprintf("user%d@example.com", i),
+			BirthDate:  time.Date(1990, time.January, 1+i, 0, 0, 0, 0, time.UTC),
+		}
+	}
+
+	return employees
+}
+
+func TestMemoryGetEmployee(t *testing.T) {
+	m := NewMemoryDB(Snapshot{Employees: memoryEmployees(3)}, clock.Real{})
+	first, err := m.GetEmployee(Employee{Email: "user0@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == uuid.Nil {
+		t.Fatal("NewMemoryDB не выдал ID")
+	}
+
+	tests := []struct {
+		name  string
+		key   Employee
+		email string
+		err   error
+	}{
+		{"по email", Employee{Email: "user1@example.com"}, "user1@example.com", nil},
+		{"email без учета регистра", Employee{Email: "User1@Example.COM"}, "user1@example.com", nil},
+		{"по telegram ID", Employee{TelegramID: 102}, "user2@example.com", nil},
+		{"по ID", Employee{ID: first.ID}, "user0@example.com", nil},
+		{"email важнее telegram ID", Employee{Email: "user1@example.com", TelegramID: 102}, "user1@example.com", nil},
+		{"неизвестный email", Employee{Email: "nobody@example.com"}, "", ErrNotFound},
+		{"неизвестный ID", Employee{ID: uuid.Must(uuid.NewV4())}, "", ErrNotFound},
+		{"пустой ключ", Employee{}, "", ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.GetEmployee(tt.key)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err == nil && got.Email != tt.email {
+				t.Errorf("найден %s, want %s", got.Email, tt.email)
+			}
+		})
+	}
+}
+
+func TestMemoryGetPage(t *testing.T) {
+	m := NewMemoryDB(Snapshot{Employees: memoryEmployees(2*LIMIT + 5)}, clock.Real{})
+
+	count, err := m.GetCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2*LIMIT+5 {
+		t.Errorf("GetCount() = %d, want %d", count, 2*LIMIT+5)
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	for page, want := range []int{LIMIT, LIMIT, 5, 0} {
+		employees, err := m.GetPage(page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(employees) != want {
+			t.Errorf("GetPage(%d): %d сотрудников, want %d", page, len(employees), want)
+		}
+		for _, e := range employees {
+			if seen[e.ID] {
+				t.Errorf("сотрудник %s на нескольких страницах", e.Email)
+			}
+			seen[e.ID] = true
+		}
+	}
+
+	if employees, _ := m.GetPage(-1); len(employees) != 0 {
+		t.Errorf("GetPage(-1): %d сотрудников, want 0", len(employees))
+	}
+}
+
+func TestMemoryPatchEmployee(t *testing.T) {
+	m := NewMemoryDB(Snapshot{Employees: memoryEmployees(2)}, clock.Real{})
+	e, err := m.GetEmployee(Employee{Email: "user0@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = m.PatchEmployee(e.ID, EmployeeUpdate{}.SetTelegramID(555)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.GetEmployee(Employee{ID: e.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TelegramID != 555 {
+		t.Errorf("TelegramID = %d, want 555", got.TelegramID)
+	}
+	got.TelegramID = e.TelegramID
+	if fmt.Sprint(got) != fmt.Sprint(e) {
+		t.Errorf("PatchEmployee изменил не только TelegramID:\n got %v\nwant %v", got, e)
+	}
+
+	if err = m.PatchEmployee(e.ID, EmployeeUpdate{}); !errors.Is(err, ErrEmptyUpdate) {
+		t.Errorf("пустое обновление: err = %v, want %v", err, ErrEmptyUpdate)
+	}
+	if err = m.PatchEmployee(uuid.Must(uuid.NewV4()), EmployeeUpdate{}.SetTelegramID(1)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("неизвестный сотрудник: err = %v, want %v", err, ErrNotFound)
+	}
+}
+
+// Snapshot отдает все, из чего собран MemoryDB: симуляция работает на полной копии данных
+func TestMemorySnapshot(t *testing.T) {
+	employees := memoryEmployees(2)
+	for i := range employees {
+		employees[i].ID = uuid.Must(uuid.NewV4())
+	}
+	first, second := employees[0].ID, employees[1].ID
+	team := Team{ID: uuid.Must(uuid.NewV4()), Name: "Разработка", Kind: TeamKindDepartment}
+	event := CustomEvent{ID: uuid.Must(uuid.NewV4()), Title: "Новый год", Rule: RuleYearly, Date: time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)}
+	want := Snapshot{
+		Employees:          employees,
+		Subscriptions:      []Subscription{{SubscriberID: first, TargetID: second, Event: EventBirthday}},
+		Teams:              []Team{team},
+		TeamMembers:        []TeamMember{{TeamID: team.ID, EmployeeID: second}},
+		TeamSubscriptions:  []TeamSubscription{{SubscriberID: first, TeamID: team.ID}},
+		Events:             []CustomEvent{event},
+		EventSubscriptions: []Subscription{{SubscriberID: first, Event: EventCustom, EventID: uuid.NullUUID{UUID: event.ID, Valid: true}}},
+		Digests:            []Digest{{EmployeeID: first, Schedule: DigestWeekly, Scope: DigestTeam, TeamID: uuid.NullUUID{UUID: team.ID, Valid: true}}},
+	}
+
+	got, err := NewMemoryDB(want, clock.Real{}).Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", want) {
+		t.Errorf("Snapshot():\n got %+v\nwant %+v", got, want)
+	}
+}
+
+// Увольнение удаляет подписки уволенного и на него, его подписки на команды, подписки на его
+// события и его дайджест; отпуск ничего не удаляет
+func TestMemoryTerminate(t *testing.T) {
+	employees := memoryEmployees(3)
+	for i := range employees {
+		employees[i].ID = uuid.Must(uuid.NewV4())
+		employees[i].Status = StatusActive
+	}
+	leaver, stayer, other := employees[0].ID, employees[1].ID, employees[2].ID
+	team := Team{ID: uuid.Must(uuid.NewV4()), Name: "Разработка", Kind: TeamKindTeam}
+	date := time.Date(2000, time.June, 1, 0, 0, 0, 0, time.UTC)
+	leaverEvent := CustomEvent{ID: uuid.Must(uuid.NewV4()), EmployeeID: uuid.NullUUID{UUID: leaver, Valid: true}, Title: "Свадьба", Rule: RuleYearly, Date: date}
+	stayerEvent := CustomEvent{ID: uuid.Must(uuid.NewV4()), EmployeeID: uuid.NullUUID{UUID: stayer, Valid: true}, Title: "Свадьба", Rule: RuleYearly, Date: date}
+	companyEvent := CustomEvent{ID: uuid.Must(uuid.NewV4()), Title: "Новый год", Rule: RuleYearly, Date: date}
+	eventSubscription := func(subscriber uuid.UUID, e CustomEvent) Subscription {
+		return Subscription{SubscriberID: subscriber, TargetID: e.EmployeeID.UUID, Event: EventCustom, EventID: uuid.NullUUID{UUID: e.ID, Valid: true}}
+	}
+
+	m := NewMemoryDB(Snapshot{
+		Employees: employees,
+		Subscriptions: []Subscription{
+			{SubscriberID: leaver, TargetID: stayer, Event: EventBirthday},
+			{SubscriberID: stayer, TargetID: leaver, Event: EventBirthday},
+			{SubscriberID: stayer, TargetID: other, Event: EventBirthday},
+		},
+		Teams:       []Team{team},
+		TeamMembers: []TeamMember{{TeamID: team.ID, EmployeeID: stayer}},
+		TeamSubscriptions: []TeamSubscription{
+			{SubscriberID: leaver, TeamID: team.ID},
+			{SubscriberID: other, TeamID: team.ID},
+		},
+		Events: []CustomEvent{leaverEvent, stayerEvent, companyEvent},
+		EventSubscriptions: []Subscription{
+			eventSubscription(stayer, leaverEvent),
+			eventSubscription(leaver, stayerEvent),
+			eventSubscription(leaver, companyEvent),
+			eventSubscription(other, stayerEvent),
+		},
+		Digests: []Digest{{EmployeeID: leaver, Schedule: DigestWeekly}, {EmployeeID: stayer, Schedule: DigestWeekly}},
+	}, clock.Real{})
+	before, err := m.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = m.SetStatus(leaver, StatusOnLeave); err != nil {
+		t.Fatal(err)
+	}
+	onLeave, err := m.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	onLeave.Employees, before.Employees = nil, nil
+	if fmt.Sprintf("%+v", onLeave) != fmt.Sprintf("%+v", before) {
+		t.Errorf("отпуск изменил подписки:\n got %+v\nwant %+v", onLeave, before)
+	}
+
+	if err = m.SetStatus(leaver, StatusTerminated); err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := m.GetEmployee(Employee{ID: leaver}); e.Status != StatusTerminated {
+		t.Errorf("статус %s, want %s", e.Status, StatusTerminated)
+	}
+	checks := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"подписки", got.Subscriptions, []Subscription{{SubscriberID: stayer, TargetID: other, Event: EventBirthday}}},
+		{"подписки на команды", got.TeamSubscriptions, []TeamSubscription{{SubscriberID: other, TeamID: team.ID}}},
+		{"подписки на события", got.EventSubscriptions, []Subscription{eventSubscription(other, stayerEvent)}},
+		{"дайджесты", got.Digests, []Digest{{EmployeeID: stayer, Schedule: DigestWeekly}}},
+		{"участники команд", got.TeamMembers, before.TeamMembers},
+	}
+	for _, c := range checks {
+		if fmt.Sprintf("%+v", c.got) != fmt.Sprintf("%+v", c.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", c.name, c.got, c.want)
+		}
+	}
+
+	if err = m.SetStatus(uuid.Must(uuid.NewV4()), StatusTerminated); !errors.Is(err, ErrNotFound) {
+		t.Errorf("неизвестный сотрудник: err = %v, want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,171 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"birthdayGreetings/internal/calendar"
+
+	"github.com/gofrs/uuid"
+)
+
+// NotificationKind вид оповещения
+type NotificationKind string
+
+const (
+	KindReminder NotificationKind = "reminder" // напоминание подписчику о событии коллеги
+	KindGreeting NotificationKind = "greeting" // поздравление самому виновнику события
+)
+
+// Notification оповещение, которое пора отправить
+type Notification struct {
+	Kind      NotificationKind `json:"kind"`
+	Event     EventKind        `json:"event"`     // День рождения или годовщина работы
+	FireAt    time.Time        `json:"fire_at"`   // на когда было запланировано
+	Recipient Employee         `json:"recipient"` // кому отправить
+	Target    Employee         `json:"target"`    // чье событие (пусто у событий всей компании)
+	LeadTime  time.Duration    `json:"lead_time"` // для напоминаний: за сколько до события
+	Custom    CustomEvent      `json:"custom"`    // для EventCustom: само событие
+}
+
+// Subscription подписка, по которой пришло напоминание
+func (n Notification) Subscription() Subscription {
+	return Subscription{
+		SubscriberID: n.Recipient.ID,
+		TargetID:     n.Target.ID,
+		Event:        n.Event,
+		LeadTime:     n.LeadTime,
+		NextFireAt:   n.FireAt,
+		EventID:      uuid.NullUUID{UUID: n.Custom.ID, Valid: n.Event == EventCustom},
+	}
+}
+
+// NotificationStore выборки для планировщика оповещений
+type NotificationStore interface {
+	GetDueNotifications(from, to time.Time, policy calendar.LeapPolicy) ([]Notification, error)
+	SetNextFireAt(s Subscription) error
+	GetUngroupedSubscribers() ([]Employee, error)
+	GetGroupedTerminated() ([]Employee, error)
+	GetWatermark() (time.Time, error)
+	SetWatermark(processedUntil time.Time) error
+}
+
+// GetDueNotifications за один запрос вернет вместе с данными сотрудников все напоминания
+// по подпискам, время которых наступило раньше to (в том числе пропущенные), и поздравления
+// с Днем рождения и годовщиной работы, полночь которых в часовом поясе сотрудника попадает
+// в [from, to). 29 февраля в невисокосный год переносится по правилу policy. Напоминания
+// получают только работающие (не в отпуске), об уволенных и уволенным оповещаний нет.
+// Оповещания о произвольных событиях добавляет dueEventNotifications
+func (d *DB) GetDueNotifications(from, to time.Time, policy calendar.LeapPolicy) ([]Notification, error) {
+	rows, err := d.dB.Query(
+		`SELECT 'reminder', s.event, s.next_fire_at, s.lead_seconds, `+employeeColumnsAs("r")+`, `+employeeColumnsAs("t")+`
+		FROM subscriptions s
+		JOIN employees r ON r.id = s.subscriber_id
+		JOIN employees t ON t.id = s.target_id
+		WHERE s.next_fire_at < $2::TIMESTAMPTZ AND r.status = 'active' AND t.status <> 'terminated'
+			AND (s.event = 'birthday' OR t.hire_date IS NOT NULL)
+		UNION ALL
+		SELECT 'greeting', b.event, b.fire_at, 0, `+employeeColumnsAs("e")+`, `+employeeColumnsAs("e")+`
+		FROM employees e
+		CROSS JOIN LATERAL (
+			SELECT ev.event, birthday_in(ev.day, y, $3)::TIMESTAMP AT TIME ZONE e.timezone AS fire_at
+			FROM (VALUES ('birthday', e.birth_date), ('anniversary', e.hire_date)) ev (event, day)
+			CROSS JOIN generate_series(
+				EXTRACT(YEAR FROM $1::TIMESTAMPTZ AT TIME ZONE 'UTC')::INT - 1,
+				EXTRACT(YEAR FROM $2::TIMESTAMPTZ AT TIME ZONE 'UTC')::INT + 1) y
+			-- годовщины считаются с первой, в год приема поздравлять не с чем
+			WHERE ev.day IS NOT NULL AND (ev.event = 'birthday' OR y > EXTRACT(YEAR FROM ev.day))
+		) b
+		WHERE b.fire_at >= $1::TIMESTAMPTZ AND b.fire_at < $2::TIMESTAMPTZ
+			AND e.status <> 'terminated'
+			AND EXISTS (SELECT 1 FROM subscriptions s WHERE s.subscriber_id = e.id)
+		ORDER BY 3`,
+		from, to, string(policy))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		var n Notification
+		var leadSeconds int64
+		fields := append([]interface{}{&n.Kind, &n.Event, &n.FireAt, &leadSeconds}, employeeFields(&n.Recipient)...)
+		if err = rows.Scan(append(fields, employeeFields(&n.Target)...)...); err != nil {
+			return nil, err
+		}
+		n.LeadTime = time.Duration(leadSeconds) * time.Second
+		notifications = append(notifications, n)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	events, err := d.dueEventNotifications(from, to, policy)
+	if err != nil {
+		return nil, err
+	}
+	notifications = append(notifications, events...)
+	sortNotifications(notifications)
+
+	return notifications, nil
+}
+
+// SetNextFireAt переносит на s.NextFireAt время оповещания s.LeadTime по подписке
+func (d *DB) SetNextFireAt(s Subscription) error {
+	result, err := d.dB.Exec(
+		`UPDATE subscriptions s SET next_fire_at = $1
+		WHERE s.subscriber_id = $2 AND s.target_id = $3 AND s.event = $4 AND s.lead_seconds = $5`,
+		s.NextFireAt, s.SubscriberID, s.TargetID, s.Event, int64(s.LeadTime/time.Second))
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrSubscriptionNotFound
+	}
+
+	return nil
+}
+
+// GetUngroupedSubscribers неуволенные сотрудники с подписками, которых еще нет в группе telegram
+func (d *DB) GetUngroupedSubscribers() ([]Employee, error) {
+	return d.queryEmployees(
+		`SELECT ` + employeeColumns + `
+		FROM employees e
+		WHERE NOT e.in_tg_group AND e.status <> 'terminated'
+			AND EXISTS (SELECT 1 FROM subscriptions s WHERE s.subscriber_id = e.id)`)
+}
+
+// GetGroupedTerminated уволенные сотрудники, которые еще состоят в группе telegram
+func (d *DB) GetGroupedTerminated() ([]Employee, error) {
+	return d.queryEmployees(
+		`SELECT ` + employeeColumns + ` FROM employees e WHERE e.in_tg_group AND e.status = 'terminated'`)
+}
+
+// GetWatermark до какого момента уже обработаны оповещания (нулевое время, если еще ни разу)
+func (d *DB) GetWatermark() (time.Time, error) {
+	var processedUntil time.Time
+	err := d.dB.QueryRow(
+		`SELECT s.processed_until FROM scheduler_state s WHERE s.name = 'notifications'`).Scan(&processedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+
+	return processedUntil, err
+}
+
+// SetWatermark сохранит момент, до которого обработаны оповещания
+func (d *DB) SetWatermark(processedUntil time.Time) error {
+	_, err := d.dB.Exec(
+		`INSERT INTO scheduler_state (name, processed_until) VALUES ('notifications', $1)
+		ON CONFLICT (name) DO UPDATE SET processed_until = EXCLUDED.processed_until`,
+		processedUntil)
+
+	return err
+}
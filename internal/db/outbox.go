@@ -0,0 +1,178 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// OutboxStatus состояние сообщения в outbox
+type OutboxStatus string
+
+const (
+	OutboxPending   OutboxStatus = "pending"   // ждет отправки (или повторной попытки)
+	OutboxDelivered OutboxStatus = "delivered" // доставлено
+	OutboxDead      OutboxStatus = "dead"      // не удалось доставить, попытки исчерпаны
+)
+
+// OutboxMessage исходящее оповещание
+type OutboxMessage struct {
+	ID            int64        `json:"id"`
+	DedupKey      string       `json:"dedup_key"` // одно и то же оповещание ставится в очередь один раз
+	ChatID        int64        `json:"chat_id"`
+	Text          string       `json:"text"`
+	Status        OutboxStatus `json:"status"`
+	Attempts      int          `json:"attempts"`
+	NextAttemptAt time.Time    `json:"next_attempt_at"`
+	LastError     string       `json:"last_error"`
+	CreatedAt     time.Time    `json:"created_at"`
+	DeliveredAt   time.Time    `json:"delivered_at"`
+}
+
+// OutboxStore очередь исходящих оповещаний
+type OutboxStore interface {
+	Enqueue(m OutboxMessage, advance *Subscription) error
+	ClaimOutbox(now, leaseUntil time.Time, limit int) ([]OutboxMessage, error)
+	MarkDelivered(id int64, at time.Time) error
+	MarkFailed(id int64, lastError string, nextAttemptAt time.Time, dead bool) error
+	GetDeadLetters(limit int) ([]OutboxMessage, error)
+}
+
+// outboxColumns колонки outbox в порядке сканирования scanOutboxMessage
+const outboxColumns = `o.id, o.dedup_key, o.chat_id, o.message, o.status, o.attempts,
+	o.next_attempt_at, o.last_error, o.created_at, o.delivered_at`
+
+// scanOutboxMessage читает сообщение, выбранное через outboxColumns
+func scanOutboxMessage(row scanner) (OutboxMessage, error) {
+	var m OutboxMessage
+	var deliveredAt sql.NullTime
+	err := row.Scan(&m.ID, &m.DedupKey, &m.ChatID, &m.Text, &m.Status, &m.Attempts,
+		&m.NextAttemptAt, &m.LastError, &m.CreatedAt, &deliveredAt)
+	m.DeliveredAt = deliveredAt.Time
+
+	return m, err
+}
+
+// Enqueue в одной транзакции ставит сообщение в очередь (повтор с тем же DedupKey игнорируется)
+// и, если advance задан, переносит время следующего напоминания по подписке
+func (d *DB) Enqueue(m OutboxMessage, advance *Subscription) error {
+	tx, err := d.dB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = insertOutbox(tx, m); err != nil {
+		return err
+	}
+
+	if advance != nil {
+		leadSeconds := int64(advance.LeadTime / time.Second)
+		query, args := `UPDATE subscriptions s SET next_fire_at = $1
+			WHERE s.subscriber_id = $2 AND s.target_id = $3 AND s.event = $4 AND s.lead_seconds = $5`,
+			[]interface{}{advance.NextFireAt, advance.SubscriberID, advance.TargetID, advance.Event, leadSeconds}
+		if advance.Event == EventCustom {
+			query, args = `UPDATE event_subscriptions s SET next_fire_at = $1
+				WHERE s.subscriber_id = $2 AND s.event_id = $3 AND s.lead_seconds = $4`,
+				[]interface{}{advance.NextFireAt, advance.SubscriberID, advance.EventID.UUID, leadSeconds}
+		}
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		if count, err := result.RowsAffected(); err != nil {
+			return err
+		} else if count == 0 {
+			return ErrSubscriptionNotFound
+		}
+	}
+
+	return tx.Commit()
+}
+
+// insertOutbox ставит сообщение в очередь, если сообщения с тем же DedupKey там еще нет
+func insertOutbox(x execer, m OutboxMessage) error {
+	_, err := x.Exec(
+		`INSERT INTO outbox (dedup_key, chat_id, message)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (dedup_key) DO NOTHING`,
+		m.DedupKey, m.ChatID, m.Text)
+
+	return err
+}
+
+// ClaimOutbox заберет готовые к отправке сообщения, продлив им next_attempt_at до leaseUntil,
+// чтобы их не отправил параллельно другой диспетчер
+func (d *DB) ClaimOutbox(now, leaseUntil time.Time, limit int) ([]OutboxMessage, error) {
+	rows, err := d.dB.Query(
+		`UPDATE outbox o SET next_attempt_at = $2
+		WHERE o.id IN (
+			SELECT x.id FROM outbox x
+			WHERE x.status = 'pending' AND x.next_attempt_at <= $1
+			ORDER BY x.next_attempt_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED)
+		RETURNING `+outboxColumns,
+		now, leaseUntil, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return readOutbox(rows)
+}
+
+// MarkDelivered отметит сообщение доставленным
+func (d *DB) MarkDelivered(id int64, at time.Time) error {
+	_, err := d.dB.Exec(
+		`UPDATE outbox o SET status = 'delivered', attempts = o.attempts + 1, delivered_at = $1, last_error = ''
+		WHERE o.id = $2`,
+		at, id)
+
+	return err
+}
+
+// MarkFailed учтет неудачную попытку: назначит следующую или переведет сообщение в dead
+func (d *DB) MarkFailed(id int64, lastError string, nextAttemptAt time.Time, dead bool) error {
+	status := OutboxPending
+	if dead {
+		status = OutboxDead
+	}
+
+	_, err := d.dB.Exec(
+		`UPDATE outbox o SET status = $1, attempts = o.attempts + 1, next_attempt_at = $2, last_error = $3
+		WHERE o.id = $4`,
+		status, nextAttemptAt, lastError, id)
+
+	return err
+}
+
+// GetDeadLetters последние сообщения, которые так и не удалось доставить
+func (d *DB) GetDeadLetters(limit int) ([]OutboxMessage, error) {
+	rows, err := d.dB.Query(
+		`SELECT `+outboxColumns+`
+		FROM outbox o
+		WHERE o.status = 'dead'
+		ORDER BY o.created_at DESC
+		LIMIT $1`,
+		limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return readOutbox(rows)
+}
+
+// readOutbox прочитает и закроет выборку сообщений
+func readOutbox(rows *sql.Rows) ([]OutboxMessage, error) {
+	defer rows.Close()
+
+	var messages []OutboxMessage
+	for rows.Next() {
+		m, err := scanOutboxMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+
+	return messages, rows.Err()
+}
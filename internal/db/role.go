@@ -0,0 +1,29 @@
+package db
+
+import "fmt"
+
+// Role роль сотрудника в боте, каждая следующая включает права предыдущих
+type Role string
+
+const (
+	RoleEmployee Role = "employee" // подписки на коллег
+	RoleHR       Role = "hr"       // кадровые данные сотрудников
+	RoleAdmin    Role = "admin"    // все, включая управление сотрудниками и доставкой
+)
+
+// roleLevels порядок ролей по возрастанию прав
+var roleLevels = map[Role]int{RoleEmployee: 1, RoleHR: 2, RoleAdmin: 3}
+
+// ParseRole разберет роль
+func ParseRole(s string) (Role, error) {
+	if _, ok := roleLevels[Role(s)]; !ok {
+		return "", fmt.Errorf("неизвестная роль: %q (ожидается %s, %s или %s)", s, RoleEmployee, RoleHR, RoleAdmin)
+	}
+
+	return Role(s), nil
+}
+
+// AtLeast есть ли у роли права роли min
+func (r Role) AtLeast(min Role) bool {
+	return roleLevels[r] >= roleLevels[min]
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// ErrSessionNotFound сессия не найдена
+var ErrSessionNotFound = errors.New("сессия не найдена")
+
+// Session сессия входа в бота: на нее выдается JWT-токен (jti = ID)
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	EmployeeID uuid.UUID `json:"employee_id"`
+	TelegramID int64     `json:"telegram_id"`
+	IssuedAt   time.Time `json:"issued_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	RevokedAt  time.Time `json:"revoked_at"` // нулевое время - не отозвана
+}
+
+// Active не истекла и не отозвана к моменту now
+func (s Session) Active(now time.Time) bool {
+	return s.RevokedAt.IsZero() && now.Before(s.ExpiresAt)
+}
+
+// SessionStore сессии входа, отзыв делает токен недействительным до истечения срока
+type SessionStore interface {
+	CreateSession(s Session) error
+	GetSession(id uuid.UUID) (Session, error)
+	RevokeSession(id uuid.UUID, at time.Time) error
+	RevokeSessions(employeeID uuid.UUID, at time.Time) error
+}
+
+// CreateSession сохранит новую сессию
+func (d *DB) CreateSession(s Session) error {
+	_, err := d.dB.Exec(
+		`INSERT INTO sessions (id, employee_id, telegram_id, issued_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		s.ID, s.EmployeeID, s.TelegramID, s.IssuedAt, s.ExpiresAt)
+
+	return err
+}
+
+// GetSession сессия по ID (jti токена)
+func (d *DB) GetSession(id uuid.UUID) (Session, error) {
+	var s Session
+	var revokedAt sql.NullTime
+	err := d.dB.QueryRow(
+		`SELECT s.id, s.employee_id, s.telegram_id, s.issued_at, s.expires_at, s.revoked_at
+		FROM sessions s
+		WHERE s.id = $1`,
+		id).Scan(&s.ID, &s.EmployeeID, &s.TelegramID, &s.IssuedAt, &s.ExpiresAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, ErrSessionNotFound
+	}
+	s.RevokedAt = revokedAt.Time
+
+	return s, err
+}
+
+// RevokeSession отзовет сессию (повторный отзыв ничего не меняет)
+func (d *DB) RevokeSession(id uuid.UUID, at time.Time) error {
+	_, err := d.dB.Exec(
+		`UPDATE sessions s SET revoked_at = $1
+		WHERE s.id = $2 AND s.revoked_at IS NULL`,
+		at, id)
+
+	return err
+}
+
+// RevokeSessions отзовет все сессии сотрудника
+func (d *DB) RevokeSessions(employeeID uuid.UUID, at time.Time) error {
+	_, err := d.dB.Exec(
+		`UPDATE sessions s SET revoked_at = $1
+		WHERE s.employee_id = $2 AND s.revoked_at IS NULL`,
+		at, employeeID)
+
+	return err
+}
@@ -0,0 +1,29 @@
+package db
+
+import "fmt"
+
+// Status статус занятости сотрудника
+type Status string
+
+const (
+	StatusActive     Status = "active"     // работает: в списках, получает все оповещания
+	StatusOnLeave    Status = "on_leave"   // в отпуске или декрете: подписки сохраняются, но напоминания ему не приходят
+	StatusTerminated Status = "terminated" // уволен: не входит в бот, скрыт из списков, его подписки и подписки на него удалены
+)
+
+// statuses допустимые статусы
+var statuses = map[Status]bool{StatusActive: true, StatusOnLeave: true, StatusTerminated: true}
+
+// ParseStatus разберет статус
+func ParseStatus(s string) (Status, error) {
+	if !statuses[Status(s)] {
+		return "", fmt.Errorf("неизвестный статус: %q (ожидается %s, %s или %s)", s, StatusActive, StatusOnLeave, StatusTerminated)
+	}
+
+	return Status(s), nil
+}
+
+// Listed сотрудник виден в списках и поиске, на него можно подписаться
+func (s Status) Listed() bool {
+	return s != StatusTerminated
+}
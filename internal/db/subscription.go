@@ -0,0 +1,138 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq"
+)
+
+// ErrSubscriptionNotFound подписка не найдена
+var ErrSubscriptionNotFound = errors.New("подписка не найдена")
+
+// Subscription подписка сотрудника на оповещения о событии другого сотрудника
+// (на День рождения и годовщину работы подписываются по отдельности). Оповещаний по подписке
+// может быть несколько: каждое - отдельная Subscription со своими LeadTime и NextFireAt
+type Subscription struct {
+	SubscriberID uuid.UUID     `json:"subscriber_id"`
+	TargetID     uuid.UUID     `json:"target_id"`
+	Event        EventKind     `json:"event"`
+	LeadTime     time.Duration `json:"lead_time"` // за сколько до события оповещать
+	NextFireAt   time.Time     `json:"next_fire_at"`
+	TeamID       uuid.NullUUID `json:"team_id"`  // через какую команду заведена (пусто - подписка на самого сотрудника)
+	EventID      uuid.NullUUID `json:"event_id"` // для EventCustom: событие из events (см. EventStore)
+}
+
+// SubscriptionStore хранилище подписок
+type SubscriptionStore interface {
+	AddSubscription(s Subscription) error
+	RemoveSubscription(subscriberID, targetID uuid.UUID, event EventKind) error
+	RemoveSubscriptionLead(subscriberID, targetID uuid.UUID, event EventKind, lead time.Duration) error
+	GetSubscriptions(subscriberID uuid.UUID) ([]Subscription, error)
+	GetSubscribers(targetID uuid.UUID) ([]Subscription, error)
+}
+
+// subscriptionColumns колонки subscriptions в порядке сканирования scanSubscription
+const subscriptionColumns = `s.subscriber_id, s.target_id, s.event, s.lead_seconds, s.next_fire_at, s.team_id`
+
+// scanSubscription читает подписку, выбранную через subscriptionColumns
+func scanSubscription(row scanner) (Subscription, error) {
+	var s Subscription
+	var leadSeconds int64
+	err := row.Scan(&s.SubscriberID, &s.TargetID, &s.Event, &leadSeconds, &s.NextFireAt, &s.TeamID)
+	s.LeadTime = time.Duration(leadSeconds) * time.Second
+
+	return s, err
+}
+
+// AddSubscription добавит к подписке оповещание за s.LeadTime или обновит его время и источник
+func (d *DB) AddSubscription(s Subscription) error {
+	_, err := d.dB.Exec(
+		`INSERT INTO subscriptions (subscriber_id, target_id, event, lead_seconds, next_fire_at, team_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (subscriber_id, target_id, event, lead_seconds)
+		DO UPDATE SET next_fire_at = EXCLUDED.next_fire_at, team_id = EXCLUDED.team_id`,
+		s.SubscriberID, s.TargetID, s.Event, int64(s.LeadTime/time.Second), s.NextFireAt, s.TeamID)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation" {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// RemoveSubscription удаляет подписку на событие со всеми ее оповещаниями
+func (d *DB) RemoveSubscription(subscriberID, targetID uuid.UUID, event EventKind) error {
+	return d.removeSubscriptions(
+		`DELETE FROM subscriptions s
+		WHERE s.subscriber_id = $1 AND s.target_id = $2 AND s.event = $3`,
+		subscriberID, targetID, event)
+}
+
+// RemoveSubscriptionLead удаляет одно оповещание подписки
+func (d *DB) RemoveSubscriptionLead(subscriberID, targetID uuid.UUID, event EventKind, lead time.Duration) error {
+	return d.removeSubscriptions(
+		`DELETE FROM subscriptions s
+		WHERE s.subscriber_id = $1 AND s.target_id = $2 AND s.event = $3 AND s.lead_seconds = $4`,
+		subscriberID, targetID, event, int64(lead/time.Second))
+}
+
+// removeSubscriptions выполнит удаление (ErrSubscriptionNotFound, если удалять нечего)
+func (d *DB) removeSubscriptions(query string, args ...interface{}) error {
+	result, err := d.dB.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrSubscriptionNotFound
+	}
+
+	return nil
+}
+
+// GetSubscriptions возвращает подписки сотрудника (на кого он подписан)
+func (d *DB) GetSubscriptions(subscriberID uuid.UUID) ([]Subscription, error) {
+	return d.querySubscriptions(
+		`SELECT `+subscriptionColumns+`
+		FROM subscriptions s
+		WHERE s.subscriber_id = $1
+		ORDER BY s.next_fire_at`,
+		subscriberID)
+}
+
+// GetSubscribers возвращает подписки на сотрудника (кто на него подписан)
+func (d *DB) GetSubscribers(targetID uuid.UUID) ([]Subscription, error) {
+	return d.querySubscriptions(
+		`SELECT `+subscriptionColumns+`
+		FROM subscriptions s
+		WHERE s.target_id = $1
+		ORDER BY s.next_fire_at`,
+		targetID)
+}
+
+// querySubscriptions выполнит запрос и прочитает список подписок
+func (d *DB) querySubscriptions(query string, args ...interface{}) ([]Subscription, error) {
+	rows, err := d.dB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []Subscription
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+
+	return subscriptions, rows.Err()
+}
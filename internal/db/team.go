@@ -0,0 +1,318 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq"
+)
+
+// TeamKind вид группы сотрудников
+type TeamKind string
+
+const (
+	TeamKindDepartment TeamKind = "department" // отдел
+	TeamKindTeam       TeamKind = "team"       // команда
+)
+
+// ErrTeamNotFound команда не найдена
+var ErrTeamNotFound = errors.New("команда не найдена")
+
+// ErrTeamExists команда с таким названием уже есть
+var ErrTeamExists = errors.New("команда с таким названием уже есть")
+
+// Team отдел или команда
+type Team struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	Kind TeamKind  `json:"kind"`
+}
+
+// TeamMember участие сотрудника в команде
+type TeamMember struct {
+	TeamID     uuid.UUID `json:"team_id"`
+	EmployeeID uuid.UUID `json:"employee_id"`
+}
+
+// TeamSubscription одно оповещание подписки сотрудника на всю команду (у подписки их может быть
+// несколько): по нему в subscriptions заводятся подписки на каждого участника (с TeamID команды),
+// их поддерживает в актуальном состоянии handle
+type TeamSubscription struct {
+	SubscriberID uuid.UUID     `json:"subscriber_id"`
+	TeamID       uuid.UUID     `json:"team_id"`
+	LeadTime     time.Duration `json:"lead_time"` // за сколько до Дня рождения оповещать
+}
+
+// TeamStore хранилище отделов и команд
+type TeamStore interface {
+	CreateTeam(t Team) (Team, error)
+	GetTeam(t Team) (Team, error)
+	GetTeams() ([]Team, error)
+	DeleteTeam(id uuid.UUID) error
+	AddTeamMember(teamID, employeeID uuid.UUID) error
+	RemoveTeamMember(teamID, employeeID uuid.UUID) error
+	GetTeamMembers(teamID uuid.UUID) ([]Employee, error)
+	GetEmployeeTeams(employeeID uuid.UUID) ([]Team, error)
+	AddTeamSubscription(s TeamSubscription) error
+	RemoveTeamSubscription(subscriberID, teamID uuid.UUID) error
+	RemoveTeamSubscriptionLead(subscriberID, teamID uuid.UUID, lead time.Duration) error
+	GetTeamSubscriptions(subscriberID uuid.UUID) ([]TeamSubscription, error)
+	GetTeamSubscribers(teamID uuid.UUID) ([]TeamSubscription, error)
+}
+
+// CreateTeam создаст команду (название уникально без учета регистра)
+func (d *DB) CreateTeam(t Team) (Team, error) {
+	t.ID = uuid.Must(uuid.NewV4())
+	if t.Kind == "" {
+		t.Kind = TeamKindTeam
+	}
+
+	_, err := d.dB.Exec(`INSERT INTO teams (id, name, kind) VALUES ($1, $2, $3)`, t.ID, t.Name, t.Kind)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+		return Team{}, ErrTeamExists
+	}
+
+	return t, err
+}
+
+// GetTeam найдет команду по ID или, если он не задан, по названию (без учета регистра)
+func (d *DB) GetTeam(t Team) (Team, error) {
+	var row *sql.Row
+	switch {
+	case t.ID != uuid.Nil:
+		row = d.dB.QueryRow(`SELECT t.id, t.name, t.kind FROM teams t WHERE t.id = $1`, t.ID)
+	case t.Name != "":
+		row = d.dB.QueryRow(`SELECT t.id, t.name, t.kind FROM teams t WHERE lower(t.name) = lower($1)`, t.Name)
+	default:
+		return t, ErrTeamNotFound
+	}
+
+	err := row.Scan(&t.ID, &t.Name, &t.Kind)
+	if errors.Is(err, sql.ErrNoRows) {
+		return t, ErrTeamNotFound
+	}
+
+	return t, err
+}
+
+// GetTeams все команды: сначала отделы, затем по названию
+func (d *DB) GetTeams() ([]Team, error) {
+	return d.queryTeams(`SELECT t.id, t.name, t.kind FROM teams t ORDER BY t.kind, t.name`)
+}
+
+// DeleteTeam удалит команду вместе с участием, подписками на нее, подписками, заведенными по ним,
+// и дайджестами по команде (каскадом в Postgres)
+func (d *DB) DeleteTeam(id uuid.UUID) error {
+	result, err := d.dB.Exec(`DELETE FROM teams t WHERE t.id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return ErrTeamNotFound
+	}
+
+	return nil
+}
+
+// AddTeamMember добавит сотрудника в команду (повторное добавление ничего не меняет)
+func (d *DB) AddTeamMember(teamID, employeeID uuid.UUID) error {
+	_, err := d.dB.Exec(
+		`INSERT INTO team_members (team_id, employee_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		teamID, employeeID)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation" {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// RemoveTeamMember уберет сотрудника из команды
+func (d *DB) RemoveTeamMember(teamID, employeeID uuid.UUID) error {
+	result, err := d.dB.Exec(
+		`DELETE FROM team_members m WHERE m.team_id = $1 AND m.employee_id = $2`, teamID, employeeID)
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// GetTeamMembers участники команды по фамилии и имени (вместе с деактивированными)
+func (d *DB) GetTeamMembers(teamID uuid.UUID) ([]Employee, error) {
+	return d.queryEmployees(
+		`SELECT `+employeeColumns+`
+		FROM employees e
+		JOIN team_members m ON m.employee_id = e.id
+		WHERE m.team_id = $1
+		ORDER BY e.last_name, e.first_name, e.id`,
+		teamID)
+}
+
+// GetEmployeeTeams в каких командах состоит сотрудник
+func (d *DB) GetEmployeeTeams(employeeID uuid.UUID) ([]Team, error) {
+	return d.queryTeams(
+		`SELECT t.id, t.name, t.kind
+		FROM teams t
+		JOIN team_members m ON m.team_id = t.id
+		WHERE m.employee_id = $1
+		ORDER BY t.kind, t.name`,
+		employeeID)
+}
+
+// AddTeamSubscription добавит к подписке на команду оповещание за s.LeadTime (повторное
+// добавление ничего не меняет)
+func (d *DB) AddTeamSubscription(s TeamSubscription) error {
+	_, err := d.dB.Exec(
+		`INSERT INTO team_subscriptions (subscriber_id, team_id, lead_seconds)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`,
+		s.SubscriberID, s.TeamID, int64(s.LeadTime/time.Second))
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation" {
+		return ErrTeamNotFound
+	}
+
+	return err
+}
+
+// RemoveTeamSubscription в одной транзакции удалит подписку на команду со всеми оповещаниями
+// и заведенные по ней подписки на участников (подписки на самих сотрудников остаются)
+func (d *DB) RemoveTeamSubscription(subscriberID, teamID uuid.UUID) error {
+	return d.removeTeamSubscriptions(
+		`DELETE FROM team_subscriptions ts WHERE ts.subscriber_id = $1 AND ts.team_id = $2`,
+		`DELETE FROM subscriptions s WHERE s.subscriber_id = $1 AND s.team_id = $2`,
+		subscriberID, teamID)
+}
+
+// RemoveTeamSubscriptionLead в одной транзакции удалит одно оповещание подписки на команду
+// и заведенные по нему подписки на участников
+func (d *DB) RemoveTeamSubscriptionLead(subscriberID, teamID uuid.UUID, lead time.Duration) error {
+	return d.removeTeamSubscriptions(
+		`DELETE FROM team_subscriptions ts
+		WHERE ts.subscriber_id = $1 AND ts.team_id = $2 AND ts.lead_seconds = $3`,
+		`DELETE FROM subscriptions s
+		WHERE s.subscriber_id = $1 AND s.team_id = $2 AND s.lead_seconds = $3`,
+		subscriberID, teamID, int64(lead/time.Second))
+}
+
+// removeTeamSubscriptions в одной транзакции выполнит удаление из team_subscriptions
+// (ErrSubscriptionNotFound, если удалять нечего) и из subscriptions с теми же аргументами
+func (d *DB) removeTeamSubscriptions(teamQuery, query string, args ...interface{}) error {
+	tx, err := d.dB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(teamQuery, args...)
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return ErrSubscriptionNotFound
+	}
+
+	if _, err = tx.Exec(query, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetTeamSubscriptions на какие команды подписан сотрудник: оповещания одной команды идут подряд,
+// от самого раннего
+func (d *DB) GetTeamSubscriptions(subscriberID uuid.UUID) ([]TeamSubscription, error) {
+	return d.queryTeamSubscriptions(
+		`SELECT ts.subscriber_id, ts.team_id, ts.lead_seconds
+		FROM team_subscriptions ts
+		JOIN teams t ON t.id = ts.team_id
+		WHERE ts.subscriber_id = $1
+		ORDER BY t.kind, t.name, ts.lead_seconds DESC`,
+		subscriberID)
+}
+
+// GetTeamSubscribers кто подписан на команду (по строке на каждое оповещание)
+func (d *DB) GetTeamSubscribers(teamID uuid.UUID) ([]TeamSubscription, error) {
+	return d.queryTeamSubscriptions(
+		`SELECT ts.subscriber_id, ts.team_id, ts.lead_seconds
+		FROM team_subscriptions ts
+		WHERE ts.team_id = $1`,
+		teamID)
+}
+
+// queryTeams выполнит запрос и прочитает список команд
+func (d *DB) queryTeams(query string, args ...interface{}) ([]Team, error) {
+	rows, err := d.dB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var teams []Team
+	for rows.Next() {
+		var t Team
+		if err = rows.Scan(&t.ID, &t.Name, &t.Kind); err != nil {
+			return nil, err
+		}
+		teams = append(teams, t)
+	}
+
+	return teams, rows.Err()
+}
+
+// queryTeamSubscriptions выполнит запрос и прочитает список подписок на команды
+func (d *DB) queryTeamSubscriptions(query string, args ...interface{}) ([]TeamSubscription, error) {
+	rows, err := d.dB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []TeamSubscription
+	for rows.Next() {
+		var s TeamSubscription
+		var leadSeconds int64
+		if err = rows.Scan(&s.SubscriberID, &s.TeamID, &leadSeconds); err != nil {
+			return nil, err
+		}
+		s.LeadTime = time.Duration(leadSeconds) * time.Second
+		subscriptions = append(subscriptions, s)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+// teamMembers участие всех сотрудников в командах (для Snapshot)
+func (d *DB) teamMembers() ([]TeamMember, error) {
+	rows, err := d.dB.Query(`SELECT m.team_id, m.employee_id FROM team_members m`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []TeamMember
+	for rows.Next() {
+		var m TeamMember
+		if err = rows.Scan(&m.TeamID, &m.EmployeeID); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+
+	return members, rows.Err()
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrEmptyUpdate в обновлении не задано ни одного поля
+var ErrEmptyUpdate = errors.New("пустое обновление")
+
+// EmployeeUpdate набор изменений сотрудника, в db пишутся только заданные поля.
+// Сеттеры возвращают копию, поэтому обновления можно собирать цепочкой:
+//
+//	db.EmployeeUpdate{}.SetToken(token).SetTelegramID(id)
+type EmployeeUpdate struct {
+	fields []updateField
+}
+
+// updateField одна колонка обновления
+type updateField struct {
+	column string
+	value  interface{}
+	apply  func(e *Employee)
+}
+
+// with добавит поле, заменив ранее заданное значение той же колонки
+func (u EmployeeUpdate) with(column string, value interface{}, apply func(e *Employee)) EmployeeUpdate {
+	fields := make([]updateField, 0, len(u.fields)+1)
+	for _, f := range u.fields {
+		if f.column != column {
+			fields = append(fields, f)
+		}
+	}
+
+	return EmployeeUpdate{fields: append(fields, updateField{column: column, value: value, apply: apply})}
+}
+
+// IsEmpty true, если не задано ни одного поля
+func (u EmployeeUpdate) IsEmpty() bool {
+	return len(u.fields) == 0
+}
+
+// Apply применит изменения к сотруднику
+func (u EmployeeUpdate) Apply(e *Employee) {
+	for _, f := range u.fields {
+		f.apply(e)
+	}
+}
+
+func (u EmployeeUpdate) SetTelegramID(id int64) EmployeeUpdate {
+	return u.with("telegram_id", id, func(e *Employee) { e.TelegramID = id })
+}
+
+func (u EmployeeUpdate) SetToken(token string) EmployeeUpdate {
+	return u.with("token", token, func(e *Employee) { e.Token = token })
+}
+
+func (u EmployeeUpdate) SetInTgGroup(inGroup bool) EmployeeUpdate {
+	return u.with("in_tg_group", inGroup, func(e *Employee) { e.InTgGroup = inGroup })
+}
+
+func (u EmployeeUpdate) SetTimezone(timezone string) EmployeeUpdate {
+	return u.with("timezone", timezone, func(e *Employee) { e.Timezone = timezone })
+}
+
+func (u EmployeeUpdate) SetFirstName(name string) EmployeeUpdate {
+	return u.with("first_name", name, func(e *Employee) { e.FirstName = name })
+}
+
+func (u EmployeeUpdate) SetPatronymic(name string) EmployeeUpdate {
+	return u.with("patronymic", name, func(e *Employee) { e.Patronymic = name })
+}
+
+func (u EmployeeUpdate) SetLastName(name string) EmployeeUpdate {
+	return u.with("last_name", name, func(e *Employee) { e.LastName = name })
+}
+
+func (u EmployeeUpdate) SetEmail(email string) EmployeeUpdate {
+	return u.with("email", email, func(e *Employee) { e.Email = email })
+}
+
+func (u EmployeeUpdate) SetBirthDate(date time.Time) EmployeeUpdate {
+	return u.with("birth_date", date, func(e *Employee) { e.BirthDate = date })
+}
+
+func (u EmployeeUpdate) SetHireDate(date time.Time) EmployeeUpdate {
+	hireDate := sql.NullTime{Time: date, Valid: !date.IsZero()}
+	return u.with("hire_date", hireDate, func(e *Employee) { e.HireDate = hireDate })
+}
+
+func (u EmployeeUpdate) SetRole(role Role) EmployeeUpdate {
+	return u.with("role", role, func(e *Employee) { e.Role = role })
+}
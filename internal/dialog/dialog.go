@@ -0,0 +1,137 @@
+package dialog
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"birthdayGreetings/internal/clock"
+
+	"github.com/gofrs/uuid"
+)
+
+// State шаг многошагового диалога с пользователем
+type State string
+
+const (
+	Idle             State = ""                  // диалога нет, текст не ожидается
+	AwaitEmail       State = "await_email"       // вход: ждем email
+	AwaitPassword    State = "await_password"    // вход: ждем код из письма
+	AwaitRebind      State = "await_rebind"      // вход: ждем согласия привязать учетную запись к новому telegram
+	AwaitSubscribe   State = "await_subscribe"   // ждем UUID для подписки
+	AwaitUnsubscribe State = "await_unsubscribe" // ждем UUID для отписки
+	AwaitImport      State = "await_import"      // ждем подтверждения импорта сотрудников из таблицы
+)
+
+// spec правила состояния: сколько оно живет, может ли начать диалог и куда из него можно перейти
+type spec struct {
+	timeout time.Duration
+	entry   bool
+	next    []State
+}
+
+// states все состояния диалогов. Новый диалог - новые состояния здесь, без колонок в employees
+var states = map[State]spec{
+	AwaitEmail:       {timeout: 15 * time.Minute, entry: true, next: []State{AwaitPassword}},
+	AwaitPassword:    {timeout: time.Hour, next: []State{AwaitRebind}}, // сам код истекает раньше, по LOGIN_CODE_TTL
+	AwaitRebind:      {timeout: 10 * time.Minute},
+	AwaitSubscribe:   {timeout: 30 * time.Minute, entry: true},
+	AwaitUnsubscribe: {timeout: 30 * time.Minute, entry: true},
+	AwaitImport:      {timeout: 30 * time.Minute, entry: true},
+}
+
+// ErrTransition переход не разрешен правилами состояния
+var ErrTransition = errors.New("недопустимый переход диалога")
+
+// Conversation текущий диалог пользователя telegram
+type Conversation struct {
+	TelegramID int64     `json:"telegram_id"`
+	State      State     `json:"state"`
+	EmployeeID uuid.UUID `json:"employee_id"` // с кем из сотрудников идет диалог (например, чей email ввели)
+	Data       string    `json:"data"`        // произвольные данные шага
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Store хранилище диалогов (если диалога нет, GetConversation вернет его в состоянии Idle)
+type Store interface {
+	GetConversation(telegramID int64) (Conversation, error)
+	SaveConversation(c Conversation) error
+	DeleteConversation(telegramID int64) error
+}
+
+// Machine ведет диалоги: начинает, переводит между состояниями, завершает и сбрасывает просроченные
+type Machine struct {
+	store Store
+	clock clock.Clock
+}
+
+func NewMachine(store Store, clk clock.Clock) *Machine {
+	return &Machine{store: store, clock: clk}
+}
+
+// Current текущий диалог; если его нет или он просрочен - Idle
+func (m *Machine) Current(telegramID int64) (Conversation, error) {
+	c, err := m.store.GetConversation(telegramID)
+	if err != nil {
+		return Conversation{}, err
+	}
+	if c.State == Idle {
+		return Conversation{TelegramID: telegramID}, nil
+	}
+	if !m.clock.Now().Before(c.ExpiresAt) {
+		return Conversation{TelegramID: telegramID}, m.store.DeleteConversation(telegramID)
+	}
+
+	return c, nil
+}
+
+// Start начнет новый диалог c, прервав текущий (так работают команды)
+func (m *Machine) Start(c Conversation) error {
+	if !states[c.State].entry {
+		return fmt.Errorf("%w: с %q диалог не начинается", ErrTransition, c.State)
+	}
+
+	return m.save(c)
+}
+
+// Transition переведет текущий диалог в состояние to (Idle - завершит его)
+func (m *Machine) Transition(c Conversation, to State) error {
+	if to == Idle {
+		return m.store.DeleteConversation(c.TelegramID)
+	}
+
+	if !allowed(c.State, to) {
+		return fmt.Errorf("%w: %q -> %q", ErrTransition, c.State, to)
+	}
+	c.State = to
+
+	return m.save(c)
+}
+
+// Cancel завершит диалог, вернет состояние, в котором он был (Idle - отменять нечего)
+func (m *Machine) Cancel(telegramID int64) (State, error) {
+	c, err := m.Current(telegramID)
+	if err != nil || c.State == Idle {
+		return Idle, err
+	}
+
+	return c.State, m.store.DeleteConversation(telegramID)
+}
+
+// save сохранит диалог, продлив его на время жизни состояния
+func (m *Machine) save(c Conversation) error {
+	c.ExpiresAt = m.clock.Now().Add(states[c.State].timeout)
+
+	return m.store.SaveConversation(c)
+}
+
+// allowed разрешен ли переход from -> to
+func allowed(from, to State) bool {
+	for _, next := range states[from].next {
+		if next == to {
+			return true
+		}
+	}
+
+	return false
+}
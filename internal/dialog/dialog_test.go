@@ -0,0 +1,151 @@
+package dialog
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"birthdayGreetings/internal/clock"
+)
+
+// mapStore хранилище диалогов в map
+type mapStore map[int64]Conversation
+
+func (s mapStore) GetConversation(telegramID int64) (Conversation, error) {
+	return s[telegramID], nil
+}
+
+func (s mapStore) SaveConversation(c Conversation) error {
+	s[c.TelegramID] = c
+	return nil
+}
+
+func (s mapStore) DeleteConversation(telegramID int64) error {
+	delete(s, telegramID)
+	return nil
+}
+
+func newTestMachine() (*Machine, mapStore, *clock.Fake) {
+	store := mapStore{}
+	clk := clock.NewFake(time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC))
+
+	return NewMachine(store, clk), store, clk
+}
+
+func TestStart(t *testing.T) {
+	tests := []struct {
+		state State
+		ok    bool
+	}{
+		{AwaitEmail, true},
+		{AwaitSubscribe, true},
+		{AwaitUnsubscribe, true},
+		{AwaitImport, true},
+		{AwaitPassword, false}, // только после email
+		{AwaitRebind, false},   // только после кода
+		{Idle, false},
+		{State("unknown"), false},
+	}
+
+	for _, tt := range tests {
+		m, store, _ := newTestMachine()
+		err := m.Start(Conversation{TelegramID: 1, State: tt.state})
+		if tt.ok != (err == nil) || err != nil && !errors.Is(err, ErrTransition) {
+			t.Errorf("Start(%q): err = %v, ожидалось ok=%v", tt.state, err, tt.ok)
+		}
+		if _, saved := store[1]; saved != tt.ok {
+			t.Errorf("Start(%q): диалог сохранен = %v", tt.state, saved)
+		}
+	}
+}
+
+func TestTransition(t *testing.T) {
+	tests := []struct {
+		from, to State
+		ok       bool
+	}{
+		{AwaitEmail, AwaitPassword, true},
+		{AwaitPassword, AwaitRebind, true},
+		{AwaitEmail, AwaitRebind, false},
+		{AwaitPassword, AwaitEmail, false},
+		{AwaitRebind, AwaitPassword, false},
+		{AwaitSubscribe, AwaitUnsubscribe, false},
+		{AwaitImport, AwaitImport, false},
+		{AwaitSubscribe, Idle, true}, // завершить можно любой диалог
+		{AwaitRebind, Idle, true},
+	}
+
+	for _, tt := range tests {
+		m, store, _ := newTestMachine()
+		store[1] = Conversation{TelegramID: 1, State: tt.from, Data: "шаг"}
+		err := m.Transition(store[1], tt.to)
+		if tt.ok != (err == nil) || err != nil && !errors.Is(err, ErrTransition) {
+			t.Errorf("%q -> %q: err = %v, ожидалось ok=%v", tt.from, tt.to, err, tt.ok)
+		}
+
+		want := tt.from
+		if tt.ok {
+			want = tt.to
+		}
+		if got := store[1].State; got != want {
+			t.Errorf("%q -> %q: состояние %q, want %q", tt.from, tt.to, got, want)
+		}
+	}
+}
+
+// Диалог живет timeout своего состояния с последнего шага, просроченный сбрасывается в Idle
+func TestTimeout(t *testing.T) {
+	m, store, clk := newTestMachine()
+	if err := m.Start(Conversation{TelegramID: 1, State: AwaitEmail, Data: "ivanov@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	clk.Advance(states[AwaitEmail].timeout - time.Second)
+	c, err := m.Current(1)
+	if err != nil || c.State != AwaitEmail || c.Data != "ivanov@example.com" {
+		t.Fatalf("до истечения: %+v, %v", c, err)
+	}
+
+	// переход продлевает диалог на время жизни нового состояния
+	if err = m.Transition(c, AwaitPassword); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(states[AwaitPassword].timeout - time.Second)
+	if c, err = m.Current(1); err != nil || c.State != AwaitPassword {
+		t.Fatalf("после перехода: %+v, %v", c, err)
+	}
+
+	clk.Advance(time.Second)
+	if c, err = m.Current(1); err != nil || c.State != Idle || c.TelegramID != 1 {
+		t.Errorf("после истечения: %+v, %v", c, err)
+	}
+	if _, ok := store[1]; ok {
+		t.Error("просроченный диалог не удален")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	m, store, clk := newTestMachine()
+	if state, err := m.Cancel(1); err != nil || state != Idle {
+		t.Errorf("без диалога: %q, %v", state, err)
+	}
+
+	if err := m.Start(Conversation{TelegramID: 1, State: AwaitSubscribe}); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := m.Cancel(1); err != nil || state != AwaitSubscribe {
+		t.Errorf("отмена: %q, %v", state, err)
+	}
+	if _, ok := store[1]; ok {
+		t.Error("отмененный диалог не удален")
+	}
+
+	// просроченный диалог отменять уже нечего
+	if err := m.Start(Conversation{TelegramID: 1, State: AwaitImport}); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(states[AwaitImport].timeout)
+	if state, err := m.Cancel(1); err != nil || state != Idle {
+		t.Errorf("просроченный: %q, %v", state, err)
+	}
+}
@@ -0,0 +1,357 @@
+package handle
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"birthdayGreetings/internal/db"
+
+	"github.com/gofrs/uuid"
+	tb "gopkg.in/telebot.v3"
+)
+
+// deadLettersLimit сколько недоставленных сообщений показывать
+const deadLettersLimit = 10
+
+// AddEmployee добавляет сотрудника: /addemployee Фамилия;Имя;Отчество;email;ГГГГ-ММ-ДД[;дата приема]
+func (h *Handle) AddEmployee(c tb.Context) error {
+	if _, err := h.authMiddleware(c, db.RoleAdmin); err != nil {
+		return h.denied(c, err)
+	}
+
+	fields := strings.Split(c.Message().Payload, ";")
+	if len(fields) != 5 && len(fields) != 6 {
+		return c.Send("Формат: /addemployee Фамилия;Имя;Отчество;email;ГГГГ-ММ-ДД[;дата приема ГГГГ-ММ-ДД]\n" +
+			"Например:\n\n/addemployee Иванов;Иван;Иванович;ivanov@example.com;1990-05-14;2019-09-02")
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	employee := db.Employee{LastName: fields[0], FirstName: fields[1], Patronymic: fields[2], Email: fields[3]}
+	if employee.LastName == "" || employee.FirstName == "" {
+		return c.Send("Фамилия и имя обязательны")
+	}
+	if err := h.checkEmail(employee.Email, uuid.Nil); err != nil {
+		return c.Send(err.Error())
+	}
+	birthDate, err := time.Parse(time.DateOnly, fields[4])
+	if err != nil {
+		return c.Send(fmt.Sprintf("Некорректная дата рождения %s, нужен формат ГГГГ-ММ-ДД", fields[4]))
+	}
+	employee.BirthDate = birthDate
+	if len(fields) == 6 && fields[5] != "" {
+		hireDate, err := time.Parse(time.DateOnly, fields[5])
+		if err != nil {
+			return c.Send(fmt.Sprintf("Некорректная дата приема %s, нужен формат ГГГГ-ММ-ДД", fields[5]))
+		}
+		employee.HireDate = sql.NullTime{Time: hireDate, Valid: true}
+	}
+
+	if employee, err = h.db.AddEmployee(employee); err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+
+	return c.Send(fmt.Sprintf("Сотрудник добавлен: %s %s %s\nUUID: %s",
+		employee.LastName, employee.FirstName, employee.Patronymic, employee.ID))
+}
+
+// EditEmployee меняет данные сотрудника: /editemployee <UUID> поле=значение ...
+func (h *Handle) EditEmployee(c tb.Context) error {
+	admin, err := h.authMiddleware(c, db.RoleAdmin)
+	if err != nil {
+		return h.denied(c, err)
+	}
+
+	args := c.Args()
+	if len(args) < 2 {
+		return c.Send("Формат: /editemployee <UUID> поле=значение ...\n" +
+			"Поля: last_name, first_name, patronymic, email, birth_date, hire_date, timezone, role\n" +
+			"Например:\n\n/editemployee b559d2f8-7319-4abb-8d8e-df7c98acff57 last_name=Петрова role=hr")
+	}
+
+	employee, err := h.employeeArg(args[0])
+	if err != nil {
+		return c.Send(err.Error())
+	}
+
+	update := db.EmployeeUpdate{}
+	reschedule := false
+	for _, arg := range args[1:] {
+		field, value, ok := strings.Cut(arg, "=")
+		if !ok || value == "" {
+			return c.Send(fmt.Sprintf("Ожидается поле=значение, получено %s", arg))
+		}
+
+		switch field {
+		case "last_name":
+			update = update.SetLastName(value)
+		case "first_name":
+			update = update.SetFirstName(value)
+		case "patronymic":
+			update = update.SetPatronymic(value)
+		case "email":
+			if err = h.checkEmail(value, employee.ID); err != nil {
+				return c.Send(err.Error())
+			}
+			update = update.SetEmail(value)
+		case "birth_date":
+			date, err := time.Parse(time.DateOnly, value)
+			if err != nil {
+				return c.Send(fmt.Sprintf("Некорректная дата рождения %s, нужен формат ГГГГ-ММ-ДД", value))
+			}
+			update, reschedule = update.SetBirthDate(date), true
+		case "hire_date":
+			date, err := time.Parse(time.DateOnly, value)
+			if err != nil {
+				return c.Send(fmt.Sprintf("Некорректная дата приема %s, нужен формат ГГГГ-ММ-ДД", value))
+			}
+			update, reschedule = update.SetHireDate(date), true
+		case "timezone":
+			loc, err := time.LoadLocation(value)
+			if err != nil || value == "Local" {
+				return c.Send(fmt.Sprintf("Неизвестный часовой пояс %s", value))
+			}
+			update, reschedule = update.SetTimezone(loc.String()), true
+		case "role":
+			role, err := db.ParseRole(value)
+			if err != nil {
+				return c.Send(err.Error())
+			}
+			if employee.ID == admin.ID && role != db.RoleAdmin {
+				return c.Send("Нельзя снять роль администратора с самого себя")
+			}
+			update = update.SetRole(role)
+		default:
+			return c.Send(fmt.Sprintf("Неизвестное поле %s", field))
+		}
+	}
+
+	if err = h.db.PatchEmployee(employee.ID, update); err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+	if reschedule {
+		if err = h.reschedule(employee.ID); err != nil {
+			log.Println(err)
+			return c.Send("Данные изменены, но напоминания пересчитать не удалось")
+		}
+	}
+
+	return c.Send("Данные сотрудника изменены")
+}
+
+// DeactivateEmployee деактивирует (увольняет) сотрудника: он больше не может войти, его подписки
+// и подписки на него удаляются: /deactivate <UUID>
+func (h *Handle) DeactivateEmployee(c tb.Context) error {
+	return h.setStatus(c, db.StatusTerminated)
+}
+
+// LeaveEmployee отправляет сотрудника в отпуск: подписки сохраняются, напоминания ему не приходят: /leave <UUID>
+func (h *Handle) LeaveEmployee(c tb.Context) error {
+	return h.setStatus(c, db.StatusOnLeave)
+}
+
+// ActivateEmployee возвращает сотрудника из отпуска или деактивированного: /activate <UUID>
+func (h *Handle) ActivateEmployee(c tb.Context) error {
+	return h.setStatus(c, db.StatusActive)
+}
+
+// setStatus общая часть /deactivate, /leave и /activate
+func (h *Handle) setStatus(c tb.Context, status db.Status) error {
+	admin, err := h.authMiddleware(c, db.RoleAdmin)
+	if err != nil {
+		return h.denied(c, err)
+	}
+
+	if len(c.Args()) != 1 {
+		return c.Send("Укажите UUID сотрудника")
+	}
+	employee, err := h.employeeArg(c.Args()[0])
+	if err != nil {
+		return c.Send(err.Error())
+	}
+	if employee.ID == admin.ID {
+		return c.Send("Нельзя менять статус самому себе")
+	}
+	if employee.Status == status {
+		return c.Send("У сотрудника уже этот статус")
+	}
+
+	if err = h.db.SetStatus(employee.ID, status); err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+
+	switch status {
+	case db.StatusOnLeave:
+		return c.Send("Сотрудник в отпуске: подписки сохранены, напоминания ему не приходят до /activate")
+	case db.StatusTerminated:
+		// Деактивированный сразу теряет доступ, из группы telegram его уберет планировщик
+		if err = h.db.RevokeSessions(employee.ID, h.clock.Now()); err != nil {
+			log.Println(err)
+		}
+		return c.Send("Сотрудник деактивирован: доступ закрыт, его подписки и подписки на него удалены")
+	}
+
+	if employee.Status == db.StatusTerminated {
+		// Подписчики его команд снова получают о нем напоминания
+		if err = h.syncMemberTeams(employee.ID); err != nil {
+			log.Println(err)
+		}
+		return c.Send("Сотрудник снова активен. Его прежние подписки удалены при деактивации")
+	}
+
+	return c.Send("Сотрудник снова активен")
+}
+
+// DeadLetters показывает последние недоставленные оповещания: /deadletters
+func (h *Handle) DeadLetters(c tb.Context) error {
+	if _, err := h.authMiddleware(c, db.RoleAdmin); err != nil {
+		return h.denied(c, err)
+	}
+
+	messages, err := h.db.GetDeadLetters(deadLettersLimit)
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+	if len(messages) == 0 {
+		return c.Send("Недоставленных оповещаний нет")
+	}
+
+	var b strings.Builder
+	b.WriteString("Последние недоставленные оповещания:\n")
+	for _, m := range messages {
+		fmt.Fprintf(&b, "\n#%d, чат %d, попыток %d, %s\nОшибка: %s\n",
+			m.ID, m.ChatID, m.Attempts, m.CreatedAt.Format("02.01.2006 15:04"), m.LastError)
+	}
+
+	return c.Send(b.String())
+}
+
+// RunScheduler сразу ставит в очередь наступившие оповещания, не дожидаясь часа: /runscheduler
+func (h *Handle) RunScheduler(c tb.Context) error {
+	if _, err := h.authMiddleware(c, db.RoleAdmin); err != nil {
+		return h.denied(c, err)
+	}
+
+	enqueued, digests, err := h.enqueueDue()
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка планировщика: " + err.Error())
+	}
+
+	return c.Send(fmt.Sprintf("Планировщик отработал, поставлено в очередь оповещаний: %d, дайджестов: %d",
+		len(enqueued), len(digests)))
+}
+
+// employeeArg сотрудник по UUID из аргумента команды
+func (h *Handle) employeeArg(arg string) (db.Employee, error) {
+	id, err := uuid.FromString(arg)
+	if err != nil {
+		return db.Employee{}, fmt.Errorf("Некорректный UUID %s", arg)
+	}
+
+	employee, err := h.db.GetEmployee(db.Employee{ID: id})
+	if err != nil {
+		return db.Employee{}, fmt.Errorf("Сотрудник %s не найден", arg)
+	}
+
+	return employee, nil
+}
+
+// checkEmail проверит, что email корректен и не занят другим сотрудником (кроме self),
+// в том числе в другом регистре
+func (h *Handle) checkEmail(email string, self uuid.UUID) error {
+	if !isValidEmail(email) {
+		return fmt.Errorf("Некорректный email %s", email)
+	}
+
+	other, err := h.db.GetEmployee(db.Employee{Email: email})
+	if err == nil && other.ID != self {
+		return fmt.Errorf("Email %s уже занят", email)
+	} else if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return errors.New("Ошибка, попробуйте еще раз")
+	}
+
+	return nil
+}
+
+// reschedule пересчитает время напоминаний по подпискам сотрудника и на него и время
+// его дайджеста (после смены часового пояса, даты рождения или даты приема)
+func (h *Handle) reschedule(employeeID uuid.UUID) error {
+	subscriptions, err := h.db.GetSubscriptions(employeeID)
+	if err != nil {
+		return err
+	}
+	subscribers, err := h.db.GetSubscribers(employeeID)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range append(subscriptions, subscribers...) {
+		subscriber, err := h.db.GetEmployee(db.Employee{ID: s.SubscriberID})
+		if err != nil {
+			return err
+		}
+		target, err := h.db.GetEmployee(db.Employee{ID: s.TargetID})
+		if err != nil {
+			return err
+		}
+
+		var ok bool
+		s.NextFireAt, ok = h.nextReminder(target, s.Event, s.LeadTime, h.clock.Now(), subscriber.Location())
+		if !ok {
+			continue
+		}
+		if err = h.db.SetNextFireAt(s); err != nil {
+			return err
+		}
+	}
+
+	// напоминания о событиях приходят в часовом поясе подписчика
+	events, err := h.db.GetEventSubscriptions(employeeID)
+	if err != nil {
+		return err
+	}
+	for _, s := range events {
+		event, err := h.db.GetEvent(s.EventID.UUID)
+		if err != nil {
+			return err
+		}
+		subscriber, err := h.db.GetEmployee(db.Employee{ID: s.SubscriberID})
+		if err != nil {
+			return err
+		}
+
+		var ok bool
+		s.NextFireAt, ok = h.nextEventReminder(event, s.LeadTime, h.clock.Now(), subscriber.Location())
+		if !ok {
+			continue
+		}
+		if err = h.db.SetEventNextFireAt(s); err != nil {
+			return err
+		}
+	}
+
+	// дайджест приходит в digestHour по местному времени получателя
+	digest, err := h.db.GetDigest(employeeID)
+	if errors.Is(err, db.ErrDigestNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	employee, err := h.db.GetEmployee(db.Employee{ID: employeeID})
+	if err != nil {
+		return err
+	}
+	digest.NextFireAt = nextDigest(digest.Schedule, h.clock.Now(), employee.Location())
+
+	return h.db.SetDigest(digest)
+}
@@ -0,0 +1,37 @@
+package handle
+
+import (
+	"testing"
+
+	"birthdayGreetings/internal/clock"
+	"birthdayGreetings/internal/db"
+
+	"github.com/gofrs/uuid"
+)
+
+// Адрес, отличающийся от занятого только регистром, тоже занят
+func TestCheckEmail(t *testing.T) {
+	owner := db.Employee{ID: uuid.Must(uuid.NewV4()), Email: "ivanov@example.com", Status: db.StatusActive}
+	h := NewHandle(db.NewMemoryDB(db.Snapshot{Employees: []db.Employee{owner}}, clock.Real{}), clock.Real{})
+
+	tests := []struct {
+		name  string
+		email string
+		self  uuid.UUID
+		ok    bool
+	}{
+		{"свободный", "petrov@example.com", uuid.Nil, true},
+		{"занят", "ivanov@example.com", uuid.Nil, false},
+		{"занят в другом регистре", "Ivanov@Example.com", uuid.Nil, false},
+		{"свой в другом регистре", "IVANOV@example.com", owner.ID, true},
+		{"некорректный", "ivanov", uuid.Nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := h.checkEmail(tt.email, tt.self); (err == nil) != tt.ok {
+				t.Errorf("checkEmail(%q) = %v, ожидалось ok=%v", tt.email, err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package handle
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"birthdayGreetings/internal/calendar"
+	"birthdayGreetings/internal/db"
+
+	"github.com/gofrs/uuid"
+	tb "gopkg.in/telebot.v3"
+)
+
+// digestHour во сколько по местному времени получателя приходит дайджест
+const digestHour = 9
+
+// digestLimit сколько Дней рождения перечислять в одном дайджесте
+const digestLimit = 50
+
+// digestSchedules расписания дайджеста по первому аргументу /digest
+var digestSchedules = map[string]db.DigestSchedule{
+	"неделя": db.DigestWeekly, "weekly": db.DigestWeekly,
+	"месяц": db.DigestMonthly, "monthly": db.DigestMonthly,
+}
+
+// digestScopes чьи Дни рождения попадают в дайджест, по второму аргументу /digest
+var digestScopes = map[string]db.DigestScope{
+	"подписки": db.DigestSubscriptions, "subscriptions": db.DigestSubscriptions,
+	"компания": db.DigestCompany, "company": db.DigestCompany,
+	"команда": db.DigestTeam, "team": db.DigestTeam,
+}
+
+// digestUsage подсказка по настройке дайджеста
+const digestUsage = "Дайджест - одно сообщение со списком Дней рождения на неделю (по понедельникам) " +
+	"или на месяц (1-го числа) в 09:00 по вашему часовому поясу. Если Дней рождения нет, он не приходит.\n\n" +
+	"/digest неделя - Дни рождения тех, на кого вы подписаны\n" +
+	"/digest месяц компания - всей компании\n" +
+	"/digest неделя команда Название - участников команды или отдела\n" +
+	"/digest выкл - выключить"
+
+// Digest показывает или меняет настройки дайджеста: /digest неделя [подписки|компания|команда Название]
+func (h *Handle) Digest(c tb.Context) error {
+	employee, err := h.authMiddleware(c, db.RoleEmployee)
+	if err != nil {
+		c.Send("Пожалуйста, пройдите аутентификацию:\n/login")
+		return err
+	}
+
+	args := strings.Fields(c.Message().Payload)
+	if len(args) == 0 {
+		current, err := h.db.GetDigest(employee.ID)
+		if errors.Is(err, db.ErrDigestNotFound) {
+			return c.Send("Дайджест выключен.\n\n" + digestUsage)
+		} else if err != nil {
+			log.Println(err)
+			return c.Send("Ошибка, попробуйте еще раз")
+		}
+		text, err := h.digestText(current, employee)
+		if err != nil {
+			log.Println(err)
+			return c.Send("Ошибка, попробуйте еще раз")
+		}
+		return c.Send(text + "\n\n" + digestUsage)
+	}
+
+	if arg := strings.ToLower(args[0]); arg == "выкл" || arg == "off" {
+		err = h.db.RemoveDigest(employee.ID)
+		if errors.Is(err, db.ErrDigestNotFound) {
+			return c.Send("Дайджест и так выключен")
+		} else if err != nil {
+			log.Println(err)
+			return c.Send("Ошибка, попробуйте еще раз")
+		}
+		return c.Send("Дайджест выключен")
+	}
+
+	schedule, ok := digestSchedules[strings.ToLower(args[0])]
+	if !ok {
+		return c.Send(fmt.Sprintf("Неизвестное расписание «%s»: неделя или месяц.\n\n%s", args[0], digestUsage))
+	}
+	digest := db.Digest{EmployeeID: employee.ID, Schedule: schedule, Scope: db.DigestSubscriptions}
+	if len(args) > 1 {
+		if digest.Scope, ok = digestScopes[strings.ToLower(args[1])]; !ok {
+			return c.Send(fmt.Sprintf("Неизвестный список «%s»: подписки, компания или команда.\n\n%s", args[1], digestUsage))
+		}
+	}
+	if digest.Scope == db.DigestTeam {
+		name := strings.Join(args[2:], " ")
+		if name == "" {
+			return c.Send("Укажите название команды, например:\n\n/digest неделя команда Бухгалтерия\n\nСписок: /teams")
+		}
+		team, err := h.db.GetTeam(db.Team{Name: name})
+		if err != nil {
+			return h.teamError(c, err)
+		}
+		digest.TeamID = uuid.NullUUID{UUID: team.ID, Valid: true}
+	}
+	digest.NextFireAt = nextDigest(schedule, h.clock.Now(), employee.Location())
+
+	if err = h.db.SetDigest(digest); err != nil {
+		return h.teamError(c, err)
+	}
+	text, err := h.digestText(digest, employee)
+	if err != nil {
+		log.Println(err)
+		return c.Send("Дайджест включен")
+	}
+
+	return c.Send("Дайджест включен. " + text)
+}
+
+// digestText настройки дайджеста словами
+func (h *Handle) digestText(d db.Digest, employee db.Employee) (string, error) {
+	when := "по понедельникам"
+	if d.Schedule == db.DigestMonthly {
+		when = "1-го числа каждого месяца"
+	}
+
+	whose := "тех, на кого вы подписаны"
+	switch d.Scope {
+	case db.DigestCompany:
+		whose = "всей компании"
+	case db.DigestTeam:
+		team, err := h.db.GetTeam(db.Team{ID: d.TeamID.UUID})
+		if err != nil {
+			return "", err
+		}
+		whose = fmt.Sprintf("участников «%s»", team.Name)
+	}
+
+	return fmt.Sprintf("Дайджест приходит %s: Дни рождения %s. Следующий - %s", when, whose,
+		d.NextFireAt.In(employee.Location()).Format("02.01.2006 15:04")), nil
+}
+
+// QueuedDigest дайджест, поставленный планировщиком в outbox
+type QueuedDigest struct {
+	Digest    db.Digest // NextFireAt - на когда дайджест был назначен
+	Recipient db.Employee
+	Text      string
+}
+
+// enqueueDigests ставит в outbox наступившие дайджесты, переносит их на следующую неделю
+// или месяц и возвращает поставленные. Устаревшие (раньше staleFrom) и пустые только переносятся
+func (h *Handle) enqueueDigests(now, staleFrom time.Time) ([]QueuedDigest, error) {
+	digests, err := h.db.GetDueDigests(now)
+	if err != nil {
+		return nil, err
+	}
+
+	var enqueued []QueuedDigest
+	for _, d := range digests {
+		recipient, err := h.db.GetEmployee(db.Employee{ID: d.EmployeeID})
+		if err != nil {
+			return nil, err
+		}
+		advance := d
+		advance.NextFireAt = nextDigest(d.Schedule, now, recipient.Location())
+
+		text := ""
+		if d.NextFireAt.Before(staleFrom) {
+			log.Printf("Пропущен устаревший дайджест (%s) для %s", d.NextFireAt, d.EmployeeID)
+		} else if text, err = h.digestMessage(d, recipient); err != nil {
+			return nil, err
+		}
+
+		if text == "" {
+			err = h.db.SetDigest(advance)
+		} else {
+			err = h.db.EnqueueDigest(db.OutboxMessage{
+				DedupKey: fmt.Sprintf("digest:%s:%d", d.EmployeeID, d.NextFireAt.Unix()),
+				ChatID:   recipient.TelegramID,
+				Text:     text,
+			}, advance)
+			enqueued = append(enqueued, QueuedDigest{Digest: d, Recipient: recipient, Text: text})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return enqueued, nil
+}
+
+// digestMessage текст дайджеста d за неделю или месяц с его времени; пустой, если Дней рождения нет
+func (h *Handle) digestMessage(d db.Digest, recipient db.Employee) (string, error) {
+	loc := recipient.Location()
+	from, to := digestPeriod(d.Schedule, d.NextFireAt, loc)
+
+	employees, err := h.digestEmployees(d)
+	if err != nil {
+		return "", err
+	}
+
+	type birthday struct {
+		day      time.Time
+		employee db.Employee
+	}
+	var birthdays []birthday
+	for _, e := range employees {
+		// неделя может захватить начало следующего года
+		for year := from.Year(); year <= to.Year(); year++ {
+			if day := calendar.BirthdayIn(e.BirthDate, year, loc, h.leapPolicy); !day.Before(from) && day.Before(to) {
+				birthdays = append(birthdays, birthday{day: day, employee: e})
+			}
+		}
+	}
+	if len(birthdays) == 0 {
+		return "", nil
+	}
+	sort.SliceStable(birthdays, func(i, j int) bool { return birthdays[i].day.Before(birthdays[j].day) })
+
+	last := to.AddDate(0, 0, -1)
+	var b strings.Builder
+	fmt.Fprintf(&b, "Дни рождения с %d %s по %d %s:\n\n",
+		from.Day(), months[from.Month()-1], last.Day(), months[last.Month()-1])
+	for i, x := range birthdays {
+		if i == digestLimit {
+			fmt.Fprintf(&b, "... и еще %d\n", len(birthdays)-digestLimit)
+			break
+		}
+		e := x.employee
+		fmt.Fprintf(&b, "%d %s, %s - %s %s %s\n", x.day.Day(), months[x.day.Month()-1],
+			weekdayNames[x.day.Weekday()].name, e.LastName, e.FirstName, e.Patronymic)
+	}
+	b.WriteString("\nНе забудьте поздравить! /digest - настройки дайджеста")
+
+	return b.String(), nil
+}
+
+// digestEmployees чьи Дни рождения попадают в дайджест d (без самого получателя и ушедших)
+func (h *Handle) digestEmployees(d db.Digest) ([]db.Employee, error) {
+	var employees []db.Employee
+	var err error
+	switch d.Scope {
+	case db.DigestCompany:
+		employees, err = h.db.GetEmployees()
+	case db.DigestTeam:
+		employees, err = h.db.GetTeamMembers(d.TeamID.UUID)
+	default:
+		var subscriptions []db.Subscription
+		if subscriptions, err = h.db.GetSubscriptions(d.EmployeeID); err != nil {
+			return nil, err
+		}
+		_, targets := groupSubscriptions(subscriptions)
+		for _, id := range targets {
+			target, err := h.db.GetEmployee(db.Employee{ID: id})
+			if err != nil {
+				return nil, err
+			}
+			employees = append(employees, target)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	listed := employees[:0]
+	for _, e := range employees {
+		if e.ID != d.EmployeeID && e.Status.Listed() {
+			listed = append(listed, e)
+		}
+	}
+
+	return listed, nil
+}
+
+// nextDigest ближайшее позже after время дайджеста: понедельник или 1-е число в digestHour
+// по часовому поясу loc
+func nextDigest(schedule db.DigestSchedule, after time.Time, loc *time.Location) time.Time {
+	t := after.In(loc)
+	if schedule == db.DigestMonthly {
+		next := time.Date(t.Year(), t.Month(), 1, digestHour, 0, 0, 0, loc)
+		if !next.After(after) {
+			next = time.Date(t.Year(), t.Month()+1, 1, digestHour, 0, 0, 0, loc)
+		}
+		return next
+	}
+
+	// понедельник текущей недели
+	next := time.Date(t.Year(), t.Month(), t.Day()-(int(t.Weekday())+6)%7, digestHour, 0, 0, 0, loc)
+	if !next.After(after) {
+		next = next.AddDate(0, 0, 7)
+	}
+
+	return next
+}
+
+// digestPeriod какие дни [from, to) охватывает дайджест, пришедший в at: неделя с этого дня
+// или весь месяц
+func digestPeriod(schedule db.DigestSchedule, at time.Time, loc *time.Location) (time.Time, time.Time) {
+	t := at.In(loc)
+	if schedule == db.DigestMonthly {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc), time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+	}
+
+	from := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	return from, from.AddDate(0, 0, 7)
+}
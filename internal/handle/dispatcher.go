@@ -0,0 +1,126 @@
+package handle
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"birthdayGreetings/internal/db"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	// dispatchInterval как часто диспетчер проверяет outbox
+	dispatchInterval = 10 * time.Second
+	// dispatchBatch сколько сообщений забирается за раз
+	dispatchBatch = 50
+	// dispatchLease на сколько сообщение резервируется за диспетчером на время отправки
+	dispatchLease = 5 * time.Minute
+	// backoffBase и backoffMax границы экспоненциальной задержки между попытками
+	backoffBase = 30 * time.Second
+	backoffMax  = time.Hour
+)
+
+// Sender отправка сообщений в telegram (реализуется *tb.Bot)
+type Sender interface {
+	Send(to tb.Recipient, what interface{}, opts ...interface{}) (*tb.Message, error)
+}
+
+// Dispatcher раз в dispatchInterval отправляет накопившиеся в outbox оповещания
+func (h *Handle) Dispatcher(s Sender) {
+	for {
+		if err := h.DispatchOutbox(s); err != nil {
+			log.Println("Ошибка отправки оповещаний:", err)
+		}
+		h.clock.Sleep(dispatchInterval)
+	}
+}
+
+// DispatchOutbox отправит готовые сообщения из outbox: доставленные отмечаются,
+// неудачные повторяются с экспоненциальной задержкой, безнадежные уходят в dead
+func (h *Handle) DispatchOutbox(s Sender) error {
+	now := h.clock.Now()
+	messages, err := h.db.ClaimOutbox(now, now.Add(dispatchLease), dispatchBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range messages {
+		err = h.deliver(s, m)
+		if err == nil {
+			if err = h.db.MarkDelivered(m.ID, h.clock.Now()); err != nil {
+				log.Println("Ошибка отметки о доставке:", err)
+			}
+			continue
+		}
+
+		retryIn, permanent := retryDelay(err, m.Attempts+1)
+		dead := permanent || m.Attempts+1 >= h.maxAttempts
+		if dead {
+			log.Printf("Оповещание %d не доставлено и перенесено в dead: %s", m.ID, err)
+		}
+		if err = h.db.MarkFailed(m.ID, err.Error(), h.clock.Now().Add(retryIn), dead); err != nil {
+			log.Println("Ошибка отметки о неудачной отправке:", err)
+		}
+	}
+
+	return nil
+}
+
+// deliver отправит одно сообщение
+func (h *Handle) deliver(s Sender, m db.OutboxMessage) error {
+	if m.ChatID == 0 {
+		return errPermanent{errors.New("у получателя нет telegram ID")}
+	}
+
+	_, err := s.Send(&tb.Chat{ID: m.ChatID}, m.Text)
+
+	return err
+}
+
+// errPermanent ошибка, после которой повторять отправку бессмысленно
+type errPermanent struct {
+	error
+}
+
+// retryDelay через сколько повторить попытку номер attempt и не безнадежна ли ошибка
+func retryDelay(err error, attempt int) (time.Duration, bool) {
+	var permanent errPermanent
+	if errors.As(err, &permanent) {
+		return 0, true
+	}
+
+	// telegram сам говорит, сколько подождать
+	var flood tb.FloodError
+	if errors.As(err, &flood) {
+		return time.Duration(flood.RetryAfter) * time.Second, false
+	}
+
+	// пользователь заблокировал бота, чат не найден и т.п. - повтор не поможет
+	var tgErr *tb.Error
+	if errors.As(err, &tgErr) && (tgErr.Code == 400 || tgErr.Code == 403) {
+		return 0, true
+	}
+
+	delay := backoffBase << (attempt - 1)
+	if delay <= 0 || delay > backoffMax {
+		delay = backoffMax
+	}
+
+	return delay, false
+}
+
+// dedupKey ключ, по которому одно и то же оповещание не попадет в outbox дважды
+// (для Дней рождения без вида события, как до появления годовщин)
+func dedupKey(n db.Notification) string {
+	if n.Event == db.EventCustom {
+		return fmt.Sprintf("%s:%s:%s:%s:%d", n.Kind, n.Event, n.Recipient.ID, n.Custom.ID, n.FireAt.Unix())
+	}
+	if n.Event != db.EventBirthday {
+		return fmt.Sprintf("%s:%s:%s:%s:%d", n.Kind, n.Event, n.Recipient.ID, n.Target.ID, n.FireAt.Unix())
+	}
+
+	return fmt.Sprintf("%s:%s:%s:%d", n.Kind, n.Recipient.ID, n.Target.ID, n.FireAt.Unix())
+}
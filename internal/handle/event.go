@@ -0,0 +1,512 @@
+package handle
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"birthdayGreetings/internal/calendar"
+	"birthdayGreetings/internal/db"
+
+	"github.com/gofrs/uuid"
+	tb "gopkg.in/telebot.v3"
+)
+
+// Уникальные имена inline-кнопок подписки на произвольные события
+const (
+	btnEvents    = "ev_list" // список событий
+	btnEventCard = "ev_card" // карточка события: id
+	btnEventOn   = "ev_on"   // включить или выключить оповещание о событии: id|hours
+	btnEventOff  = "ev_off"  // отписаться от события: id
+)
+
+// eventsListLimit сколько событий показывать в списке
+const eventsListLimit = 50
+
+// weekdayNames дни недели по time.Weekday и род для согласования порядкового числительного
+var weekdayNames = [...]struct {
+	name   string
+	gender int // 0 - мужской, 1 - женский, 2 - средний
+}{
+	{"воскресенье", 2}, {"понедельник", 0}, {"вторник", 0}, {"среда", 1},
+	{"четверг", 0}, {"пятница", 1}, {"суббота", 1},
+}
+
+// weekOrdinals порядковые числительные недели месяца по родам и женский род в винительном
+// падеже («в последнюю пятницу»), -1 - последняя неделя
+var weekOrdinals = map[int][4]string{
+	1:  {"первый", "первая", "первое", "первую"},
+	2:  {"второй", "вторая", "второе", "вторую"},
+	3:  {"третий", "третья", "третье", "третью"},
+	4:  {"четвертый", "четвертая", "четвертое", "четвертую"},
+	-1: {"последний", "последняя", "последнее", "последнюю"},
+}
+
+// Events список событий компании и сотрудников с подпиской на них: /events
+func (h *Handle) Events(c tb.Context) error {
+	employee, err := h.authMiddleware(c, db.RoleEmployee)
+	if err != nil {
+		return h.denied(c, err)
+	}
+
+	text, markup, err := h.eventsPage(employee)
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+
+	return c.Send(text, markup)
+}
+
+// AddEvent создает событие: /addevent Название;правило[;UUID сотрудника]. Сотрудник заводит события
+// только себе, администратор - любому сотруднику или, без UUID, всей компании
+func (h *Handle) AddEvent(c tb.Context) error {
+	employee, err := h.authMiddleware(c, db.RoleEmployee)
+	if err != nil {
+		return h.denied(c, err)
+	}
+	admin := employee.Role.AtLeast(db.RoleAdmin)
+
+	fields := strings.Split(c.Message().Payload, ";")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	if len(fields) < 2 || len(fields) > 3 || fields[0] == "" {
+		return c.Send("Формат: /addevent Название;правило[;UUID сотрудника]\n" +
+			"Правило: ДД.ММ или «12 июня» - ежегодно, ДД.ММ.ГГГГ - однократно, «последняя пятница июля» - ежегодно " +
+			"в день недели месяца\nНапример:\n\n/addevent Годовщина свадьбы;12.06\n" +
+			"/addevent День системного администратора;последняя пятница июля")
+	}
+
+	event, err := parseEventRule(fields[1])
+	if err != nil {
+		return c.Send(err.Error())
+	}
+	event.Title = fields[0]
+
+	switch {
+	case len(fields) == 3 && fields[2] != "":
+		owner, err := h.employeeArg(fields[2])
+		if err != nil {
+			return c.Send(err.Error())
+		}
+		if owner.ID != employee.ID && !admin {
+			return c.Send("Заводить события другим сотрудникам может только администратор")
+		}
+		event.EmployeeID = uuid.NullUUID{UUID: owner.ID, Valid: true}
+	case !admin:
+		event.EmployeeID = uuid.NullUUID{UUID: employee.ID, Valid: true}
+	}
+
+	if event, err = h.db.CreateEvent(event); err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+
+	whose := "всей компании"
+	if event.EmployeeID.Valid {
+		whose = "сотрудника"
+	}
+	return c.Send(fmt.Sprintf("Событие %s «%s» (%s) добавлено\nUUID: %s\nПодписаться: /events",
+		whose, event.Title, eventRuleText(event), event.ID))
+}
+
+// DeleteEvent удаляет событие вместе с подписками на него: /deleteevent <UUID события>.
+// Сотрудник удаляет только свои события, администратор - любые
+func (h *Handle) DeleteEvent(c tb.Context) error {
+	employee, err := h.authMiddleware(c, db.RoleEmployee)
+	if err != nil {
+		return h.denied(c, err)
+	}
+
+	id, err := uuid.FromString(strings.TrimSpace(c.Message().Payload))
+	if err != nil {
+		return c.Send("Формат: /deleteevent <UUID события>")
+	}
+	event, err := h.db.GetEvent(id)
+	if err != nil {
+		return h.eventError(c, err)
+	}
+	if !employee.Role.AtLeast(db.RoleAdmin) && event.EmployeeID.UUID != employee.ID {
+		return c.Send("Удалять чужие события и события компании может только администратор")
+	}
+
+	if err = h.db.DeleteEvent(event.ID); err != nil {
+		return h.eventError(c, err)
+	}
+
+	return c.Send(fmt.Sprintf("Событие «%s» удалено", event.Title))
+}
+
+// eventError сообщит об ошибке поиска события
+func (h *Handle) eventError(c tb.Context, err error) error {
+	if errors.Is(err, db.ErrEventNotFound) {
+		return c.Send("Событие не найдено, список: /events")
+	}
+
+	log.Println(err)
+	return c.Send("Ошибка, попробуйте еще раз")
+}
+
+// nextEventReminder ближайшее позже after время напоминания за lead до события в часовом поясе loc
+// (false, если однократное событие уже прошло)
+func (h *Handle) nextEventReminder(event db.CustomEvent, lead time.Duration, after time.Time,
+	loc *time.Location) (time.Time, bool) {
+	start := after.In(loc).Year()
+	for year := start; year <= start+2; year++ {
+		day, ok := event.In(year, loc, h.leapPolicy)
+		if t := calendar.Before(day, lead); ok && t.After(after) {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// onEvents возвращает к списку событий
+func (h *Handle) onEvents(c tb.Context) error {
+	employee, err := h.callbackAuth(c)
+	if err != nil {
+		return err
+	}
+
+	text, markup, err := h.eventsPage(employee)
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+
+	c.Edit(text, markup)
+	return c.Respond()
+}
+
+// onEventCard открывает карточку события
+func (h *Handle) onEventCard(c tb.Context) error {
+	employee, err := h.callbackAuth(c)
+	if err != nil {
+		return err
+	}
+
+	event, err := h.callbackCustomEvent(c.Args())
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+
+	return h.editEventCard(c, employee, event, "")
+}
+
+// onEventSubscribe включает оповещание о событии за выбранное время (или выключает уже включенное)
+func (h *Handle) onEventSubscribe(c tb.Context) error {
+	employee, err := h.callbackAuth(c)
+	if err != nil {
+		return err
+	}
+
+	args := c.Args()
+	event, err := h.callbackCustomEvent(args)
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+	hours := 0
+	if len(args) > 1 {
+		hours, _ = strconv.Atoi(args[1])
+	}
+	lead := time.Duration(hours) * time.Hour
+
+	subscribed, err := h.eventSubscriptions(employee.ID)
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+	for _, s := range subscribed[event.ID] {
+		if s.LeadTime != lead {
+			continue
+		}
+		err = h.db.RemoveEventSubscriptionLead(employee.ID, event.ID, lead)
+		if err != nil && !errors.Is(err, db.ErrSubscriptionNotFound) {
+			return h.callbackError(c, err)
+		}
+		return h.editEventCard(c, employee, event, "Оповещание выключено")
+	}
+
+	next, ok := h.nextEventReminder(event, lead, h.clock.Now(), employee.Location())
+	if !ok {
+		return c.Respond(&tb.CallbackResponse{Text: "Событие уже прошло", ShowAlert: true})
+	}
+	err = h.db.AddEventSubscription(db.Subscription{
+		SubscriberID: employee.ID,
+		TargetID:     event.EmployeeID.UUID,
+		Event:        db.EventCustom,
+		EventID:      uuid.NullUUID{UUID: event.ID, Valid: true},
+		LeadTime:     lead,
+		NextFireAt:   next,
+	})
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+
+	return h.editEventCard(c, employee, event, "Оповещание включено")
+}
+
+// onEventUnsubscribe отписывает от события
+func (h *Handle) onEventUnsubscribe(c tb.Context) error {
+	employee, err := h.callbackAuth(c)
+	if err != nil {
+		return err
+	}
+
+	event, err := h.callbackCustomEvent(c.Args())
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+
+	err = h.db.RemoveEventSubscription(employee.ID, event.ID)
+	if err != nil && !errors.Is(err, db.ErrSubscriptionNotFound) {
+		return h.callbackError(c, err)
+	}
+
+	return h.editEventCard(c, employee, event, "Вы отписаны")
+}
+
+// editEventCard перерисует сообщение карточкой события и ответит на нажатие
+func (h *Handle) editEventCard(c tb.Context, employee db.Employee, event db.CustomEvent, notice string) error {
+	text, markup, err := h.eventCard(employee, event)
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+
+	c.Edit(text, markup)
+	return c.Respond(&tb.CallbackResponse{Text: notice})
+}
+
+// eventsPage текст и клавиатура списка предстоящих событий
+func (h *Handle) eventsPage(employee db.Employee) (string, *tb.ReplyMarkup, error) {
+	events, err := h.db.GetEvents()
+	if err != nil {
+		return "", nil, err
+	}
+	subscribed, err := h.eventSubscriptions(employee.ID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	markup := &tb.ReplyMarkup{}
+	var rows []tb.Row
+	for _, e := range events {
+		if _, ok := h.nextEventReminder(e, 0, h.clock.Now(), employee.Location()); !ok {
+			continue // однократное и уже прошло
+		}
+		if len(rows) == eventsListLimit {
+			break
+		}
+
+		title, err := h.eventTitle(e)
+		if err != nil {
+			return "", nil, err
+		}
+		text := title + " · " + eventRuleText(e)
+		if s, ok := subscribed[e.ID]; ok {
+			text = "✅ " + text + " · " + leadsText(s)
+		}
+		rows = append(rows, markup.Row(markup.Data(text, btnEventCard, e.ID.String())))
+	}
+	markup.Inline(rows...)
+
+	if len(rows) == 0 {
+		return "Предстоящих событий пока нет. Добавить: /addevent", markup, nil
+	}
+
+	return "События компании и сотрудников: праздники, свадьбы, именины (✅ - вы уже подписаны). " +
+		"Добавить свое: /addevent", markup, nil
+}
+
+// eventCard текст и клавиатура карточки события
+func (h *Handle) eventCard(employee db.Employee, event db.CustomEvent) (string, *tb.ReplyMarkup, error) {
+	subscribed, err := h.eventSubscriptions(employee.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	title, err := h.eventTitle(event)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%s\n", title, eventRuleText(event))
+	if day, ok := h.nextEventReminder(event, 0, h.clock.Now(), employee.Location()); ok {
+		fmt.Fprintf(&b, "Ближайшее: %s\n", day.Format("02.01.2006"))
+	}
+
+	current, ok := subscribed[event.ID]
+	if ok {
+		fmt.Fprintf(&b, "\nПодписка: %s, ближайшее оповещание %s", leadsText(current),
+			nearestFireAt(current).In(employee.Location()).Format("02.01.2006 15:04"))
+	} else {
+		b.WriteString("\nПодписки нет. Когда оповестить? Можно выбрать несколько вариантов")
+	}
+
+	enabled := make(map[time.Duration]bool, len(current))
+	for _, s := range current {
+		enabled[s.LeadTime] = true
+	}
+
+	id := event.ID.String()
+	markup := &tb.ReplyMarkup{}
+	var choices []tb.Btn
+	for _, choice := range leadChoices {
+		label := choice.text
+		if enabled[choice.lead] {
+			label = "• " + label
+		}
+		choices = append(choices, markup.Data(label, btnEventOn, id, strconv.Itoa(int(choice.lead/time.Hour))))
+	}
+
+	rows := markup.Split(2, choices)
+	if ok {
+		rows = append(rows, markup.Row(markup.Data("Отписаться", btnEventOff, id)))
+	}
+	rows = append(rows, markup.Row(markup.Data("« К событиям", btnEvents)))
+	markup.Inline(rows...)
+
+	return b.String(), markup, nil
+}
+
+// eventTitle название события для списка: у событий сотрудника - с его фамилией и именем
+func (h *Handle) eventTitle(event db.CustomEvent) (string, error) {
+	if !event.EmployeeID.Valid {
+		return "🎉 " + event.Title, nil
+	}
+
+	owner, err := h.db.GetEmployee(db.Employee{ID: event.EmployeeID.UUID})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s %s: %s", owner.LastName, owner.FirstName, event.Title), nil
+}
+
+// eventSubscriptions оповещания сотрудника о событиях по ID события
+func (h *Handle) eventSubscriptions(employeeID uuid.UUID) (map[uuid.UUID][]db.Subscription, error) {
+	subscriptions, err := h.db.GetEventSubscriptions(employeeID)
+	if err != nil {
+		return nil, err
+	}
+
+	subscribed := make(map[uuid.UUID][]db.Subscription, len(subscriptions))
+	for _, s := range subscriptions {
+		subscribed[s.EventID.UUID] = append(subscribed[s.EventID.UUID], s)
+	}
+
+	return subscribed, nil
+}
+
+// callbackCustomEvent событие из первого аргумента кнопки (события уволенных - как ненайденные)
+func (h *Handle) callbackCustomEvent(args []string) (db.CustomEvent, error) {
+	if len(args) == 0 {
+		return db.CustomEvent{}, db.ErrEventNotFound
+	}
+	id, err := uuid.FromString(args[0])
+	if err != nil {
+		return db.CustomEvent{}, err
+	}
+
+	event, err := h.db.GetEvent(id)
+	if err != nil || !event.EmployeeID.Valid {
+		return event, err
+	}
+	owner, err := h.db.GetEmployee(db.Employee{ID: event.EmployeeID.UUID})
+	if err == nil && !owner.Status.Listed() {
+		return db.CustomEvent{}, db.ErrEventNotFound
+	}
+
+	return event, err
+}
+
+// parseEventRule разберет правило повторения: ДД.ММ или «[ежегодно] 12 июня» - ежегодно,
+// ДД.ММ.ГГГГ или ГГГГ-ММ-ДД - однократно, «<первый…четвертый|последний> <день недели> <месяц>» -
+// ежегодно в день недели месяца
+func parseEventRule(s string) (db.CustomEvent, error) {
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) > 0 && (fields[0] == "ежегодно" || fields[0] == "в") {
+		fields = fields[1:]
+	}
+	if len(fields) == 2 {
+		for i, m := range months {
+			if fields[1] == m {
+				fields = []string{fmt.Sprintf("%s.%02d", fields[0], i+1)}
+				break
+			}
+		}
+	}
+	if len(fields) == 1 {
+		for _, layout := range []string{"02.01.2006", time.DateOnly} {
+			if date, err := time.Parse(layout, fields[0]); err == nil {
+				return db.CustomEvent{Rule: db.RuleOnce, Date: date}, nil
+			}
+		}
+		// високосный год, чтобы 29.02 тоже можно было указать
+		if date, err := time.Parse("2.01.2006", fields[0]+".2000"); err == nil {
+			return db.CustomEvent{Rule: db.RuleYearly, Date: date}, nil
+		}
+		return db.CustomEvent{}, fmt.Errorf("Некорректная дата %s: нужен формат ДД.ММ или ДД.ММ.ГГГГ", fields[0])
+	}
+
+	if len(fields) != 3 {
+		return db.CustomEvent{}, fmt.Errorf("Непонятное правило «%s»: нужна дата (ДД.ММ, ДД.ММ.ГГГГ) "+
+			"или, например, «последняя пятница июля»", s)
+	}
+
+	event := db.CustomEvent{Rule: db.RuleWeekday, Week: parseWeek(fields[0]), Weekday: -1}
+	for i, w := range weekdayNames {
+		// день недели и в винительном падеже: «в последнюю пятницу»
+		if fields[1] == w.name || w.gender == 1 && fields[1] == strings.TrimSuffix(w.name, "а")+"у" {
+			event.Weekday = time.Weekday(i)
+		}
+	}
+	for i, m := range months {
+		if fields[2] == m {
+			event.Month = time.Month(i + 1)
+		}
+	}
+
+	switch {
+	case event.Week == 0:
+		return db.CustomEvent{}, fmt.Errorf("Неделя месяца «%s»: ожидается 1-4, первый…четвертый или последний", fields[0])
+	case event.Weekday < 0:
+		return db.CustomEvent{}, fmt.Errorf("Неизвестный день недели «%s»", fields[1])
+	case event.Month == 0:
+		return db.CustomEvent{}, fmt.Errorf("Неизвестный месяц «%s»: нужен в родительном падеже, например «июля»", fields[2])
+	}
+
+	return event, nil
+}
+
+// parseWeek неделя месяца: число 1-4 (можно «2-й») или порядковое числительное (0, если не разобрать)
+func parseWeek(s string) int {
+	if n, err := strconv.Atoi(strings.TrimRight(strings.TrimRight(s, "йяюе"), "-")); err == nil && n >= 1 && n <= 4 {
+		return n
+	}
+	for week, forms := range weekOrdinals {
+		for _, form := range forms {
+			if s == form {
+				return week
+			}
+		}
+	}
+
+	return 0
+}
+
+// eventRuleText правило повторения словами (разбирается обратно parseEventRule)
+func eventRuleText(event db.CustomEvent) string {
+	switch event.Rule {
+	case db.RuleOnce:
+		return event.Date.Format("02.01.2006")
+	case db.RuleWeekday:
+		w := weekdayNames[event.Weekday]
+		return fmt.Sprintf("%s %s %s", weekOrdinals[event.Week][w.gender], w.name, months[event.Month-1])
+	default:
+		return fmt.Sprintf("ежегодно %d %s", event.Date.Day(), months[event.Date.Month()-1])
+	}
+}
@@ -1,7 +1,6 @@
 package handle
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"log"
@@ -10,11 +9,15 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	td "birthdayGreetings/internal/TDlib"
+	"birthdayGreetings/internal/calendar"
+	"birthdayGreetings/internal/clock"
 	"birthdayGreetings/internal/db"
+	"birthdayGreetings/internal/dialog"
 	m "birthdayGreetings/internal/mailer"
+	"birthdayGreetings/internal/otp"
 
 	tb "gopkg.in/telebot.v3"
 
@@ -22,13 +25,76 @@ import (
 )
 
 type Handle struct {
-	db db.DB
+	db     db.Store
+	clock  clock.Clock
+	dialog *dialog.Machine
+	// staleness насколько устаревшие пропущенные оповещания еще отправлять
+	staleness time.Duration
+	// maxAttempts сколько раз пытаться доставить оповещание до переноса в dead
+	maxAttempts int
+	// leapPolicy когда поздравлять и напоминать о 29 февраля в невисокосный год
+	leapPolicy calendar.LeapPolicy
+	// login ограничения на коды входа
+	login loginLimits
+	// session время жизни и продление сессий
+	session sessionConfig
+	// schedulerMu не дает двум проверкам оповещаний идти одновременно
+	schedulerMu sync.Mutex
 }
 
-func NewHandle() *Handle {
+// loginLimits время жизни кода входа и сколько раз за окно window можно запросить и ввести код
+// (отдельно для сотрудника и для telegram ID)
+type loginLimits struct {
+	codeTTL     time.Duration
+	maxSends    int
+	maxAttempts int
+	window      time.Duration
+}
+
+func NewHandle(store db.Store, clk clock.Clock) *Handle {
+	leapPolicy, err := calendar.ParseLeapPolicy(os.Getenv("LEAP_DAY_POLICY"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	return &Handle{
-		db: db.NewDB(),
+		db:          store,
+		clock:       clk,
+		dialog:      dialog.NewMachine(store, clk),
+		staleness:   envDuration("NOTIFICATION_STALENESS", 24*time.Hour),
+		maxAttempts: envInt("OUTBOX_MAX_ATTEMPTS", 8),
+		leapPolicy:  leapPolicy,
+		login: loginLimits{
+			codeTTL:     envDuration("LOGIN_CODE_TTL", 10*time.Minute),
+			maxSends:    envInt("LOGIN_MAX_SENDS", 3),
+			maxAttempts: envInt("LOGIN_MAX_ATTEMPTS", 5),
+			window:      envDuration("LOGIN_LIMIT_WINDOW", time.Hour),
+		},
+		session: sessionConfig{
+			ttl:     envDuration("SESSION_TTL", 24*time.Hour),
+			refresh: envDuration("SESSION_REFRESH", 6*time.Hour),
+		},
+	}
+}
+
+// envDuration длительность из переменной окружения или def, если она не задана или невалидна
+func envDuration(name string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || d <= 0 {
+		return def
+	}
+
+	return d
+}
+
+// envInt положительное число из переменной окружения или def
+func envInt(name string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || n < 1 {
+		return def
 	}
+
+	return n
 }
 
 func (h *Handle) CloseDB() {
@@ -37,9 +103,28 @@ func (h *Handle) CloseDB() {
 
 // Login аутентификация
 func (h *Handle) Login(c tb.Context) error {
+	if err := h.dialog.Start(dialog.Conversation{TelegramID: c.Sender().ID, State: dialog.AwaitEmail}); err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+
 	return c.Send("Пожалуйста, введите ваш email:")
 }
 
+// Cancel прерывает текущий диалог: /cancel
+func (h *Handle) Cancel(c tb.Context) error {
+	state, err := h.dialog.Cancel(c.Sender().ID)
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+	if state == dialog.Idle {
+		return c.Send("Нечего отменять")
+	}
+
+	return c.Send("Отменено")
+}
+
 // isValidEmail проверка валидности email
 func isValidEmail(email string) bool {
 	re := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
@@ -47,126 +132,311 @@ func isValidEmail(email string) bool {
 	return re.MatchString(email)
 }
 
-// WaitUserResponse ожидание ответов пользователей
+// WaitUserResponse ожидание ответов пользователей: текст обрабатывается по текущему шагу диалога
 func (h *Handle) WaitUserResponse(c tb.Context) error {
-	var err error
-	var employee db.Employee
-	var isValidEmailVal bool
+	conversation, err := h.dialog.Current(c.Sender().ID)
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
 	response := c.Message().Text
 
-	// Если отправили валидный email
-	if isValidEmailVal = isValidEmail(response); !isValidEmailVal {
-		// Получаем данные из db по TelegramId
-		if employee, err = h.db.GetEmployee(db.Employee{TelegramID: c.Sender().ID}); err != nil {
-			log.Println(err)
-			return h.BotHelp(c)
+	switch conversation.State {
+	case dialog.AwaitEmail:
+		return h.waitEmail(conversation, c, response)
+	case dialog.AwaitPassword:
+		return h.waitPassword(conversation, c, response)
+	case dialog.AwaitRebind:
+		return h.waitRebind(conversation, c, response)
+	case dialog.AwaitImport:
+		return h.waitImport(conversation, c, response)
+	case dialog.AwaitSubscribe, dialog.AwaitUnsubscribe:
+		employee, err := h.authMiddleware(c, db.RoleEmployee)
+		if err != nil {
+			c.Send("Пожалуйста, пройдите аутентификацию:\n/login")
+			return err
 		}
-	}
-
-	if isValidEmailVal {
-		return h.waitEmail(c, response)
-	} else if employee.WaitLogin {
-		return h.waitPassword(employee, c, response)
-	} else if employee.WaitSubscribe {
-		return h.waitSubscribe(employee, c, response)
-	} else if employee.WaitUnsubscribe {
-		return h.waitUnSubscribe(employee, c, response)
+		if conversation.State == dialog.AwaitSubscribe {
+			return h.waitSubscribe(conversation, employee, c, response)
+		}
+		return h.waitUnSubscribe(conversation, employee, c, response)
 	}
 
 	return h.BotHelp(c)
 }
 
-// waitEmail получает email от пользователя и отправляет на него пароль
-func (h *Handle) waitEmail(c tb.Context, response string) error {
-	// Проверяем email в базе
-	employee, err := h.db.AuthenticateUser(c, response)
-	if err != nil {
-		return c.Send("Ошибка при аутентификации: " + err.Error())
+// waitEmail получает email от пользователя и отправляет на него код входа
+func (h *Handle) waitEmail(conversation dialog.Conversation, c tb.Context, response string) error {
+	if !isValidEmail(response) {
+		return c.Send("Это не похоже на email, попробуйте еще раз\n/cancel - отменить вход")
+	}
+
+	// Не даем рассылать коды с одного telegram ID без ограничений
+	if ok, err := h.allowLogin(c, db.LoginSend, telegramKey(c.Sender().ID)); !ok {
+		return err
+	}
+
+	// Проверяем email в базе (telegram ID привяжется только после ввода кода)
+	employee, err := h.db.GetEmployee(db.Employee{Email: response})
+	if errors.Is(err, db.ErrNotFound) {
+		return c.Send("Ошибка при аутентификации: email не найден")
+	} else if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка при аутентификации, попробуйте еще раз")
 	}
 
-	// Отправляем временный пароль на email пользователя
-	password, err := m.SendPasswordToEmail(employee.Email)
+	if ok, err := h.allowLogin(c, db.LoginSend, employeeKey(employee.ID)); !ok {
+		return err
+	}
+
+	// Сохраняем только хэш кода, сам код уходит на email
+	code, err := otp.Generate()
 	if err != nil {
-		return c.Send("Ошибка при отправке пароля: " + err.Error())
+		log.Println(err)
+		return c.Send("Ошибка при аутентификации, попробуйте еще раз")
+	}
+	err = h.db.SaveLoginCode(employee.ID, otp.Code{Hash: otp.Hash(code), CreatedAt: h.clock.Now()})
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка при аутентификации, попробуйте еще раз")
+	}
+
+	if err = m.SendCodeToEmail(employee.Email, code, h.login.codeTTL); err != nil {
+		h.db.DeleteLoginCode(employee.ID)
+		return c.Send("Ошибка при отправке кода: " + err.Error())
 	}
 
-	// Сохраняем временный пароль для дальнейшей проверки
-	if err := h.db.PatchEmployee(db.Employee{ID: employee.ID, TempPassword: password, WaitLogin: true}, "TempPassword"); err != nil {
+	conversation.EmployeeID = employee.ID
+	if err := h.dialog.Transition(conversation, dialog.AwaitPassword); err != nil {
+		log.Println(err)
 		return c.Send("Ошибка при аутентификации, попробуйте еще раз")
 	}
 
-	return c.Send("Мы отправили временный пароль на ваш email. Пожалуйста, введите его.")
+	return c.Send(fmt.Sprintf("Мы отправили код для входа на ваш email, он действует %s. Пожалуйста, введите его.",
+		h.login.codeTTL))
 }
 
-// waitPassword получает пароль от пользователя и сравнивает с отправленным
-func (h *Handle) waitPassword(employee db.Employee, c tb.Context, response string) error {
-	// Проверяем введенный пароль
-	if employee.TempPassword != response {
-		// Ставим флаг employee.WaitLogin в false чтобы была одна попытка проверки пароля
-		if err := h.db.PatchEmployee(db.Employee{ID: employee.ID}, "Wait"); err != nil {
+// waitPassword получает код от пользователя и сравнивает с отправленным
+func (h *Handle) waitPassword(conversation dialog.Conversation, c tb.Context, response string) error {
+	code, err := h.db.GetLoginCode(conversation.EmployeeID)
+	if errors.Is(err, db.ErrCodeNotFound) {
+		h.dialog.Transition(conversation, dialog.Idle)
+		return c.Send("Код не запрашивался или уже использован\n/login - получить новый")
+	} else if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка при аутентификации, попробуйте еще раз")
+	}
+
+	if code.Expired(h.clock.Now(), h.login.codeTTL) {
+		h.endLogin(conversation)
+		return c.Send("Срок действия кода истек\n/login - получить новый")
+	}
+
+	// Попытки считаются и по telegram ID, и по сотруднику: исчерпаны - код больше не действует
+	for _, key := range []string{telegramKey(c.Sender().ID), employeeKey(conversation.EmployeeID)} {
+		if ok, err := h.allowLogin(c, db.LoginAttempt, key); !ok {
+			h.endLogin(conversation)
 			return err
 		}
-		return c.Send("Неверный пароль")
-	} else {
-		// Генерируем JWT-токен для пользователя
-		token, err := db.GenerateJWTToken(employee.ID)
-		if err != nil {
-			return c.Send("Ошибка при генерации токена: " + err.Error())
-		}
-		// Сохраняем JWT-токен в базу
-		if err = h.db.PatchEmployee(db.Employee{ID: employee.ID, Token: token}, "Token"); err != nil {
+	}
+
+	// Проверяем введенный код
+	if !otp.Verify(code.Hash, strings.TrimSpace(response)) {
+		return c.Send("Неверный код, попробуйте еще раз\n/cancel - отменить вход")
+	}
+	if err = h.db.DeleteLoginCode(conversation.EmployeeID); err != nil {
+		log.Println(err)
+	}
+
+	employee, err := h.db.GetEmployee(db.Employee{ID: conversation.EmployeeID})
+	if err != nil {
+		log.Println(err)
+		h.dialog.Transition(conversation, dialog.Idle)
+		return c.Send("Ошибка при аутентификации, попробуйте еще раз")
+	}
+
+	// Учетная запись уже привязана к другому telegram - перепривязка только с явного согласия
+	if employee.TelegramID != 0 && employee.TelegramID != c.Sender().ID {
+		if err = h.dialog.Transition(conversation, dialog.AwaitRebind); err != nil {
+			log.Println(err)
 			return c.Send("Ошибка при аутентификации, попробуйте еще раз")
 		}
+		return c.Send("Ваша учетная запись уже привязана к другому аккаунту telegram. " +
+			"Привязать ее к этому аккаунту? Прежний аккаунт получит уведомление и выйдет из бота.\n\n" +
+			"Ответьте \"да\" или \"нет\"")
+	}
 
-		// Отправляем сообщение с успешной аутентификацией
-		return c.Send("Аутентификация прошла успешно!")
+	h.dialog.Transition(conversation, dialog.Idle)
+
+	return h.bindAndLogin(c, employee, db.BindLogin)
+}
+
+// waitRebind ждет подтверждения перепривязки учетной записи к новому аккаунту telegram
+func (h *Handle) waitRebind(conversation dialog.Conversation, c tb.Context, response string) error {
+	switch strings.ToLower(strings.TrimSpace(response)) {
+	case "да":
+	case "нет":
+		h.dialog.Transition(conversation, dialog.Idle)
+		return c.Send("Привязка не изменилась")
+	default:
+		return c.Send("Ответьте \"да\" или \"нет\"\n/cancel - отменить вход")
+	}
+	h.dialog.Transition(conversation, dialog.Idle)
+
+	employee, err := h.db.GetEmployee(db.Employee{ID: conversation.EmployeeID})
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка при аутентификации, попробуйте еще раз")
 	}
+
+	return h.bindAndLogin(c, employee, db.BindRebind)
 }
 
-// waitSubscribe получает uuid сотрудников на которых нужно подписаться
-func (h *Handle) waitSubscribe(employee db.Employee, c tb.Context, response string) error {
-	data := strings.Fields(response)
+// bindAndLogin привяжет сотрудника к отправителю (если еще не привязан) и начнет сессию;
+// прежний аккаунт telegram получит уведомление о перепривязке
+func (h *Handle) bindAndLogin(c tb.Context, employee db.Employee, reason db.BindingReason) error {
+	now := h.clock.Now()
+	previous, err := h.db.BindTelegram(employee.ID, c.Sender().ID, reason, now)
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка при аутентификации, попробуйте еще раз")
+	}
+
+	// Начинаем сессию (прежние сессии отзываются) и сохраняем ее JWT-токен в базу
+	if err = h.startSession(employee.ID, c.Sender().ID); err != nil {
+		log.Println(err)
+		return c.Send("Ошибка при аутентификации, попробуйте еще раз")
+	}
+
+	if previous != 0 && previous != c.Sender().ID {
+		err = h.db.Enqueue(db.OutboxMessage{
+			DedupKey: fmt.Sprintf("rebind:%s:%d", employee.ID, now.UnixNano()),
+			ChatID:   previous,
+			Text: fmt.Sprintf("Учетная запись %s %s привязана к другому аккаунту telegram, "+
+				"здесь выполнен выход. Если это были не вы, срочно обратитесь к администратору.",
+				employee.FirstName, employee.LastName),
+		}, nil)
+		if err != nil {
+			log.Println("Ошибка уведомления о перепривязке:", err)
+		}
+	}
+
+	// Отправляем сообщение с успешной аутентификацией
+	return c.Send("Аутентификация прошла успешно!")
+}
+
+// endLogin удалит код входа и завершит диалог
+func (h *Handle) endLogin(conversation dialog.Conversation) {
+	if err := h.db.DeleteLoginCode(conversation.EmployeeID); err != nil {
+		log.Println(err)
+	}
+	if err := h.dialog.Transition(conversation, dialog.Idle); err != nil {
+		log.Println(err)
+	}
+}
+
+// allowLogin учтет отправку или ввод кода для key; если лимит за окно исчерпан - сообщит
+// пользователю, когда можно повторить, и вернет false
+func (h *Handle) allowLogin(c tb.Context, action db.LoginAction, key string) (bool, error) {
+	now := h.clock.Now()
+	count, resetAt, err := h.db.HitLoginLimit(key, action, now, h.login.window)
+	if err != nil {
+		log.Println(err)
+		return false, c.Send("Ошибка при аутентификации, попробуйте еще раз")
+	}
+
+	limit, message := h.login.maxSends, "Слишком много запросов кода"
+	if action == db.LoginAttempt {
+		limit, message = h.login.maxAttempts, "Слишком много попыток ввода кода"
+	}
+	if count <= limit {
+		return true, nil
+	}
+
+	wait := resetAt.Sub(now).Round(time.Minute)
+	if wait < time.Minute {
+		wait = time.Minute
+	}
+
+	return false, c.Send(fmt.Sprintf("%s. Попробуйте снова через %d мин.\n/login", message, int(wait.Minutes())))
+}
+
+// telegramKey и employeeKey ключи ограничений входа
+func telegramKey(id int64) string {
+	return "telegram:" + strconv.FormatInt(id, 10)
+}
+
+func employeeKey(id uuid.UUID) string {
+	return "employee:" + id.String()
+}
+
+// waitSubscribe получает uuid сотрудников на которых нужно подписаться и после каждого -
+// когда оповещать (см. parseLead, несколько значений через запятую - несколько оповещаний)
+func (h *Handle) waitSubscribe(conversation dialog.Conversation, employee db.Employee, c tb.Context, response string) error {
+	data := strings.Fields(strings.NewReplacer(",", " ", ";", " ").Replace(response))
 	if len(data) == 0 {
 		return h.SubscribeToNotifications(c)
 	}
 
-	hours, id, subscribe := 0, uuid.Nil, db.Employee{}
-	for _, s := range data {
+	id := uuid.Nil
+	targets := make(map[uuid.UUID]db.Employee)
+	leads := make(map[uuid.UUID][]time.Duration)
+	for i := 0; i < len(data); {
+		s := data[i]
 		// если это uuid
 		if uuid, err := uuid.FromString(s); err == nil {
+			i++
 			id = uuid
-			hours = 0
 			// проверит на существование в db
-			if subscribe, err = h.db.GetEmployee(db.Employee{ID: id}); err != nil {
+			subscribe, err := h.db.GetEmployee(db.Employee{ID: id})
+			if err != nil {
 				return c.Send(fmt.Sprintf("Вы отправили некорректные данные %s", s))
 			}
-		} else {
-			// если не uuid - пробуем считать время до оповещания
-			if hours, err = strconv.Atoi(s); err != nil {
-				return c.Send(fmt.Sprintf("Вы отправили некорректные данные %s", s))
+			if !subscribe.Status.Listed() {
+				return c.Send(fmt.Sprintf("Сотрудник %s деактивирован, подписаться на него нельзя", s))
 			}
+			targets[id] = subscribe
+			continue
 		}
 
-		if id != uuid.Nil {
-			duration := time.Duration(hours) * time.Hour
-			dateNotification := subscribe.BirthDate.Add(-duration)
-
-			// Создаем новое время с текущим годом и старыми остальными полями
-			dateNotification = time.Date(time.Now().Year(), dateNotification.Month(), dateNotification.Day(), dateNotification.Hour(),
-				dateNotification.Minute(), dateNotification.Second(), dateNotification.Nanosecond(), dateNotification.Location())
+		// если не uuid - пробуем считать время до оповещания
+		if id == uuid.Nil {
+			return c.Send(fmt.Sprintf("Вы отправили некорректные данные %s: сначала UUID сотрудника", s))
+		}
+		lead, n, err := parseLead(data[i:])
+		if err != nil {
+			return c.Send(fmt.Sprintf("Не удалось разобрать время оповещания: %s", err))
+		}
+		leads[id] = append(leads[id], lead)
+		i += n
+	}
 
-			if dateNotification.Truncate(time.Hour).Before(time.Now().Truncate(time.Hour)) {
-				// Создаем новое время в следующем году
-				dateNotification = time.Date(time.Now().Year()+1, dateNotification.Month(), dateNotification.Day(), dateNotification.Hour(),
-					dateNotification.Minute(), dateNotification.Second(), dateNotification.Nanosecond(), dateNotification.Location())
+	// сохраняем подписки в db: отправленные оповещания заменяют прежние
+	for id, subscribe := range targets {
+		if len(leads[id]) == 0 {
+			leads[id] = []time.Duration{0}
+		}
+		err := h.db.RemoveSubscription(employee.ID, id, db.EventBirthday)
+		if err != nil && !errors.Is(err, db.ErrSubscriptionNotFound) {
+			log.Println(err)
+			return c.Send("Ошибка, попробуйте еще раз")
+		}
+		for _, lead := range leads[id] {
+			err = h.db.AddSubscription(db.Subscription{
+				SubscriberID: employee.ID,
+				TargetID:     id,
+				Event:        db.EventBirthday,
+				LeadTime:     lead,
+				NextFireAt:   calendar.NextReminder(subscribe.BirthDate, lead, h.clock.Now(), employee.Location(), h.leapPolicy),
+			})
+			if err != nil {
+				log.Println(err)
+				return c.Send("Ошибка, попробуйте еще раз")
 			}
-
-			employee.Subscribe[id] = dateNotification
 		}
 	}
 
-	// патчим в db
-	err := h.db.PatchEmployee(db.Employee{ID: employee.ID, Subscribe: employee.Subscribe}, "Subscribe")
+	err := h.dialog.Transition(conversation, dialog.Idle)
 	if err != nil {
 		log.Println(err)
 		return c.Send("Ошибка, попробуйте еще раз")
@@ -176,7 +446,7 @@ func (h *Handle) waitSubscribe(employee db.Employee, c tb.Context, response stri
 }
 
 // waitUnSubscribe получает uuid сотрудников от которых нужно отписаться
-func (h *Handle) waitUnSubscribe(employee db.Employee, c tb.Context, response string) error {
+func (h *Handle) waitUnSubscribe(conversation dialog.Conversation, employee db.Employee, c tb.Context, response string) error {
 	data := strings.Fields(response)
 	if len(data) == 0 {
 		// на исходную позицию UnSubscribe
@@ -188,17 +458,24 @@ func (h *Handle) waitUnSubscribe(employee db.Employee, c tb.Context, response st
 		if err != nil {
 			return c.Send(fmt.Sprintf("Вы отправили некорректные данные %s", i))
 		}
-		// если uuid не существует - отправит предупреждение
-		if _, ok := employee.Subscribe[uuid]; !ok {
+
+		// удаляет подписки на все события сотрудника, если их нет - отправит предупреждение
+		removed := false
+		for _, event := range events {
+			err = h.db.RemoveSubscription(employee.ID, uuid, event.kind)
+			if err != nil && !errors.Is(err, db.ErrSubscriptionNotFound) {
+				log.Println(err)
+				return c.Send("Ошибка, попробуйте еще раз")
+			}
+			removed = removed || err == nil
+		}
+		if !removed {
 			c.Send(fmt.Sprintf("%s - в вашем списке нет", i))
 		}
-
-		// удаляет из map
-		delete(employee.Subscribe, uuid)
 	}
 
-	// патчит в bd
-	err := h.db.PatchEmployee(db.Employee{ID: employee.ID, Subscribe: employee.Subscribe}, "Subscribe")
+	// завершает диалог
+	err := h.dialog.Transition(conversation, dialog.Idle)
 	if err != nil {
 		log.Println(err)
 		return c.Send("Ошибка, попробуйте еще раз")
@@ -209,56 +486,69 @@ func (h *Handle) waitUnSubscribe(employee db.Employee, c tb.Context, response st
 
 // SubscribeToNotifications функция для подписки на уведомления о днях рождения
 func (h *Handle) SubscribeToNotifications(c tb.Context) error {
-	employee, err := h.authMiddleware(c)
+	employee, err := h.authMiddleware(c, db.RoleEmployee)
 	if err != nil {
 		c.Send("Пожалуйста, пройдите аутентификацию:\n/login")
 		return err
 	}
 
-	message := "Отправьте UUID сотрудников (да, этот длинный ключ), " +
-		"на которых хотите подписаться.\nЧерез пробел можно указать " +
-		"за сколько часов оповещать до дня рождения (одним числом), " +
-		"или оповещание прийдет в наступивший День рождения сотрудника." +
-		"\nНапример:\n\nb559d2f8-7319-4abb-8d8e-df7c98acff57 15\n" +
-		"9abbc7d6-16b6-4376-b5f2-4d9633e940f1\n" +
-		"c14edf46-2df1-4e1b-9d01-60d4f4dd3b99 20\n\n" +
+	text, markup, err := h.subscribePage(employee, 0, listAll)
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+	if err = c.Send(text, markup); err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+
+	message := "Можно и по-старому: отправьте UUID сотрудников, через пробел - когда оповестить " +
+		"о дне рождения (" + leadExamples + "; число без единицы - часы). " +
+		"Несколько оповещаний - через запятую.\nНапример:\n\n" +
+		"b559d2f8-7319-4abb-8d8e-df7c98acff57 за неделю, накануне в 18:00, в день\n\n" +
 		"/list - получить список сотрудников"
 	err = c.Send(message)
 	if err != nil {
 		log.Println(err)
 		return c.Send("Ошибка, попробуйте еще раз")
 	}
-	// Ставим флаг ожидания uuid сотрудников true
-	return h.db.PatchEmployee(db.Employee{ID: employee.ID, WaitSubscribe: true}, "Wait")
+	// Ждем uuid сотрудников
+	return h.dialog.Start(dialog.Conversation{TelegramID: c.Sender().ID, State: dialog.AwaitSubscribe, EmployeeID: employee.ID})
 }
 
 // UnsubscribeFromNotifications функция для отписки от уведомлений о днях рождения
 func (h *Handle) UnsubscribeFromNotifications(c tb.Context) error {
-	employee, err := h.authMiddleware(c)
+	employee, err := h.authMiddleware(c, db.RoleEmployee)
 
 	if err != nil {
 		c.Send("Пожалуйста, пройдите аутентификацию:\n/login")
 		return err
 	}
 
-	message := "Отправьте UUID сотрудников " +
-		"(да, снова этот длинный ключ), от которых хотите отписаться.\n" +
-		"Например:\n\nb559d2f8-7319-4abb-8d8e-df7c98acff57\n" +
-		"9abbc7d6-16b6-4376-b5f2-4d9633e940f1\n" +
-		"c14edf46-2df1-4e1b-9d01-60d4f4dd3b99\n\n" +
-		"/list - получить список сотрудников"
+	text, markup, err := h.subscribePage(employee, 0, listMine)
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+	if err = c.Send(text, markup); err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+
+	message := "Можно и по-старому: отправьте UUID сотрудников, от которых хотите отписаться.\n" +
+		"/subscribed - получить список (на кого подписан)"
 	err = c.Send(message)
 	if err != nil {
 		log.Println(err)
 		return c.Send("Ошибка, попробуйте еще раз")
 	}
 
-	return h.db.PatchEmployee(db.Employee{ID: employee.ID, WaitUnsubscribe: true}, "Wait")
+	return h.dialog.Start(dialog.Conversation{TelegramID: c.Sender().ID, State: dialog.AwaitUnsubscribe, EmployeeID: employee.ID})
 }
 
 // Subscribed отправляет пользователю csv со списком (на кого он подписан)
 func (h *Handle) Subscribed(c tb.Context) error {
-	_, err := h.authMiddleware(c)
+	_, err := h.authMiddleware(c, db.RoleEmployee)
 
 	if err != nil {
 		c.Send("Пожалуйста, пройдите аутентификацию:\n/login")
@@ -274,7 +564,7 @@ func (h *Handle) Subscribed(c tb.Context) error {
 	defer os.Remove(file.Name())
 
 	// Записываем заголовок в файл
-	_, err = file.WriteString("UUID,First_name,Patronymic,Last_name, Birth_date, Notification\n")
+	_, err = file.WriteString("UUID,First_name,Patronymic,Last_name, Birth_date, Event, Reminders, Notification, Team\n")
 	if err != nil {
 		fmt.Println(err)
 		return err
@@ -286,15 +576,64 @@ func (h *Handle) Subscribed(c tb.Context) error {
 		return c.Send("Ошибка, попробуйте еще раз")
 	}
 
-	for i, j := range e.Subscribe {
-		employee, err := h.db.GetEmployee(db.Employee{ID: i})
+	subscriptions, err := h.db.GetSubscriptions(e.ID)
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+
+	// Подписки на команды - сообщением, подписки по ним - в файле с названием команды
+	teams, err := h.teamSubscriptionsText(e.ID)
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+	if teams != "" {
+		c.Send(teams)
+	}
+
+	// Строка на подписку: все ее оповещания и ближайшее из них
+	var lines [][]db.Subscription
+	grouped, targets := groupSubscriptions(subscriptions)
+	for _, id := range targets {
+		for _, event := range events {
+			var current []db.Subscription
+			for _, s := range grouped[id] {
+				if s.Event == event.kind {
+					current = append(current, s)
+				}
+			}
+			if len(current) > 0 {
+				lines = append(lines, current)
+			}
+		}
+	}
+
+	teamNames := make(map[uuid.UUID]string)
+	for _, line := range lines {
+		s := line[0]
+		employee, err := h.db.GetEmployee(db.Employee{ID: s.TargetID})
 		if err != nil {
 			log.Println(err)
 			return c.Send("Ошибка, попробуйте еще раз")
 		}
 
-		_, err = file.WriteString(fmt.Sprintf("%s,%s,%s,%s,%s,%s\n",
-			employee.ID, employee.FirstName, employee.Patronymic, employee.LastName, employee.BirthDate, j))
+		teamName := ""
+		if s.TeamID.Valid {
+			if _, ok := teamNames[s.TeamID.UUID]; !ok {
+				team, err := h.db.GetTeam(db.Team{ID: s.TeamID.UUID})
+				if err != nil {
+					log.Println(err)
+					return c.Send("Ошибка, попробуйте еще раз")
+				}
+				teamNames[team.ID] = team.Name
+			}
+			teamName = teamNames[s.TeamID.UUID]
+		}
+
+		_, err = file.WriteString(fmt.Sprintf("%s,%s,%s,%s,%s,%s,%s,%s,%s\n",
+			employee.ID, employee.FirstName, employee.Patronymic, employee.LastName, employee.BirthDate, s.Event,
+			strings.ReplaceAll(leadsText(line), ", ", " / "), nearestFireAt(line).In(e.Location()), teamName))
 		if err != nil {
 			fmt.Println(err)
 			return err
@@ -311,9 +650,75 @@ func (h *Handle) Subscribed(c tb.Context) error {
 	return nil
 }
 
+// Timezone показывает или меняет часовой пояс пользователя: /timezone Europe/Moscow
+func (h *Handle) Timezone(c tb.Context) error {
+	employee, err := h.authMiddleware(c, db.RoleEmployee)
+	if err != nil {
+		c.Send("Пожалуйста, пройдите аутентификацию:\n/login")
+		return err
+	}
+
+	if len(c.Args()) == 0 {
+		return c.Send(fmt.Sprintf("Ваш часовой пояс: %s\n"+
+			"Чтобы изменить, отправьте команду с названием пояса из базы IANA, например:\n\n"+
+			"/timezone Europe/Moscow\n/timezone Asia/Yekaterinburg", employee.Location()))
+	}
+
+	// "Local" - пояс сервера, а не пользователя
+	name := c.Args()[0]
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return c.Send(fmt.Sprintf("Неизвестный часовой пояс %s", name))
+	}
+
+	if err = h.db.PatchEmployee(employee.ID, db.EmployeeUpdate{}.SetTimezone(loc.String())); err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+
+	// Напоминания приходят по местному времени получателя - пересчитываем их
+	if err = h.reschedule(employee.ID); err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+
+	return c.Send(fmt.Sprintf("Часовой пояс изменен на %s", loc))
+}
+
+// Find ищет сотрудников по ФИО или email: /find Иванов
+func (h *Handle) Find(c tb.Context) error {
+	employee, err := h.authMiddleware(c, db.RoleEmployee)
+	if err != nil {
+		c.Send("Пожалуйста, пройдите аутентификацию:\n/login")
+		return err
+	}
+
+	query := strings.TrimSpace(c.Message().Payload)
+	if query == "" {
+		return c.Send("Укажите, кого искать (можно с опечатками и латиницей).\nНапример:\n\n/find Иванов\n/find ivan.petrov@")
+	}
+
+	found, err := h.db.SearchEmployees(query, db.LIMIT)
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+	if len(found) == 0 {
+		return c.Send("Никого не нашлось.\n/subscribe - выбрать из списка")
+	}
+
+	markup, err := h.searchResults(employee, found)
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+
+	return c.Send("Найдены сотрудники (➕ - подписаться в день рождения, имя - другие варианты):", markup)
+}
+
 // List отправляет пользователю csv со списком сотрудников
 func (h *Handle) List(c tb.Context) error {
-	_, err := h.authMiddleware(c)
+	_, err := h.authMiddleware(c, db.RoleEmployee)
 
 	if err != nil {
 		c.Send("Пожалуйста, пройдите аутентификацию:\n/login")
@@ -375,220 +780,11 @@ func (h *Handle) List(c tb.Context) error {
 	return nil
 }
 
-// Scheduler
-func (h *Handle) Scheduler(b *tb.Bot) {
-	var t td.TDlib
-
-	groupID, err := strconv.ParseInt(os.Getenv("TELEGRAM_GROUP"), 10, 64)
-	if err != nil {
-		log.Fatal("Невалидный TELEGRAM_GROUP")
-		return
-	}
-
-	apiID, err := strconv.Atoi(os.Getenv("API_ID"))
-	if err != nil {
-		panic("Невалидный API_ID")
-	}
-
-	t, err = td.NewTDlib(int32(apiID), os.Getenv("API_HASH"))
-	if err != nil {
-		panic(err.Error())
-	}
-	defer t.TDlibStop()
-
-	// Проверяем, существует ли группа
-	_, err = t.GetGroup(groupID)
-	if err != nil {
-		// Создаем новую группу
-		groupID, err = t.CreateNewGroup(os.Getenv("NAME_TELEGRAM_GROUP"))
-		if err != nil {
-			panic(err)
-		}
+// errForbidden у пользователя нет нужной роли
+var errForbidden = errors.New("недостаточно прав")
 
-		_, err = t.GetGroup(groupID)
-		if err != nil {
-			panic(err)
-		}
-
-		// заменит groupID
-		changeEnv(groupID)
-	}
-
-	if err = h.SchedulerNotifications(t, b, groupID); err != nil {
-		log.Println(err)
-	}
-
-	for {
-		// Получаем текущее время
-		now := time.Now()
-		// Определяем время следующего часа в 00 минут
-		nextHour := time.Date(now.Year(), now.Month(), now.Day(), now.Hour()+1, 0, 0, 0, now.Location())
-		// Вычисляем, сколько времени осталось до следующего часа в 00 минут
-		waitTime := nextHour.Sub(now)
-		// Ждём до следующего часа в 00 минут
-		time.Sleep(waitTime)
-		fmt.Println("Scheduler выполняет проверку: ", time.Now().Format("2006-01-02 15:04:05"))
-
-		if err = h.SchedulerNotifications(t, b, groupID); err != nil {
-			log.Println(err)
-		}
-	}
-}
-
-// changeEnv заменит "TELEGRAM_GROUP=" в .env на актуальные данные
-func changeEnv(groupID int64) {
-	groupIDstr := strconv.Itoa(int(groupID))
-
-	oldID := os.Getenv("TELEGRAM_GROUP")
-
-	// Путь к файлу .env
-	envFilePath := "../configs/.env"
-
-	// Строка для поиска
-	searchString := fmt.Sprintf(`TELEGRAM_GROUP=%s`, oldID)
-
-	// Новая строка для замены
-	replaceString := fmt.Sprintf(`TELEGRAM_GROUP=%s`, groupIDstr)
-
-	// Открытие файла для чтения
-	file, err := os.Open(envFilePath)
-	if err != nil {
-		fmt.Println("Ошибка открытия .env:", err)
-		return
-	}
-	defer file.Close()
-
-	// Создание нового файла для записи
-	newFile, err := os.Create(envFilePath + ".tmp")
-	if err != nil {
-		fmt.Println("Ошибка создания нового файла .env:", err)
-		return
-	}
-	defer newFile.Close()
-
-	// Чтение содержимого файла
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		// Проверка, содержит ли строка искомое значение
-		if strings.Contains(line, searchString) {
-			// Замена искомой строки на новую
-			line = strings.ReplaceAll(line, searchString, replaceString)
-		}
-		// Запись обновленной строки в новый файл
-		_, err = newFile.WriteString(line + "\n")
-		if err != nil {
-			fmt.Println("Ошибка записи в новый файл .env:", err)
-			return
-		}
-	}
-
-	// Переименование временного файла в оригинальный
-	err = os.Rename(envFilePath+".tmp", envFilePath)
-	if err != nil {
-		fmt.Println("Ошибка переименования файла .env:", err)
-		return
-	}
-}
-
-// SchedulerNotifications функция по сегментам достает данные из db для проверки
-func (h *Handle) SchedulerNotifications(t td.TDlib, b *tb.Bot, groupID int64) error {
-	page := 0
-	count, err := h.db.GetCount()
-	if err != nil {
-		fmt.Println(err)
-		return err
-	}
-
-	for {
-		employees, err := h.db.GetPage(page)
-		if err != nil {
-			fmt.Println(err)
-			return err
-		}
-
-		if err = h.checkEmployees(employees, t, b, groupID); err != nil {
-			return err
-		}
-
-		// Проверяем, есть ли еще страницы
-		if page*db.LIMIT >= count {
-			break
-		}
-		// Переходим к следующей странице
-		page++
-	}
-
-	return nil
-}
-
-// checkEmployees проверяет каждого пользователя
-func (h *Handle) checkEmployees(employees []db.Employee, t td.TDlib, b *tb.Bot, groupID int64) error {
-	for _, employee := range employees {
-		if len(employee.Subscribe) != 0 {
-			// Если пользователь подписан на кого-то и не состоит в группе
-			if !employee.InTgGroup {
-				// Добавляем его в группу
-				err := t.AddUserToGroup(groupID, employee.TelegramID)
-				if err != nil {
-					log.Println(err)
-				} else {
-					h.db.PatchEmployee(db.Employee{ID: employee.ID, InTgGroup: true}, "InTgGroup")
-				}
-			}
-
-			// Проверяем, если у него День рождения -
-			if employee.BirthDate.Month() == time.Now().Month() &&
-				employee.BirthDate.Day() == time.Now().Day() && employee.BirthDate.Hour() == time.Now().Hour() {
-				// бот отправит поздравление
-				message := "Поздравляю тебя с Днём рождения! Желаю тебе исполнения всех твоих " +
-					"мечтаний и достижения поставленных целей. Пусть успех сопровождает " +
-					"тебя всегда и во всём, а здоровье будет крепким, как алмаз!"
-
-				b.Send(&tb.Chat{ID: employee.TelegramID}, message)
-			}
-
-			newSubscribe, flag := make(map[uuid.UUID]time.Time), false
-			// Проверяем время оповещания его подписок
-			for k, t := range employee.Subscribe {
-				newSubscribe[k] = t
-
-				if t.Month() == time.Now().Month() && t.Day() == time.Now().Day() && t.Hour() == time.Now().Hour() {
-					// Если пришло время - оповещаем о Дне рождения у сотрудника, на которого подписан
-					e, err := h.db.GetEmployee(db.Employee{ID: k})
-					if err != nil {
-						return err
-					}
-
-					message := fmt.Sprintf("Самое время напомнить!\n\n %s %s %s - День рождения %v.\n\n Не забудьте поздравить!",
-						e.FirstName, e.Patronymic, e.LastName, e.BirthDate)
-
-					_, err = b.Send(&tb.Chat{ID: employee.TelegramID}, message)
-					if err != nil {
-						log.Println("Ошибка оповещания о Дне рожденния:", err)
-					}
-					// Обновляем дату оповещания
-					newDateNotification := time.Date(time.Now().Year()+1, t.Month(), t.Day(),
-						t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
-
-					newSubscribe[k] = newDateNotification
-					flag = true
-				}
-			}
-			if flag {
-				err := h.db.PatchEmployee(db.Employee{ID: employee.ID, Subscribe: newSubscribe}, "Subscribe")
-				if err != nil {
-					log.Println("Ошибка сохранения новой даты оповещания:", err)
-				}
-			}
-		}
-	}
-
-	return nil
-}
-
-// authMiddleware проверка авторизации у пользователей
-func (h *Handle) authMiddleware(c tb.Context) (db.Employee, error) {
+// authMiddleware проверка авторизации у пользователей: действующая сессия и роль не ниже min
+func (h *Handle) authMiddleware(c tb.Context, min db.Role) (db.Employee, error) {
 	// Получает JWT-токен из контекста сообщения
 	employee, err := h.db.GetEmployee(db.Employee{TelegramID: c.Sender().ID})
 	if err != nil {
@@ -601,14 +797,30 @@ func (h *Handle) authMiddleware(c tb.Context) (db.Employee, error) {
 		return db.Employee{}, errors.New("токен не доступен")
 	}
 
-	// Верифицирует и распарсит токен
-	if err = db.JwtParse(jwtToken); err != nil {
+	// Проверит токен и сессию
+	if err = h.checkSession(employee, c.Sender().ID); err != nil {
 		return db.Employee{}, err
 	}
 
+	if employee.Status == db.StatusTerminated {
+		return db.Employee{}, errors.New("учетная запись деактивирована")
+	}
+	if !employee.Role.AtLeast(min) {
+		return db.Employee{}, errForbidden
+	}
+
 	return employee, nil
 }
 
+// denied ответит пользователю, почему команда ему недоступна
+func (h *Handle) denied(c tb.Context, err error) error {
+	if errors.Is(err, errForbidden) {
+		return c.Send("Недостаточно прав для этой команды")
+	}
+
+	return c.Send("Пожалуйста, пройдите аутентификацию:\n/login")
+}
+
 // BotStart обработка команды /start
 func (h *Handle) BotStart(c tb.Context) error {
 	if err := c.Send("Привет! Я, бот-помощник, для поздравлений сотрудников с Днем рождения)"); err != nil {
@@ -623,18 +835,64 @@ func (h *Handle) BotHelp(c tb.Context) error {
 	if err := c.Send("/login - пройти аутентификацию"); err != nil {
 		return err
 	}
-	if err := c.Send("/subscribe - подписаться на оповещения о Дне рождения"); err != nil {
+	if err := c.Send("/subscribe - подписаться на оповещения о Дне рождения и годовщине работы"); err != nil {
 		return err
 	}
-	if err := c.Send("/unsubscribe - отписаться от оповещений о Дне рождения"); err != nil {
+	if err := c.Send("/unsubscribe - отписаться от оповещений о коллеге"); err != nil {
 		return err
 	}
 	if err := c.Send("/list - получить список сотрудников"); err != nil {
 		return err
 	}
+	if err := c.Send("/find - найти сотрудника по имени или email"); err != nil {
+		return err
+	}
+	if err := c.Send("/teams - подписаться на весь отдел или команду"); err != nil {
+		return err
+	}
+	if err := c.Send("/events - праздники компании и события коллег, /addevent - добавить свое"); err != nil {
+		return err
+	}
 	if err := c.Send("/subscribed - проверить список (на кого подписан)"); err != nil {
 		return err
 	}
+	if err := c.Send("/digest - дайджест Дней рождения на неделю или месяц одним сообщением"); err != nil {
+		return err
+	}
+	if err := c.Send("/timezone - часовой пояс для поздравлений и напоминаний"); err != nil {
+		return err
+	}
+	if err := c.Send("/cancel - отменить текущее действие"); err != nil {
+		return err
+	}
+	if err := c.Send("/logout - выйти из учетной записи"); err != nil {
+		return err
+	}
+
+	// Команды отдела кадров и администратора видят только они
+	if _, err := h.authMiddleware(c, db.RoleHR); err == nil {
+		err = c.Send("Отделу кадров:\n" +
+			"отправьте таблицу сотрудников (.csv, .xlsx или .json) - импорт с предпросмотром\n" +
+			"/newteam, /newdepartment - создать команду или отдел\n" +
+			"/teamadd, /teamremove - добавить или убрать участника\n" +
+			"/deleteteam - удалить команду или отдел")
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := h.authMiddleware(c, db.RoleAdmin); err == nil {
+		err = c.Send("Администратору:\n" +
+			"/addemployee - добавить сотрудника\n" +
+			"/editemployee - изменить данные или роль сотрудника\n" +
+			"/deactivate, /activate - закрыть или вернуть сотруднику доступ\n" +
+			"/leave - отправить сотрудника в отпуск (напоминания ему не приходят до /activate)\n" +
+			"/addevent без UUID - событие всей компании, /deleteevent - удалить любое событие\n" +
+			"/deadletters - недоставленные оповещания\n" +
+			"/runscheduler - запустить планировщик сейчас")
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -0,0 +1,183 @@
+package handle
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"birthdayGreetings/internal/db"
+	"birthdayGreetings/internal/dialog"
+	"birthdayGreetings/internal/roster"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	// importMaxSize самый большой принимаемый файл
+	importMaxSize = 5 << 20
+	// importPreviewLimit сколько строк каждого вида показывать в предпросмотре и ошибках
+	importPreviewLimit = 15
+)
+
+// importData ожидающий подтверждения импорт: строки таблицы и показанный предпросмотр
+type importData struct {
+	Rows    []roster.Row `json:"rows"`
+	Preview string       `json:"preview"`
+}
+
+// ImportEmployees принимает от отдела кадров таблицу сотрудников (.csv, .xlsx или .json),
+// проверяет ее и показывает, что изменится. Применяется только после подтверждения
+func (h *Handle) ImportEmployees(c tb.Context) error {
+	hr, err := h.authMiddleware(c, db.RoleHR)
+	if err != nil {
+		return h.denied(c, err)
+	}
+
+	doc := c.Message().Document
+	if doc.FileSize > importMaxSize {
+		return c.Send(fmt.Sprintf("Файл слишком большой, максимум %d МБ", importMaxSize>>20))
+	}
+	file, err := c.Bot().File(&doc.File)
+	if err != nil {
+		log.Println(err)
+		return c.Send("Не удалось скачать файл, попробуйте еще раз")
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, importMaxSize))
+	if err != nil {
+		log.Println(err)
+		return c.Send("Не удалось скачать файл, попробуйте еще раз")
+	}
+
+	rows, problems, err := roster.Parse(doc.FileName, data, h.clock.Now())
+	if err != nil {
+		return c.Send("Не удалось прочитать таблицу: " + err.Error())
+	}
+	current, err := h.db.GetEmployees()
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+	plan, conflicts := roster.Diff(current, rows)
+	if problems = append(problems, conflicts...); len(problems) > 0 {
+		return c.Send(importProblems(problems))
+	}
+	if plan.IsEmpty() {
+		return c.Send("Таблица совпадает с базой, менять нечего")
+	}
+	preview := plan.Preview(importPreviewLimit)
+
+	payload, err := json.Marshal(importData{Rows: rows, Preview: preview})
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+	err = h.dialog.Start(dialog.Conversation{
+		TelegramID: c.Sender().ID, State: dialog.AwaitImport, EmployeeID: hr.ID, Data: string(payload)})
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+
+	return c.Send(preview + "\nПрименить? Ответьте \"да\" или \"нет\"")
+}
+
+// waitImport ждет подтверждения импорта и применяет его в одной транзакции
+func (h *Handle) waitImport(conversation dialog.Conversation, c tb.Context, response string) error {
+	switch strings.ToLower(strings.TrimSpace(response)) {
+	case "да":
+	case "нет":
+		h.dialog.Transition(conversation, dialog.Idle)
+		return c.Send("Импорт отменен")
+	default:
+		return c.Send("Ответьте \"да\" или \"нет\"\n/cancel - отменить импорт")
+	}
+
+	if _, err := h.authMiddleware(c, db.RoleHR); err != nil {
+		h.dialog.Transition(conversation, dialog.Idle)
+		return h.denied(c, err)
+	}
+
+	var data importData
+	if err := json.Unmarshal([]byte(conversation.Data), &data); err != nil {
+		log.Println(err)
+		h.dialog.Transition(conversation, dialog.Idle)
+		return c.Send("Ошибка, отправьте таблицу еще раз")
+	}
+
+	// База могла измениться после предпросмотра - тогда показываем новый
+	current, err := h.db.GetEmployees()
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+	plan, problems := roster.Diff(current, data.Rows)
+	if len(problems) > 0 {
+		h.dialog.Transition(conversation, dialog.Idle)
+		return c.Send("Пока вы смотрели, данные в базе изменились. " + importProblems(problems))
+	}
+	if preview := plan.Preview(importPreviewLimit); preview != data.Preview {
+		data.Preview = preview
+		payload, _ := json.Marshal(data)
+		conversation.Data = string(payload)
+		if err = h.dialog.Start(conversation); err != nil {
+			log.Println(err)
+			return c.Send("Ошибка, попробуйте еще раз")
+		}
+		return c.Send("Пока вы смотрели, данные в базе изменились. Новый предпросмотр:\n\n" +
+			preview + "\nПрименить? Ответьте \"да\" или \"нет\"")
+	}
+
+	if err = h.applyImport(plan); err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, импорт не применен. Попробуйте еще раз")
+	}
+	h.dialog.Transition(conversation, dialog.Idle)
+
+	return c.Send(fmt.Sprintf("Импорт применен: добавлено %d, изменено %d, деактивировано %d",
+		len(plan.Added), len(plan.Changed), len(plan.Removed)))
+}
+
+// applyImport применит план в одной транзакции, затем пересчитает напоминания тем, у кого
+// сменилась дата рождения или приема, вернет вернувшихся в подписки на их команды и закроет сессии деактивированных
+func (h *Handle) applyImport(plan roster.Plan) error {
+	if err := h.db.ImportEmployees(plan.Import()); err != nil {
+		return err
+	}
+
+	for _, change := range plan.Changed {
+		hired := change.Row.HireDate
+		if !change.Employee.BirthDate.Equal(change.Row.BirthDate) ||
+			!hired.IsZero() && !(change.Employee.HireDate.Valid && change.Employee.HireDate.Time.Equal(hired)) {
+			if err := h.reschedule(change.Employee.ID); err != nil {
+				log.Println(err)
+			}
+		}
+		if change.Employee.Status == db.StatusTerminated {
+			if err := h.syncMemberTeams(change.Employee.ID); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	for _, e := range plan.Removed {
+		if err := h.db.RevokeSessions(e.ID, h.clock.Now()); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return nil
+}
+
+// importProblems текст отказа в импорте из-за ошибок в строках таблицы
+func importProblems(problems []error) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "В таблице ошибки (%d), ничего не изменено:\n\n", len(problems))
+	for i := 0; i < len(problems) && i < importPreviewLimit; i++ {
+		b.WriteString(problems[i].Error() + "\n")
+	}
+	b.WriteString("\nИсправьте таблицу и отправьте ее снова")
+
+	return b.String()
+}
@@ -0,0 +1,516 @@
+package handle
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"birthdayGreetings/internal/db"
+
+	"github.com/gofrs/uuid"
+	tb "gopkg.in/telebot.v3"
+)
+
+// Уникальные имена inline-кнопок, данные кнопок разделяются "|"
+const (
+	btnPage  = "sub_page" // страница списка: page|mode
+	btnCard  = "sub_card" // карточка сотрудника: id|page|mode
+	btnOn    = "sub_on"   // включить или выключить оповещание: id|page|mode|hours|event
+	btnOff   = "sub_off"  // отписаться: id|page|mode|event
+	btnNoop  = "sub_noop" // кнопка без действия (номер страницы)
+	listAll  = "a"        // режим списка: все сотрудники
+	listMine = "m"        // режим списка: только подписки
+)
+
+// events виды событий в карточке сотрудника: значок, название и аргумент кнопки
+// (без аргумента кнопка относится к Дню рождения)
+var events = []struct {
+	kind  db.EventKind
+	icon  string
+	title string
+	arg   string
+}{
+	{db.EventBirthday, "🎂", "День рождения", "b"},
+	{db.EventAnniversary, "🏢", "Годовщина работы", "a"},
+}
+
+// leadChoices варианты, за сколько до события оповещать
+var leadChoices = []struct {
+	text string
+	lead time.Duration
+}{
+	{"В день", 0},
+	{"За день", 24 * time.Hour},
+	{"За 3 дня", 72 * time.Hour},
+	{"За неделю", 7 * 24 * time.Hour},
+}
+
+// months названия месяцев в родительном падеже
+var months = [...]string{"января", "февраля", "марта", "апреля", "мая", "июня",
+	"июля", "августа", "сентября", "октября", "ноября", "декабря"}
+
+// RegisterCallbacks регистрирует обработчики inline-кнопок подписки на сотрудников, команды и события
+func (h *Handle) RegisterCallbacks(b *tb.Bot) {
+	b.Handle(&tb.Btn{Unique: btnPage}, h.onSubscribePage)
+	b.Handle(&tb.Btn{Unique: btnCard}, h.onSubscribeCard)
+	b.Handle(&tb.Btn{Unique: btnOn}, h.onSubscribe)
+	b.Handle(&tb.Btn{Unique: btnOff}, h.onUnsubscribe)
+	b.Handle(&tb.Btn{Unique: btnNoop}, func(c tb.Context) error { return c.Respond() })
+	b.Handle(&tb.Btn{Unique: btnTeams}, h.onTeams)
+	b.Handle(&tb.Btn{Unique: btnTeamCard}, h.onTeamCard)
+	b.Handle(&tb.Btn{Unique: btnTeamOn}, h.onTeamSubscribe)
+	b.Handle(&tb.Btn{Unique: btnTeamOff}, h.onTeamUnsubscribe)
+	b.Handle(&tb.Btn{Unique: btnEvents}, h.onEvents)
+	b.Handle(&tb.Btn{Unique: btnEventCard}, h.onEventCard)
+	b.Handle(&tb.Btn{Unique: btnEventOn}, h.onEventSubscribe)
+	b.Handle(&tb.Btn{Unique: btnEventOff}, h.onEventUnsubscribe)
+}
+
+// onSubscribePage листает список сотрудников
+func (h *Handle) onSubscribePage(c tb.Context) error {
+	employee, err := h.callbackAuth(c)
+	if err != nil {
+		return err
+	}
+
+	page, mode := callbackPage(c.Args(), 0)
+	text, markup, err := h.subscribePage(employee, page, mode)
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+
+	c.Edit(text, markup)
+	return c.Respond()
+}
+
+// onSubscribeCard открывает карточку сотрудника с кнопками подписки
+func (h *Handle) onSubscribeCard(c tb.Context) error {
+	employee, err := h.callbackAuth(c)
+	if err != nil {
+		return err
+	}
+
+	args := c.Args()
+	target, err := h.callbackTarget(args)
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+
+	return h.editCard(c, employee, target, args, "")
+}
+
+// onSubscribe включает оповещание за выбранное время (или выключает уже включенное)
+// и обновляет карточку
+func (h *Handle) onSubscribe(c tb.Context) error {
+	employee, err := h.callbackAuth(c)
+	if err != nil {
+		return err
+	}
+
+	args := c.Args()
+	target, err := h.callbackTarget(args)
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+
+	hours := 0
+	if len(args) > 3 {
+		hours, _ = strconv.Atoi(args[3])
+	}
+	lead := time.Duration(hours) * time.Hour
+	event := callbackEvent(args, 4)
+
+	subscriptions, err := h.db.GetSubscriptions(employee.ID)
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+	personal, enabled := true, false
+	for _, s := range subscriptions {
+		if s.TargetID == target.ID && s.Event == event {
+			personal = personal && !s.TeamID.Valid
+			enabled = enabled || s.LeadTime == lead
+		}
+	}
+
+	if personal && enabled {
+		err = h.db.RemoveSubscriptionLead(employee.ID, target.ID, event, lead)
+		if err != nil && !errors.Is(err, db.ErrSubscriptionNotFound) {
+			return h.callbackError(c, err)
+		}
+		// Без последнего оповещания вернется командная подписка, если она есть
+		if err = h.syncTeamSubscriptions(employee.ID); err != nil {
+			return h.callbackError(c, err)
+		}
+		return h.editCard(c, employee, target, args, "Оповещание выключено")
+	}
+
+	next, ok := h.nextReminder(target, event, lead, h.clock.Now(), employee.Location())
+	if !ok {
+		return c.Respond(&tb.CallbackResponse{Text: "Дата приема сотрудника неизвестна", ShowAlert: true})
+	}
+	if !personal {
+		// Выбор времени делает командную подписку личной
+		err = h.db.RemoveSubscription(employee.ID, target.ID, event)
+		if err != nil && !errors.Is(err, db.ErrSubscriptionNotFound) {
+			return h.callbackError(c, err)
+		}
+	}
+	err = h.db.AddSubscription(db.Subscription{
+		SubscriberID: employee.ID,
+		TargetID:     target.ID,
+		Event:        event,
+		LeadTime:     lead,
+		NextFireAt:   next,
+	})
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+
+	return h.editCard(c, employee, target, args, "Оповещание включено")
+}
+
+// onUnsubscribe отписывает и обновляет карточку
+func (h *Handle) onUnsubscribe(c tb.Context) error {
+	employee, err := h.callbackAuth(c)
+	if err != nil {
+		return err
+	}
+
+	args := c.Args()
+	target, err := h.callbackTarget(args)
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+
+	err = h.db.RemoveSubscription(employee.ID, target.ID, callbackEvent(args, 3))
+	if err != nil && !errors.Is(err, db.ErrSubscriptionNotFound) {
+		return h.callbackError(c, err)
+	}
+	// Если сотрудник есть в командах, на которые подписан пользователь, остается командная подписка
+	if err = h.syncTeamSubscriptions(employee.ID); err != nil {
+		return h.callbackError(c, err)
+	}
+
+	return h.editCard(c, employee, target, args, "Вы отписаны")
+}
+
+// editCard перерисует сообщение карточкой сотрудника и ответит на нажатие
+func (h *Handle) editCard(c tb.Context, employee, target db.Employee, args []string, notice string) error {
+	page, mode := callbackPage(args, 1)
+	text, markup, err := h.subscribeCard(employee, target, page, mode)
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+
+	c.Edit(text, markup)
+	return c.Respond(&tb.CallbackResponse{Text: notice})
+}
+
+// subscribePage текст и клавиатура страницы списка: все сотрудники или только подписки
+func (h *Handle) subscribePage(employee db.Employee, page int, mode string) (string, *tb.ReplyMarkup, error) {
+	subscriptions, err := h.db.GetSubscriptions(employee.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	subscribed, targets := groupSubscriptions(subscriptions)
+
+	var employees []db.Employee
+	var count int
+	if mode == listMine {
+		count = len(targets)
+		for i := page * db.LIMIT; i >= 0 && i < count && i < (page+1)*db.LIMIT; i++ {
+			target, err := h.db.GetEmployee(db.Employee{ID: targets[i]})
+			if err != nil {
+				return "", nil, err
+			}
+			employees = append(employees, target)
+		}
+	} else {
+		if count, err = h.db.GetCount(); err != nil {
+			return "", nil, err
+		}
+		if employees, err = h.db.GetPage(page); err != nil {
+			return "", nil, err
+		}
+	}
+
+	pages := (count + db.LIMIT - 1) / db.LIMIT
+	if pages == 0 {
+		pages = 1
+	}
+
+	markup := &tb.ReplyMarkup{}
+	var rows []tb.Row
+	for _, e := range employees {
+		text := fmt.Sprintf("%s %s %s", e.LastName, e.FirstName, e.Patronymic)
+		if e.Status == db.StatusOnLeave {
+			text += " (в отпуске)"
+		}
+		if s, ok := subscribed[e.ID]; ok {
+			text = "✅ " + text + subscriptionMarks(s)
+		}
+		rows = append(rows, markup.Row(markup.Data(text, btnCard, e.ID.String(), strconv.Itoa(page), mode)))
+	}
+
+	var nav []tb.Btn
+	if page > 0 {
+		nav = append(nav, markup.Data("«", btnPage, strconv.Itoa(page-1), mode))
+	}
+	nav = append(nav, markup.Data(fmt.Sprintf("%d / %d", page+1, pages), btnNoop))
+	if page+1 < pages {
+		nav = append(nav, markup.Data("»", btnPage, strconv.Itoa(page+1), mode))
+	}
+	rows = append(rows, markup.Row(nav...))
+	markup.Inline(rows...)
+
+	text := "Выберите сотрудника, чтобы подписаться на оповещания о его Дне рождения " +
+		"или годовщине работы (✅ - вы уже подписаны):"
+	if mode == listMine {
+		text = "Ваши подписки. Выберите сотрудника, чтобы отписаться или изменить время оповещаний:"
+		if count == 0 {
+			text = "Вы пока ни на кого не подписаны.\n/subscribe - подписаться"
+		}
+	}
+
+	return text, markup, nil
+}
+
+// searchResults клавиатура результатов поиска: имя открывает карточку, ➕ сразу подписывает
+func (h *Handle) searchResults(employee db.Employee, found []db.Employee) (*tb.ReplyMarkup, error) {
+	subscriptions, err := h.db.GetSubscriptions(employee.ID)
+	if err != nil {
+		return nil, err
+	}
+	subscribed, _ := groupSubscriptions(subscriptions)
+
+	markup := &tb.ReplyMarkup{}
+	var rows []tb.Row
+	for _, e := range found {
+		id := e.ID.String()
+		text := fmt.Sprintf("%s %s %s, %d %s", e.LastName, e.FirstName, e.Patronymic,
+			e.BirthDate.Day(), months[e.BirthDate.Month()-1])
+		if s, ok := subscribed[e.ID]; ok {
+			rows = append(rows, markup.Row(
+				markup.Data("✅ "+text+subscriptionMarks(s), btnCard, id, "0", listAll)))
+			continue
+		}
+		rows = append(rows, markup.Row(
+			markup.Data(text, btnCard, id, "0", listAll),
+			markup.Data("➕", btnOn, id, "0", listAll, "0")))
+	}
+	markup.Inline(rows...)
+
+	return markup, nil
+}
+
+// subscribeCard текст и клавиатура карточки сотрудника: подписки на День рождения
+// и, если известна дата приема, на годовщину работы
+func (h *Handle) subscribeCard(employee, target db.Employee, page int, mode string) (string, *tb.ReplyMarkup, error) {
+	subscriptions, err := h.db.GetSubscriptions(employee.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	subscribed, _ := groupSubscriptions(subscriptions)
+
+	text := fmt.Sprintf("%s %s %s\nДень рождения: %d %s\n",
+		target.LastName, target.FirstName, target.Patronymic,
+		target.BirthDate.Day(), months[target.BirthDate.Month()-1])
+	if target.HireDate.Valid {
+		text += fmt.Sprintf("В компании с %s\n", target.HireDate.Time.Format("02.01.2006"))
+	}
+	if target.Status == db.StatusOnLeave {
+		text += "Сейчас в отпуске\n"
+	}
+
+	id, p := target.ID.String(), strconv.Itoa(page)
+	markup := &tb.ReplyMarkup{}
+	var rows []tb.Row
+	var unsubscribe []tb.Btn
+	for _, event := range events {
+		if _, ok := target.EventDate(event.kind); !ok {
+			continue
+		}
+
+		var current []db.Subscription
+		enabled := make(map[time.Duration]bool)
+		for _, s := range subscribed[target.ID] {
+			if s.Event == event.kind {
+				current = append(current, s)
+				enabled[s.LeadTime] = true
+			}
+		}
+
+		if len(current) == 0 {
+			text += fmt.Sprintf("\n%s %s: подписки нет", event.icon, event.title)
+		} else {
+			text += fmt.Sprintf("\n%s %s: %s, ближайшее оповещание %s", event.icon, event.title,
+				leadsText(current), nearestFireAt(current).In(employee.Location()).Format("02.01.2006 15:04"))
+			if current[0].TeamID.Valid {
+				team, err := h.db.GetTeam(db.Team{ID: current[0].TeamID.UUID})
+				if err != nil {
+					return "", nil, err
+				}
+				text += fmt.Sprintf("\nЧерез подписку на «%s»: отписаться можно от всей команды (/teams), "+
+					"выбор времени сделает подписку личной", team.Name)
+			} else {
+				unsubscribe = append(unsubscribe, markup.Data("Отписаться "+event.icon, btnOff, id, p, mode, event.arg))
+			}
+		}
+
+		var choices []tb.Btn
+		for _, choice := range leadChoices {
+			label := event.icon + " " + choice.text
+			if enabled[choice.lead] {
+				label = "• " + label
+			}
+			choices = append(choices, markup.Data(label, btnOn, id, p, mode,
+				strconv.Itoa(int(choice.lead/time.Hour)), event.arg))
+		}
+		rows = append(rows, markup.Split(2, choices)...)
+	}
+	text += "\n\nКогда оповестить? Можно выбрать несколько вариантов, повторное нажатие выключает оповещание"
+
+	if len(unsubscribe) > 0 {
+		rows = append(rows, markup.Row(unsubscribe...))
+	}
+	rows = append(rows, markup.Row(markup.Data("« К списку", btnPage, p, mode)))
+	markup.Inline(rows...)
+
+	return text, markup, nil
+}
+
+// groupSubscriptions подписки по сотрудникам и сотрудники в порядке ближайшего оповещания
+func groupSubscriptions(subscriptions []db.Subscription) (map[uuid.UUID][]db.Subscription, []uuid.UUID) {
+	grouped := make(map[uuid.UUID][]db.Subscription, len(subscriptions))
+	var targets []uuid.UUID
+	for _, s := range subscriptions {
+		if _, ok := grouped[s.TargetID]; !ok {
+			targets = append(targets, s.TargetID)
+		}
+		grouped[s.TargetID] = append(grouped[s.TargetID], s)
+	}
+
+	return grouped, targets
+}
+
+// subscriptionMarks значки событий и время оповещаний для строки списка
+func subscriptionMarks(subscriptions []db.Subscription) string {
+	var marks string
+	for _, event := range events {
+		var current []db.Subscription
+		for _, s := range subscriptions {
+			if s.Event == event.kind {
+				current = append(current, s)
+			}
+		}
+		if len(current) > 0 {
+			marks += " · " + event.icon + " " + leadsText(current)
+		}
+	}
+
+	return marks
+}
+
+// leadsText время оповещаний подписки словами, от самого раннего: "за 7 дн., в день"
+func leadsText(subscriptions []db.Subscription) string {
+	leads := make([]time.Duration, 0, len(subscriptions))
+	for _, s := range subscriptions {
+		leads = append(leads, s.LeadTime)
+	}
+	sort.Slice(leads, func(i, j int) bool { return leads[i] > leads[j] })
+
+	texts := make([]string, 0, len(leads))
+	for _, lead := range leads {
+		texts = append(texts, leadText(lead))
+	}
+
+	return strings.Join(texts, ", ")
+}
+
+// nearestFireAt время ближайшего из оповещаний подписки
+func nearestFireAt(subscriptions []db.Subscription) time.Time {
+	var nearest time.Time
+	for _, s := range subscriptions {
+		if nearest.IsZero() || s.NextFireAt.Before(nearest) {
+			nearest = s.NextFireAt
+		}
+	}
+
+	return nearest
+}
+
+// callbackEvent вид события из аргумента кнопки i (по умолчанию День рождения)
+func callbackEvent(args []string, i int) db.EventKind {
+	for _, event := range events {
+		if len(args) > i && args[i] == event.arg {
+			return event.kind
+		}
+	}
+
+	return db.EventBirthday
+}
+
+// callbackAuth аутентификация для нажатий на кнопки
+func (h *Handle) callbackAuth(c tb.Context) (db.Employee, error) {
+	employee, err := h.authMiddleware(c, db.RoleEmployee)
+	if err != nil {
+		c.Respond(&tb.CallbackResponse{Text: "Пожалуйста, пройдите аутентификацию: /login", ShowAlert: true})
+	}
+
+	return employee, err
+}
+
+// callbackTarget сотрудник из первого аргумента кнопки (деактивированные - как ненайденные:
+// кнопка могла остаться в старом сообщении)
+func (h *Handle) callbackTarget(args []string) (db.Employee, error) {
+	if len(args) == 0 {
+		return db.Employee{}, db.ErrNotFound
+	}
+	id, err := uuid.FromString(args[0])
+	if err != nil {
+		return db.Employee{}, err
+	}
+
+	target, err := h.db.GetEmployee(db.Employee{ID: id})
+	if err == nil && !target.Status.Listed() {
+		return db.Employee{}, db.ErrNotFound
+	}
+
+	return target, err
+}
+
+// callbackPage номер страницы и режим списка, начиная с аргумента кнопки i
+func callbackPage(args []string, i int) (int, string) {
+	page, mode := 0, listAll
+	if len(args) > i {
+		page, _ = strconv.Atoi(args[i])
+	}
+	if len(args) > i+1 && args[i+1] == listMine {
+		mode = listMine
+	}
+	if page < 0 {
+		page = 0
+	}
+
+	return page, mode
+}
+
+// callbackError сообщит пользователю об ошибке во всплывающем окне
+func (h *Handle) callbackError(c tb.Context, err error) error {
+	log.Println(err)
+	if errors.Is(err, db.ErrNotFound) {
+		return c.Respond(&tb.CallbackResponse{Text: "Сотрудник не найден", ShowAlert: true})
+	}
+	if errors.Is(err, db.ErrTeamNotFound) {
+		return c.Respond(&tb.CallbackResponse{Text: "Команда не найдена", ShowAlert: true})
+	}
+	if errors.Is(err, db.ErrEventNotFound) {
+		return c.Respond(&tb.CallbackResponse{Text: "Событие не найдено", ShowAlert: true})
+	}
+
+	return c.Respond(&tb.CallbackResponse{Text: "Ошибка, попробуйте еще раз", ShowAlert: true})
+}
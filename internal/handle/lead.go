@@ -0,0 +1,154 @@
+package handle
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLeadDays насколько заранее можно оповещать о событии
+const maxLeadDays = 60
+
+// leadExamples примеры времени оповещания для подсказок и ошибок
+const leadExamples = "3d, 1w, 2d 10:00, накануне в 18:00, за неделю, в день в 09:00"
+
+var (
+	// leadNumberRe число с необязательной единицей: 3, 3d, 3дн., 1w, 12ч
+	leadNumberRe = regexp.MustCompile(`^(\d+)(\pL*)\.?$`)
+	// clockRe время суток ЧЧ:ММ
+	clockRe = regexp.MustCompile(`^(\d{1,2}):(\d{2})$`)
+)
+
+// leadUnits единицы смещения слитно с числом или отдельным словом: d - дни, w - недели, h - часы
+var leadUnits = map[string]string{
+	"d": "d", "д": "d", "дн": "d", "день": "d", "дня": "d", "дней": "d",
+	"w": "w", "нед": "w", "неделю": "w", "недели": "w", "недель": "w",
+	"h": "h", "ч": "h", "час": "h", "часа": "h", "часов": "h",
+}
+
+// parseLead читает одно время оповещания с начала fields и вернет, сколько слов прочитано.
+// Смещение отсчитывается от полуночи дня события в часовом поясе подписчика: «2d 10:00» -
+// за два дня в 10:00, «в день в 09:00» - отрицательное смещение. Число без единицы - часы,
+// как раньше. Понимает и то, что выводит leadText
+func parseLead(fields []string) (time.Duration, int, error) {
+	i := 0
+	word := func(k int) string {
+		if i+k < len(fields) {
+			return strings.ToLower(fields[i+k])
+		}
+		return ""
+	}
+
+	if word(0) == "за" {
+		i++
+	}
+	if word(0) == "" {
+		return 0, 0, fmt.Errorf("после «за» не указано, за сколько оповестить")
+	}
+
+	days, hours := 0, -1
+	switch w := word(0); {
+	case w == "накануне":
+		days = 1
+		i++
+	case w == "неделю":
+		days = 7
+		i++
+	case w == "в" && word(1) == "день":
+		i += 2
+		if word(0) == "события" {
+			i++
+		}
+	case clockRe.MatchString(w), w == "в" && clockRe.MatchString(word(1)):
+		// только время суток - в день события
+	default:
+		m := leadNumberRe.FindStringSubmatch(w)
+		if m == nil {
+			return 0, 0, fmt.Errorf("«%s» - не понимаю, когда оповестить. Примеры: %s", fields[i], leadExamples)
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n > maxLeadDays*24 {
+			return 0, 0, fmt.Errorf("«%s» - оповещать можно не раньше чем за %d дней", fields[i], maxLeadDays)
+		}
+
+		unit, ok := leadUnits[m[2]]
+		switch {
+		case m[2] != "" && !ok:
+			return 0, 0, fmt.Errorf("«%s» - неизвестная единица, используйте d (дни), w (недели) или h (часы)", fields[i])
+		case m[2] != "":
+		case leadUnits[strings.TrimSuffix(word(1), ".")] != "":
+			unit = leadUnits[strings.TrimSuffix(word(1), ".")]
+			i++
+		default:
+			unit = "h"
+		}
+		i++
+
+		switch unit {
+		case "d":
+			days = n
+		case "w":
+			days = 7 * n
+		default:
+			hours = n
+		}
+	}
+
+	var clock time.Duration
+	if word(0) == "в" && clockRe.MatchString(word(1)) {
+		i++
+	}
+	if m := clockRe.FindStringSubmatch(word(0)); m != nil {
+		h, _ := strconv.Atoi(m[1])
+		minute, _ := strconv.Atoi(m[2])
+		if h > 23 || minute > 59 {
+			return 0, 0, fmt.Errorf("«%s» - такого времени суток нет", fields[i])
+		}
+		if hours >= 0 {
+			return 0, 0, fmt.Errorf("«%s» - время суток указывается вместе с днями, например 2d %s", fields[i], fields[i])
+		}
+		clock = time.Duration(h)*time.Hour + time.Duration(minute)*time.Minute
+		i++
+	}
+
+	if days > maxLeadDays || hours > maxLeadDays*24 {
+		return 0, 0, fmt.Errorf("«%s» - оповещать можно не раньше чем за %d дней",
+			strings.Join(fields[:i], " "), maxLeadDays)
+	}
+	if hours >= 0 {
+		return time.Duration(hours) * time.Hour, i, nil
+	}
+
+	return time.Duration(days)*24*time.Hour - clock, i, nil
+}
+
+// leadText время оповещания словами, в виде, который понимает parseLead
+func leadText(lead time.Duration) string {
+	day := 24 * time.Hour
+	days := int((lead + day - 1) / day)
+	if lead <= 0 {
+		days = 0
+	}
+	clock := time.Duration(days)*day - lead
+
+	var text string
+	switch {
+	case days == 0:
+		text = "в день"
+	case days == 1:
+		text = "накануне"
+	case days == 7:
+		text = "за неделю"
+	case days%7 == 0:
+		text = fmt.Sprintf("за %d нед.", days/7)
+	default:
+		text = fmt.Sprintf("за %d дн.", days)
+	}
+	if clock > 0 {
+		text += fmt.Sprintf(" в %02d:%02d", clock/time.Hour, clock%time.Hour/time.Minute)
+	}
+
+	return text
+}
@@ -0,0 +1,90 @@
+package handle
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLead(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		in   string
+		want time.Duration
+		read int // сколько слов прочитано
+	}{
+		{"3d", 3 * day, 1},
+		{"3дн.", 3 * day, 1},
+		{"за 3 дня", 3 * day, 3},
+		{"1w", 7 * day, 1},
+		{"за 2 нед.", 14 * day, 3},
+		{"за неделю", 7 * day, 2},
+		{"12ч", 12 * time.Hour, 1},
+		{"12 часов", 12 * time.Hour, 2},
+		{"12", 12 * time.Hour, 1},
+		{"2d 10:00", 2*day - 10*time.Hour, 2},
+		{"накануне", day, 1},
+		{"накануне в 18:00", 6 * time.Hour, 3},
+		{"в день", 0, 2},
+		{"в день в 09:00", -9 * time.Hour, 4},
+		{"в день события в 09:00", -9 * time.Hour, 5},
+		{"10:00", -10 * time.Hour, 1},
+		{"в 10:00", -10 * time.Hour, 2},
+		{"60d", maxLeadDays * day, 1},
+		{"1440", maxLeadDays * day, 1},
+		{"3d 1w", 3 * day, 1}, // читается только первое время
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, read, err := parseLead(strings.Fields(tt.in))
+			if err != nil {
+				t.Fatalf("parseLead(%q): %v", tt.in, err)
+			}
+			if got != tt.want || read != tt.read {
+				t.Errorf("parseLead(%q) = %s, %d, want %s, %d", tt.in, got, read, tt.want, tt.read)
+			}
+		})
+	}
+}
+
+func TestParseLeadErrors(t *testing.T) {
+	for _, in := range []string{
+		"за",
+		"завтра",
+		"3x",
+		"61d", // больше maxLeadDays
+		"9w",
+		"1441",
+		"99999999999999999999",
+		"2d 25:00",
+		"12h 10:00",
+		"в день в 9:60",
+	} {
+		t.Run(in, func(t *testing.T) {
+			if lead, _, err := parseLead(strings.Fields(in)); err == nil {
+				t.Errorf("parseLead(%q) = %s, ожидалась ошибка", in, lead)
+			}
+		})
+	}
+}
+
+// parseLead понимает все, что выводит leadText
+func TestLeadTextRoundTrip(t *testing.T) {
+	day := 24 * time.Hour
+	for _, lead := range []time.Duration{
+		0, -9 * time.Hour, -23*time.Hour - 59*time.Minute, time.Hour, 6 * time.Hour, 23 * time.Hour,
+		day, 2*day - 10*time.Hour, 3 * day, 7 * day, 14 * day, 15*day - 30*time.Minute, maxLeadDays * day,
+	} {
+		text := leadText(lead)
+		fields := strings.Fields(text)
+		got, read, err := parseLead(fields)
+		if err != nil {
+			t.Errorf("parseLead(leadText(%s) = %q): %v", lead, text, err)
+			continue
+		}
+		if got != lead || read != len(fields) {
+			t.Errorf("parseLead(leadText(%s) = %q) = %s, %d слов из %d", lead, text, got, read, len(fields))
+		}
+	}
+}
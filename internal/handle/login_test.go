@@ -0,0 +1,225 @@
+package handle
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"birthdayGreetings/internal/clock"
+	"birthdayGreetings/internal/db"
+	"birthdayGreetings/internal/dialog"
+	"birthdayGreetings/internal/otp"
+
+	"github.com/gofrs/uuid"
+	tb "gopkg.in/telebot.v3"
+)
+
+// fakeContext сообщение от пользователя telegram: запоминает ответы бота
+type fakeContext struct {
+	tb.Context
+	sender *tb.User
+	sent   []string
+}
+
+func (c *fakeContext) Sender() *tb.User {
+	return c.sender
+}
+
+func (c *fakeContext) Send(what interface{}, opts ...interface{}) error {
+	c.sent = append(c.sent, fmt.Sprint(what))
+	return nil
+}
+
+// last последний ответ бота
+func (c *fakeContext) last() string {
+	if len(c.sent) == 0 {
+		return ""
+	}
+	return c.sent[len(c.sent)-1]
+}
+
+// newLoginHandle сотрудник без telegram и Handle поверх MemoryDB с ним
+func newLoginHandle(t *testing.T) (*Handle, *db.MemoryDB, *clock.Fake, db.Employee) {
+	t.Setenv("SECRET", "secret")
+	employee := db.Employee{
+		ID:        uuid.Must(uuid.NewV4()),
+		LastName:  "Иванов",
+		FirstName: "Иван",
+		Email:     "ivanov@example.com",
+		Status:    db.StatusActive,
+		Role:      db.RoleEmployee,
+	}
+	clk := clock.NewFake(time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC))
+	store := db.NewMemoryDB(db.Snapshot{Employees: []db.Employee{employee}}, clk)
+
+	return NewHandle(store, clk), store, clk, employee
+}
+
+// awaitCode начнет вход сотрудника с telegramID так, будто код code только что отправлен на email
+func awaitCode(t *testing.T, h *Handle, store *db.MemoryDB, employee db.Employee, telegramID int64, code string) dialog.Conversation {
+	t.Helper()
+	if err := store.SaveLoginCode(employee.ID, otp.Code{Hash: otp.Hash(code), CreatedAt: h.clock.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	conversation := dialog.Conversation{TelegramID: telegramID, State: dialog.AwaitEmail}
+	if err := h.dialog.Start(conversation); err != nil {
+		t.Fatal(err)
+	}
+	conversation.EmployeeID = employee.ID
+	if err := h.dialog.Transition(conversation, dialog.AwaitPassword); err != nil {
+		t.Fatal(err)
+	}
+
+	conversation, err := h.dialog.Current(telegramID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conversation
+}
+
+func TestWaitPassword(t *testing.T) {
+	const telegramID = 42
+	tests := []struct {
+		name   string
+		before func(h *Handle, clk *clock.Fake, c *fakeContext, conversation dialog.Conversation)
+		input  string
+		reply  string
+		state  dialog.State // состояние диалога после ответа
+		code   bool         // код входа еще действует
+		bound  bool         // telegram привязан, сессия начата
+	}{
+		{
+			name:  "верный код",
+			input: " 123456 ",
+			reply: "Аутентификация прошла успешно!",
+			state: dialog.Idle,
+			bound: true,
+		},
+		{
+			name:  "неверный код",
+			input: "654321",
+			reply: "Неверный код",
+			state: dialog.AwaitPassword,
+			code:  true,
+		},
+		{
+			name: "код истек",
+			before: func(h *Handle, clk *clock.Fake, c *fakeContext, conversation dialog.Conversation) {
+				clk.Advance(h.login.codeTTL)
+			},
+			input: "123456",
+			reply: "Срок действия кода истек",
+			state: dialog.Idle,
+		},
+		{
+			// исчерпанные попытки сжигают код: верный код после них уже не принимается
+			name: "попытки исчерпаны",
+			before: func(h *Handle, clk *clock.Fake, c *fakeContext, conversation dialog.Conversation) {
+				for i := 0; i < h.login.maxAttempts; i++ {
+					h.waitPassword(conversation, c, "000000")
+				}
+			},
+			input: "123456",
+			reply: "Слишком много попыток ввода кода",
+			state: dialog.Idle,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, store, clk, employee := newLoginHandle(t)
+			conversation := awaitCode(t, h, store, employee, telegramID, "123456")
+			c := &fakeContext{sender: &tb.User{ID: telegramID}}
+			if tt.before != nil {
+				tt.before(h, clk, c, conversation)
+			}
+
+			if err := h.waitPassword(conversation, c, tt.input); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(c.last(), tt.reply) {
+				t.Errorf("ответ %q, ожидался %q", c.last(), tt.reply)
+			}
+			if current, _ := h.dialog.Current(telegramID); current.State != tt.state {
+				t.Errorf("диалог в состоянии %q, ожидалось %q", current.State, tt.state)
+			}
+			if _, err := store.GetLoginCode(employee.ID); (err == nil) != tt.code {
+				t.Errorf("код действует: %v, ожидалось %v", err == nil, tt.code)
+			}
+			got, err := store.GetEmployee(db.Employee{ID: employee.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bound := got.TelegramID == telegramID && got.Token != ""; bound != tt.bound {
+				t.Errorf("telegram %d, токен %q: ожидалась привязка %v", got.TelegramID, got.Token, tt.bound)
+			}
+		})
+	}
+}
+
+// Учетная запись, привязанная к другому telegram, перепривязывается только после согласия
+func TestWaitPasswordRebind(t *testing.T) {
+	h, store, _, employee := newLoginHandle(t)
+	if _, err := store.BindTelegram(employee.ID, 7, db.BindLogin, h.clock.Now()); err != nil {
+		t.Fatal(err)
+	}
+	conversation := awaitCode(t, h, store, employee, 42, "123456")
+	c := &fakeContext{sender: &tb.User{ID: 42}}
+
+	if err := h.waitPassword(conversation, c, "123456"); err != nil {
+		t.Fatal(err)
+	}
+	current, err := h.dialog.Current(42)
+	if err != nil || current.State != dialog.AwaitRebind {
+		t.Fatalf("после кода: %q, %v", current.State, err)
+	}
+	if err = h.waitRebind(current, c, "да"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.GetEmployee(db.Employee{ID: employee.ID}); got.TelegramID != 42 {
+		t.Errorf("telegram %d, ожидался 42", got.TelegramID)
+	}
+	// прежний аккаунт предупрежден о перепривязке
+	messages, err := store.ClaimOutbox(h.clock.Now(), h.clock.Now().Add(time.Minute), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].ChatID != 7 {
+		t.Errorf("уведомления: %+v", messages)
+	}
+}
+
+// Запросы кода ограничены maxSends за окно отдельно для каждого ключа
+func TestAllowLoginSends(t *testing.T) {
+	h, _, clk, employee := newLoginHandle(t)
+	c := &fakeContext{sender: &tb.User{ID: 42}}
+	key := employeeKey(employee.ID)
+
+	for i := 0; i < h.login.maxSends; i++ {
+		if ok, err := h.allowLogin(c, db.LoginSend, key); !ok || err != nil {
+			t.Fatalf("запрос %d: %v, %v", i+1, ok, err)
+		}
+	}
+	if ok, _ := h.allowLogin(c, db.LoginSend, key); ok {
+		t.Fatal("запрос сверх лимита разрешен")
+	}
+	wantReply := fmt.Sprintf("Слишком много запросов кода. Попробуйте снова через %d мин.", int(h.login.window.Minutes()))
+	if !strings.HasPrefix(c.last(), wantReply) {
+		t.Errorf("ответ %q, ожидался %q", c.last(), wantReply)
+	}
+
+	// другой ключ и другое действие считаются отдельно
+	if ok, _ := h.allowLogin(c, db.LoginSend, telegramKey(42)); !ok {
+		t.Error("лимит сотрудника ограничил telegram ID")
+	}
+	if ok, _ := h.allowLogin(c, db.LoginAttempt, key); !ok {
+		t.Error("лимит запросов ограничил ввод кода")
+	}
+
+	clk.Advance(h.login.window)
+	if ok, err := h.allowLogin(c, db.LoginSend, key); !ok || err != nil {
+		t.Errorf("после окна: %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,354 @@
+package handle
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	td "birthdayGreetings/internal/TDlib"
+	"birthdayGreetings/internal/calendar"
+	"birthdayGreetings/internal/db"
+)
+
+// Scheduler
+func (h *Handle) Scheduler() {
+	var t td.TDlib
+
+	groupID, err := strconv.ParseInt(os.Getenv("TELEGRAM_GROUP"), 10, 64)
+	if err != nil {
+		log.Fatal("Невалидный TELEGRAM_GROUP")
+		return
+	}
+
+	apiID, err := strconv.Atoi(os.Getenv("API_ID"))
+	if err != nil {
+		panic("Невалидный API_ID")
+	}
+
+	t, err = td.NewTDlib(int32(apiID), os.Getenv("API_HASH"))
+	if err != nil {
+		panic(err.Error())
+	}
+	defer t.TDlibStop()
+
+	// Проверяем, существует ли группа
+	_, err = t.GetGroup(groupID)
+	if err != nil {
+		// Создаем новую группу
+		groupID, err = t.CreateNewGroup(os.Getenv("NAME_TELEGRAM_GROUP"))
+		if err != nil {
+			panic(err)
+		}
+
+		_, err = t.GetGroup(groupID)
+		if err != nil {
+			panic(err)
+		}
+
+		// заменит groupID
+		changeEnv(groupID)
+	}
+
+	if err = h.SchedulerNotifications(&t, groupID); err != nil {
+		log.Println(err)
+	}
+
+	for {
+		// Получаем текущее время
+		now := h.clock.Now()
+		// Определяем время следующего часа в 00 минут
+		nextHour := time.Date(now.Year(), now.Month(), now.Day(), now.Hour()+1, 0, 0, 0, now.Location())
+		// Вычисляем, сколько времени осталось до следующего часа в 00 минут
+		waitTime := nextHour.Sub(now)
+		// Ждём до следующего часа в 00 минут
+		h.clock.Sleep(waitTime)
+		fmt.Println("Scheduler выполняет проверку: ", h.clock.Now().Format("2006-01-02 15:04:05"))
+
+		if err = h.SchedulerNotifications(&t, groupID); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// changeEnv заменит "TELEGRAM_GROUP=" в .env на актуальные данные
+func changeEnv(groupID int64) {
+	groupIDstr := strconv.Itoa(int(groupID))
+
+	oldID := os.Getenv("TELEGRAM_GROUP")
+
+	// Путь к файлу .env
+	envFilePath := "../configs/.env"
+
+	// Строка для поиска
+	searchString := fmt.Sprintf(`TELEGRAM_GROUP=%s`, oldID)
+
+	// Новая строка для замены
+	replaceString := fmt.Sprintf(`TELEGRAM_GROUP=%s`, groupIDstr)
+
+	// Открытие файла для чтения
+	file, err := os.Open(envFilePath)
+	if err != nil {
+		fmt.Println("Ошибка открытия .env:", err)
+		return
+	}
+	defer file.Close()
+
+	// Создание нового файла для записи
+	newFile, err := os.Create(envFilePath + ".tmp")
+	if err != nil {
+		fmt.Println("Ошибка создания нового файла .env:", err)
+		return
+	}
+	defer newFile.Close()
+
+	// Чтение содержимого файла
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Проверка, содержит ли строка искомое значение
+		if strings.Contains(line, searchString) {
+			// Замена искомой строки на новую
+			line = strings.ReplaceAll(line, searchString, replaceString)
+		}
+		// Запись обновленной строки в новый файл
+		_, err = newFile.WriteString(line + "\n")
+		if err != nil {
+			fmt.Println("Ошибка записи в новый файл .env:", err)
+			return
+		}
+	}
+
+	// Переименование временного файла в оригинальный
+	err = os.Rename(envFilePath+".tmp", envFilePath)
+	if err != nil {
+		fmt.Println("Ошибка переименования файла .env:", err)
+		return
+	}
+}
+
+// Group группа telegram, в которую добавляются подписчики (реализуется *td.TDlib)
+type Group interface {
+	AddUserToGroup(groupID, userID int64) error
+	RemoveUserFromGroup(groupID, userID int64) error
+}
+
+// SchedulerNotifications добавляет подписчиков в группу, убирает из нее деактивированных
+// и ставит в outbox наступившие оповещания. Отправкой из outbox занимается Dispatcher
+func (h *Handle) SchedulerNotifications(g Group, groupID int64) error {
+	// Если пользователь подписан на кого-то и не состоит в группе - добавляем его в группу
+	employees, err := h.db.GetUngroupedSubscribers()
+	if err != nil {
+		return err
+	}
+	for _, employee := range employees {
+		if err = g.AddUserToGroup(groupID, employee.TelegramID); err != nil {
+			log.Println(err)
+		} else if err = h.db.PatchEmployee(employee.ID, db.EmployeeUpdate{}.SetInTgGroup(true)); err != nil {
+			log.Println(err)
+		}
+	}
+
+	// Ушедших сотрудников (деактивированы вручную, импортом или синхронизацией с HR) - из группы
+	leavers, err := h.db.GetGroupedTerminated()
+	if err != nil {
+		return err
+	}
+	for _, employee := range leavers {
+		if err = g.RemoveUserFromGroup(groupID, employee.TelegramID); err != nil {
+			log.Println(err)
+		} else if err = h.db.PatchEmployee(employee.ID, db.EmployeeUpdate{}.SetInTgGroup(false)); err != nil {
+			log.Println(err)
+		}
+	}
+
+	_, _, err = h.enqueueDue()
+
+	return err
+}
+
+// enqueueDue достает из db оповещания, которые наступили с прошлой проверки (но не старше
+// h.staleness), ставит их в outbox вместе с дайджестами, запоминает момент проверки
+// и возвращает поставленные
+func (h *Handle) enqueueDue() ([]db.Notification, []QueuedDigest, error) {
+	// плановый и ручной (/runscheduler) запуски не должны пересекаться
+	h.schedulerMu.Lock()
+	defer h.schedulerMu.Unlock()
+
+	now := h.clock.Now()
+	processedUntil, err := h.db.GetWatermark()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Всё, что должно было прийти раньше staleFrom, уже не отправляем
+	staleFrom := now.Add(-h.staleness)
+	from := processedUntil
+	if from.Before(staleFrom) {
+		from = staleFrom
+	}
+
+	notifications, err := h.db.GetDueNotifications(from, now, h.leapPolicy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var enqueued []db.Notification
+	for _, n := range notifications {
+		var advance *db.Subscription
+		finished := false
+		if n.Kind == db.KindReminder {
+			// Переносим напоминание на ближайшее следующее событие
+			s := n.Subscription()
+			if n.Event == db.EventCustom {
+				var ok bool
+				s.NextFireAt, ok = h.nextEventReminder(n.Custom, n.LeadTime, now, n.Recipient.Location())
+				finished = !ok
+			} else {
+				s.NextFireAt, _ = h.nextReminder(n.Target, n.Event, n.LeadTime, now, n.Recipient.Location())
+			}
+			if !finished {
+				advance = &s
+			}
+		}
+
+		if n.FireAt.Before(staleFrom) {
+			log.Printf("Пропущено устаревшее оповещание (%s) для %s", n.FireAt, n.Recipient.ID)
+			switch {
+			case advance == nil:
+			case advance.Event == db.EventCustom:
+				err = h.db.SetEventNextFireAt(*advance)
+			default:
+				err = h.db.SetNextFireAt(*advance)
+			}
+		} else {
+			err = h.db.Enqueue(db.OutboxMessage{
+				DedupKey: dedupKey(n),
+				ChatID:   n.Recipient.TelegramID,
+				Text:     notificationMessage(n),
+			}, advance)
+			enqueued = append(enqueued, n)
+		}
+		if err == nil && finished {
+			// однократное событие прошло, это оповещание о нем больше не нужно
+			err = h.db.RemoveEventSubscriptionLead(n.Recipient.ID, n.Custom.ID, n.LeadTime)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	digests, err := h.enqueueDigests(now, staleFrom)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return enqueued, digests, h.db.SetWatermark(now)
+}
+
+// Simulate прогоняет планировщик от текущего времени часов h.clock до to с шагом step
+// и передает в report каждое поздравление и напоминание, а в reportDigest - каждый дайджест,
+// которые были бы отправлены. Группа telegram и отправка не используются; вызывать
+// с clock.Fake поверх копии данных
+func (h *Handle) Simulate(to time.Time, step time.Duration, report func(n db.Notification),
+	reportDigest func(d QueuedDigest)) error {
+	// Начинаем с чистого листа: пропущенное до начала симуляции не считается
+	if err := h.db.SetWatermark(h.clock.Now()); err != nil {
+		return err
+	}
+
+	for h.clock.Sleep(step); !h.clock.Now().After(to); h.clock.Sleep(step) {
+		notifications, digests, err := h.enqueueDue()
+		if err != nil {
+			return err
+		}
+		for _, n := range notifications {
+			report(n)
+		}
+		for _, d := range digests {
+			reportDigest(d)
+		}
+	}
+
+	return nil
+}
+
+// notificationMessage текст поздравления или напоминания
+func notificationMessage(n db.Notification) string {
+	e := n.Target
+	if n.Event == db.EventCustom {
+		return customEventMessage(n)
+	}
+	if n.Event == db.EventAnniversary {
+		// Годовщина наступает через LeadTime после напоминания (у поздравления он нулевой)
+		years := calendar.After(n.FireAt.In(n.Recipient.Location()), n.LeadTime).Year() - e.HireDate.Time.Year()
+		if n.Kind == db.KindGreeting {
+			return fmt.Sprintf("Поздравляю с годовщиной работы в компании: сегодня %s с нами! "+
+				"Спасибо за твой труд, пусть впереди будет еще больше интересных задач и побед!", yearsText(years))
+		}
+
+		return fmt.Sprintf("Самое время напомнить!\n\n %s %s %s - %s работы в компании (с %s).\n\n Не забудьте поздравить!",
+			e.FirstName, e.Patronymic, e.LastName, yearsText(years), e.HireDate.Time.Format("02.01.2006"))
+	}
+
+	if n.Kind == db.KindGreeting {
+		return "Поздравляю тебя с Днём рождения! Желаю тебе исполнения всех твоих " +
+			"мечтаний и достижения поставленных целей. Пусть успех сопровождает " +
+			"тебя всегда и во всём, а здоровье будет крепким, как алмаз!"
+	}
+
+	return fmt.Sprintf("Самое время напомнить!\n\n %s %s %s - День рождения %v.\n\n Не забудьте поздравить!",
+		e.FirstName, e.Patronymic, e.LastName, e.BirthDate)
+}
+
+// customEventMessage текст оповещания о произвольном событии сотрудника или компании
+func customEventMessage(n db.Notification) string {
+	e, title := n.Target, n.Custom.Title
+	switch {
+	case n.Kind == db.KindGreeting && n.Custom.EmployeeID.Valid:
+		return fmt.Sprintf("Поздравляю: %s! Пусть этот день будет радостным, а впереди ждет только хорошее!", title)
+	case n.Kind == db.KindGreeting:
+		return fmt.Sprintf("Сегодня %s! Поздравляю с праздником!", title)
+	}
+
+	day := calendar.After(n.FireAt.In(n.Recipient.Location()), n.LeadTime).Format("02.01.2006")
+	if n.Custom.EmployeeID.Valid {
+		return fmt.Sprintf("Самое время напомнить!\n\n %s %s %s - %s, %s.\n\n Не забудьте поздравить!",
+			e.FirstName, e.Patronymic, e.LastName, title, day)
+	}
+
+	return fmt.Sprintf("Самое время напомнить!\n\n %s - %s.", day, title)
+}
+
+// yearsText число лет со склонением: 1 год, 2 года, 5 лет
+func yearsText(years int) string {
+	switch {
+	case years%10 == 1 && years%100 != 11:
+		return fmt.Sprintf("%d год", years)
+	case years%10 >= 2 && years%10 <= 4 && (years%100 < 12 || years%100 > 14):
+		return fmt.Sprintf("%d года", years)
+	default:
+		return fmt.Sprintf("%d лет", years)
+	}
+}
+
+// nextReminder ближайшее позже after время напоминания за lead до события сотрудника target
+// в часовом поясе loc; годовщины считаются с первой. false, если дата события неизвестна
+func (h *Handle) nextReminder(target db.Employee, event db.EventKind, lead time.Duration, after time.Time,
+	loc *time.Location) (time.Time, bool) {
+	date, ok := target.EventDate(event)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	for {
+		next := calendar.NextReminder(date, lead, after, loc, h.leapPolicy)
+		if event != db.EventAnniversary || calendar.After(next.In(loc), lead).Year() > date.Year() {
+			return next, true
+		}
+		after = next
+	}
+}
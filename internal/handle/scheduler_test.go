@@ -0,0 +1,184 @@
+package handle
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"birthdayGreetings/internal/calendar"
+	"birthdayGreetings/internal/clock"
+	"birthdayGreetings/internal/db"
+
+	"github.com/gofrs/uuid"
+	tb "gopkg.in/telebot.v3"
+)
+
+// schedulerStart начало симуляции
+var schedulerStart = time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+// schedulerEmployee сотрудник в статусе status с Днем рождения в день day
+func schedulerEmployee(name string, telegramID int64, day time.Time, status db.Status) db.Employee {
+	return db.Employee{
+		ID:         uuid.Must(uuid.NewV4()),
+		TelegramID: telegramID,
+		LastName:   name,
+		FirstName:  name,
+		BirthDate:  time.Date(1990, day.Month(), day.Day(), 0, 0, 0, 0, time.UTC),
+		Status:     status,
+	}
+}
+
+// schedulerSubscription подписка на День рождения target с ближайшим после schedulerStart оповещанием
+func schedulerSubscription(subscriber, target db.Employee, lead time.Duration) db.Subscription {
+	return db.Subscription{
+		SubscriberID: subscriber.ID,
+		TargetID:     target.ID,
+		Event:        db.EventBirthday,
+		LeadTime:     lead,
+		NextFireAt:   calendar.NextReminder(target.BirthDate, lead, schedulerStart, subscriber.Location(), calendar.LeapFeb28),
+	}
+}
+
+// Планировщик поверх MemoryDB должен вести себя как поверх Postgres: окно поздравлений,
+// фильтр по статусам, перенос напоминаний и пропуск устаревших
+func TestSimulate(t *testing.T) {
+	day := 24 * time.Hour
+	march := func(d int) time.Time { return time.Date(2025, time.March, d, 0, 0, 0, 0, time.UTC) }
+
+	subscriber := schedulerEmployee("Подписчик", 1, march(7), db.StatusActive)
+	colleague := schedulerEmployee("Коллега", 2, march(5), db.StatusActive)
+	neighbour := schedulerEmployee("Сосед", 3, march(6), db.StatusActive)
+	onLeave := schedulerEmployee("Отпуск", 4, march(8), db.StatusOnLeave)
+	terminated := schedulerEmployee("Уволен", 5, march(5), db.StatusTerminated)
+	resting := schedulerEmployee("Отдыхающий", 6, march(20), db.StatusOnLeave)
+	missed := schedulerEmployee("Пропущенный", 7, time.Date(2025, time.February, 26, 0, 0, 0, 0, time.UTC), db.StatusActive)
+
+	stale := schedulerSubscription(subscriber, missed, 0)
+	stale.NextFireAt = missed.BirthDate.AddDate(35, 0, 0) // 26.02.2025, до начала симуляции
+	store := db.NewMemoryDB(db.Snapshot{
+		Employees: []db.Employee{subscriber, colleague, neighbour, onLeave, terminated, resting, missed},
+		Subscriptions: []db.Subscription{
+			schedulerSubscription(subscriber, colleague, 0),
+			schedulerSubscription(subscriber, neighbour, day),
+			schedulerSubscription(subscriber, onLeave, 0),    // в отпуске, но в списке - напоминаем
+			schedulerSubscription(subscriber, terminated, 0), // уволен - не напоминаем
+			schedulerSubscription(resting, colleague, 0),     // получатель в отпуске - не напоминаем
+			stale,
+		},
+	}, clock.Real{})
+	h := NewHandle(store, clock.NewFake(schedulerStart))
+	h.leapPolicy = calendar.LeapFeb28
+
+	var got []string
+	err := h.Simulate(march(10), time.Hour, func(n db.Notification) {
+		got = append(got, fmt.Sprintf("%s %s %s->%s", n.FireAt.Format("02.01 15:04"), n.Kind, n.Recipient.LastName, n.Target.LastName))
+	}, func(QueuedDigest) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"05.03 00:00 reminder Подписчик->Коллега",
+		"05.03 00:00 reminder Подписчик->Сосед",
+		"07.03 00:00 greeting Подписчик->Подписчик",
+		"08.03 00:00 reminder Подписчик->Отпуск",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("оповещания:\n got %q\nwant %q", got, want)
+	}
+
+	// сработавшие и устаревшие напоминания перенесены на следующий год, остальные не тронуты
+	subscriptions, err := store.GetSubscriptions(subscriber.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range subscriptions {
+		wantYear := 2026
+		if s.TargetID == terminated.ID {
+			wantYear = 2025
+		}
+		if s.NextFireAt.Year() != wantYear {
+			t.Errorf("напоминание о %s за %s перенесено на %s, ожидался %d год", s.TargetID, s.LeadTime, s.NextFireAt, wantYear)
+		}
+	}
+
+	// повторный прогон за то же время не повторяет напоминаний
+	h.clock.(*clock.Fake).Set(schedulerStart)
+	if err = h.Simulate(march(10), time.Hour, func(n db.Notification) {
+		if n.Kind == db.KindReminder {
+			t.Errorf("повторное напоминание %s", n.FireAt)
+		}
+	}, func(QueuedDigest) {}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recordingSender запоминает, в какие чаты что отправлено
+type recordingSender struct {
+	sent []string
+}
+
+func (s *recordingSender) Send(to tb.Recipient, what interface{}, opts ...interface{}) (*tb.Message, error) {
+	s.sent = append(s.sent, to.Recipient())
+	return &tb.Message{}, nil
+}
+
+// Сообщение, забранное диспетчером, зарезервировано за ним на dispatchLease
+// и не отправляется второй раз, пока резерв не истек
+func TestClaimOutboxLease(t *testing.T) {
+	clk := clock.NewFake(schedulerStart)
+	store := db.NewMemoryDB(db.Snapshot{}, clk)
+	h := NewHandle(store, clk)
+
+	for i, chat := range []int64{10, 20, 0} {
+		msg := db.OutboxMessage{DedupKey: fmt.Sprint("lease:", i), ChatID: chat, Text: "С Днем рождения!"}
+		if err := store.Enqueue(msg, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Enqueue(db.OutboxMessage{DedupKey: "lease:0", ChatID: 10}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	now := clk.Now()
+	claimed, err := store.ClaimOutbox(now, now.Add(dispatchLease), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 2 {
+		t.Fatalf("забрано %d сообщений, ожидалось 2 (limit)", len(claimed))
+	}
+	again, err := store.ClaimOutbox(now.Add(time.Minute), now.Add(time.Minute+dispatchLease), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 1 || again[0].ID == claimed[0].ID || again[0].ID == claimed[1].ID {
+		t.Fatalf("во время резерва забрано %v, ожидалось только третье сообщение", again)
+	}
+
+	// после резерва все сообщения снова доступны: дубль по DedupKey в очередь не попал
+	clk.Set(now.Add(time.Minute + dispatchLease))
+	sender := &recordingSender{}
+	if err = h.DispatchOutbox(sender); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(sender.sent) != "[10 20]" {
+		t.Errorf("отправлено в чаты %v, ожидалось [10 20]", sender.sent)
+	}
+
+	// доставленные и безнадежные (без telegram ID) больше не забираются
+	clk.Advance(backoffMax)
+	if err = h.DispatchOutbox(sender); err != nil {
+		t.Fatal(err)
+	}
+	if len(sender.sent) != 2 {
+		t.Errorf("повторная отправка: %v", sender.sent)
+	}
+	dead, err := store.GetDeadLetters(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].ChatID != 0 {
+		t.Errorf("в dead %v, ожидалось сообщение без telegram ID", dead)
+	}
+}
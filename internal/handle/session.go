@@ -0,0 +1,106 @@
+package handle
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"birthdayGreetings/internal/db"
+
+	"github.com/gofrs/uuid"
+	tb "gopkg.in/telebot.v3"
+)
+
+// sessionConfig сколько живет сессия и за сколько до конца она продлевается новым токеном
+type sessionConfig struct {
+	ttl     time.Duration
+	refresh time.Duration
+}
+
+// startSession начнет новую сессию сотрудника в telegram, отозвав прежние, и сохранит ее токен
+func (h *Handle) startSession(employeeID uuid.UUID, telegramID int64) error {
+	now := h.clock.Now()
+	if err := h.db.RevokeSessions(employeeID, now); err != nil {
+		return err
+	}
+
+	return h.issueSession(employeeID, telegramID, now)
+}
+
+// issueSession создаст сессию и сохранит ее токен сотруднику
+func (h *Handle) issueSession(employeeID uuid.UUID, telegramID int64, now time.Time) error {
+	session := db.Session{
+		ID:         uuid.Must(uuid.NewV4()),
+		EmployeeID: employeeID,
+		TelegramID: telegramID,
+		IssuedAt:   now,
+		ExpiresAt:  now.Add(h.session.ttl),
+	}
+
+	token, err := db.GenerateJWTToken(session)
+	if err != nil {
+		return err
+	}
+	if err = h.db.CreateSession(session); err != nil {
+		return err
+	}
+
+	return h.db.PatchEmployee(employeeID, db.EmployeeUpdate{}.SetToken(token))
+}
+
+// checkSession сверит токен сотрудника с ним самим, с отправителем и с сессией;
+// если сессия скоро истечет - продлит ее новым токеном
+func (h *Handle) checkSession(employee db.Employee, telegramID int64) error {
+	// Верифицирует и распарсит токен
+	now := h.clock.Now()
+	claims, err := db.JwtParse(employee.Token, now)
+	if err != nil {
+		return err
+	}
+	if claims.EmployeeID != employee.ID || claims.TelegramID != telegramID || employee.TelegramID != telegramID {
+		return errors.New("токен выдан другому пользователю")
+	}
+
+	id, err := claims.SessionID()
+	if err != nil {
+		return errors.New("токен недействителен")
+	}
+	session, err := h.db.GetSession(id)
+	if err != nil {
+		return err
+	}
+
+	if !session.Active(now) {
+		return errors.New("сессия завершена")
+	}
+
+	if session.ExpiresAt.Sub(now) < h.session.refresh {
+		// старый токен отзываем только после того, как сохранен новый
+		if err = h.issueSession(employee.ID, telegramID, now); err != nil {
+			log.Println("Ошибка продления сессии:", err)
+		} else if err = h.db.RevokeSession(session.ID, now); err != nil {
+			log.Println("Ошибка отзыва сессии:", err)
+		}
+	}
+
+	return nil
+}
+
+// Logout завершает сессию: /logout
+func (h *Handle) Logout(c tb.Context) error {
+	employee, err := h.authMiddleware(c, db.RoleEmployee)
+	if err != nil {
+		return c.Send("Вы и так не вошли\n/login - пройти аутентификацию")
+	}
+
+	if err = h.db.RevokeSessions(employee.ID, h.clock.Now()); err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+	if err = h.db.PatchEmployee(employee.ID, db.EmployeeUpdate{}.SetToken("")); err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+
+	return c.Send("Вы вышли из учетной записи\n/login - войти снова")
+}
@@ -0,0 +1,149 @@
+package handle
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"birthdayGreetings/internal/db"
+
+	"github.com/gofrs/uuid"
+	tb "gopkg.in/telebot.v3"
+)
+
+// loggedIn привяжет сотрудника к telegramID, начнет сессию и вернет ее токен
+func loggedIn(t *testing.T, h *Handle, store *db.MemoryDB, employee db.Employee, telegramID int64) string {
+	t.Helper()
+	if _, err := store.BindTelegram(employee.ID, telegramID, db.BindLogin, h.clock.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.startSession(employee.ID, telegramID); err != nil {
+		t.Fatal(err)
+	}
+
+	return currentToken(t, store, employee)
+}
+
+func currentToken(t *testing.T, store *db.MemoryDB, employee db.Employee) string {
+	t.Helper()
+	e, err := store.GetEmployee(db.Employee{ID: employee.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e.Token
+}
+
+// useToken вернет сотруднику прежний токен (например, перехваченный)
+func useToken(t *testing.T, store *db.MemoryDB, employee db.Employee, token string) {
+	t.Helper()
+	if err := store.PatchEmployee(employee.ID, db.EmployeeUpdate{}.SetToken(token)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Сессия продлевается новым токеном за h.session.refresh до конца, прежний токен после этого не действует
+func TestSessionRefresh(t *testing.T) {
+	h, store, clk, employee := newLoginHandle(t)
+	c := &fakeContext{sender: &tb.User{ID: 42}}
+	first := loggedIn(t, h, store, employee, 42)
+
+	// до окна продления токен не меняется
+	clk.Advance(h.session.ttl - h.session.refresh - time.Minute)
+	if _, err := h.authMiddleware(c, db.RoleEmployee); err != nil {
+		t.Fatal(err)
+	}
+	if currentToken(t, store, employee) != first {
+		t.Fatal("токен продлен раньше окна продления")
+	}
+
+	clk.Advance(2 * time.Minute)
+	if _, err := h.authMiddleware(c, db.RoleEmployee); err != nil {
+		t.Fatal(err)
+	}
+	second := currentToken(t, store, employee)
+	if second == first {
+		t.Fatal("токен не продлен")
+	}
+
+	// новый токен живет полный ttl с момента продления
+	clk.Advance(h.session.ttl - h.session.refresh)
+	if _, err := h.authMiddleware(c, db.RoleEmployee); err != nil {
+		t.Errorf("продленный токен: %v", err)
+	}
+
+	useToken(t, store, employee, first)
+	if _, err := h.authMiddleware(c, db.RoleEmployee); err == nil {
+		t.Error("прежний токен действует после продления")
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	h, store, clk, employee := newLoginHandle(t)
+	c := &fakeContext{sender: &tb.User{ID: 42}}
+	loggedIn(t, h, store, employee, 42)
+
+	clk.Advance(h.session.ttl)
+	if _, err := h.authMiddleware(c, db.RoleEmployee); err == nil {
+		t.Error("истекший токен действует")
+	}
+}
+
+// Токен действует только для сотрудника и telegram, которым выдан
+func TestSessionBinding(t *testing.T) {
+	h, store, _, employee := newLoginHandle(t)
+	token := loggedIn(t, h, store, employee, 42)
+
+	e, err := store.GetEmployee(db.Employee{ID: employee.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = h.checkSession(e, 99); err == nil {
+		t.Error("токен принят от другого telegram")
+	}
+
+	// токен сотрудника, подставленный другому, не действует
+	other := db.Employee{ID: uuid.Must(uuid.NewV4()), TelegramID: 42, Token: token}
+	if err = h.checkSession(other, 42); err == nil {
+		t.Error("токен принят для другого сотрудника")
+	}
+
+	if err = h.checkSession(db.Employee{ID: e.ID, TelegramID: 42, Token: token + "x"}, 42); err == nil {
+		t.Error("принят токен с неверной подписью")
+	}
+}
+
+// Новый вход и /logout отзывают прежние сессии
+func TestSessionRevoke(t *testing.T) {
+	h, store, _, employee := newLoginHandle(t)
+	c := &fakeContext{sender: &tb.User{ID: 42}}
+	first := loggedIn(t, h, store, employee, 42)
+	second := loggedIn(t, h, store, employee, 42)
+
+	useToken(t, store, employee, first)
+	if _, err := h.authMiddleware(c, db.RoleEmployee); err == nil {
+		t.Error("токен прежнего входа действует")
+	}
+
+	useToken(t, store, employee, second)
+	if err := h.Logout(c); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(c.last(), "Вы вышли") {
+		t.Errorf("ответ на /logout: %q", c.last())
+	}
+	if token := currentToken(t, store, employee); token != "" {
+		t.Errorf("после /logout токен не стерт: %q", token)
+	}
+
+	useToken(t, store, employee, second)
+	if _, err := h.authMiddleware(c, db.RoleEmployee); err == nil {
+		t.Error("токен действует после /logout")
+	}
+	if err := h.Logout(c); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(c.last(), "Вы и так не вошли") {
+		t.Errorf("повторный /logout: %q", c.last())
+	}
+}
@@ -0,0 +1,86 @@
+package handle
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"birthdayGreetings/internal/roster"
+)
+
+// syncReportLimit сколько строк каждого вида изменений выводить в отчете синхронизации
+const syncReportLimit = 1000
+
+// ErrSyncAborted выгрузка не применена: в ней ошибки или она деактивировала бы слишком многих
+var ErrSyncAborted = errors.New("синхронизация прервана, база не изменена")
+
+// SyncReport итог синхронизации с выгрузкой HR-системы
+type SyncReport struct {
+	Plan     roster.Plan
+	Problems []error // ошибки в записях выгрузки
+	Applied  bool    // изменения записаны в базу
+}
+
+// SyncEmployees сверяет сотрудников с выгрузкой HR-системы (.csv, .xlsx или .json; ключ - табельный
+// номер, иначе email): добавляет новых, обновляет изменившихся, деактивирует ушедших и удаляет
+// их подписки (из группы telegram их уберет планировщик). Повторный запуск с той же выгрузкой
+// ничего не меняет. dryRun - только отчет; maxRemoved - какую долю активных сотрудников можно
+// деактивировать за один запуск (защита от обрезанной выгрузки)
+func (h *Handle) SyncEmployees(name string, data []byte, dryRun bool, maxRemoved float64) (SyncReport, error) {
+	var report SyncReport
+
+	rows, problems, err := roster.Parse(name, data, h.clock.Now())
+	if err != nil {
+		return report, err
+	}
+	current, err := h.db.GetEmployees()
+	if err != nil {
+		return report, err
+	}
+	report.Plan, report.Problems = roster.Diff(current, rows)
+	if report.Problems = append(problems, report.Problems...); len(report.Problems) > 0 {
+		return report, fmt.Errorf("%w: ошибок в выгрузке %d", ErrSyncAborted, len(report.Problems))
+	}
+
+	active := 0
+	for _, e := range current {
+		if e.Status.Listed() {
+			active++
+		}
+	}
+	if removed := len(report.Plan.Removed); removed > 0 && float64(removed) > maxRemoved*float64(active) {
+		return report, fmt.Errorf("%w: деактивировали бы %d из %d активных сотрудников", ErrSyncAborted, removed, active)
+	}
+
+	if dryRun || report.Plan.IsEmpty() {
+		return report, nil
+	}
+	if err = h.applyImport(report.Plan); err != nil {
+		return report, err
+	}
+	report.Applied = true
+
+	return report, nil
+}
+
+// String текст отчета синхронизации
+func (r SyncReport) String() string {
+	var b strings.Builder
+	switch {
+	case len(r.Problems) > 0:
+		fmt.Fprintf(&b, "Ошибки в выгрузке (%d):\n", len(r.Problems))
+		for _, p := range r.Problems {
+			b.WriteString(p.Error() + "\n")
+		}
+		return b.String()
+	case r.Plan.IsEmpty():
+		return "Выгрузка совпадает с базой, изменений нет\n"
+	case r.Applied:
+		b.WriteString("Изменения применены. ")
+	default:
+		b.WriteString("Изменения не применены. ")
+	}
+	b.WriteString(r.Plan.Preview(syncReportLimit))
+
+	return b.String()
+}
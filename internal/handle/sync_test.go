@@ -0,0 +1,115 @@
+package handle
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"birthdayGreetings/internal/clock"
+	"birthdayGreetings/internal/db"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestSyncEmployees(t *testing.T) {
+	employee := func(lastName, email, externalID string) db.Employee {
+		return db.Employee{
+			ID:         uuid.Must(uuid.NewV4()),
+			LastName:   lastName,
+			FirstName:  "Имя",
+			Email:      email,
+			BirthDate:  time.Date(1990, time.May, 12, 0, 0, 0, 0, time.UTC),
+			ExternalID: externalID,
+			Status:     db.StatusActive,
+		}
+	}
+	stayer := employee("Иванов", "ivanov@example.com", "T-1")
+	colleague := employee("Петров", "petrov@example.com", "T-2")
+	leaver := employee("Сидоров", "sidorov@example.com", "T-3")
+	another := employee("Козлов", "kozlov@example.com", "T-4")
+	store := db.NewMemoryDB(db.Snapshot{
+		Employees: []db.Employee{stayer, colleague, leaver, another},
+		Subscriptions: []db.Subscription{
+			{SubscriberID: stayer.ID, TargetID: leaver.ID, Event: db.EventBirthday},
+			{SubscriberID: leaver.ID, TargetID: stayer.ID, Event: db.EventBirthday},
+			{SubscriberID: stayer.ID, TargetID: colleague.ID, Event: db.EventBirthday},
+		},
+	}, clock.Real{})
+	h := NewHandle(store, clock.NewFake(time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)))
+
+	header := "фамилия,имя,email,дата рождения,табельный номер\n"
+	export := header +
+		"Иванов,Имя,ivanov@example.com,1990-05-12,T-1\n" +
+		"Петров,Имя,petrov@example.com,1990-05-12,T-2\n" +
+		"Новиков,Имя,novikov@example.com,1991-01-01,T-5\n"
+
+	unchanged := func(t *testing.T) {
+		t.Helper()
+		if e, _ := store.GetEmployee(db.Employee{ID: leaver.ID}); e.Status != db.StatusActive {
+			t.Errorf("база изменена: %s в статусе %s", e.LastName, e.Status)
+		}
+		if _, err := store.GetEmployee(db.Employee{Email: "novikov@example.com"}); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("база изменена: добавлен новый сотрудник (%v)", err)
+		}
+	}
+
+	t.Run("ошибки в выгрузке", func(t *testing.T) {
+		broken := export + "Орлов,,orlov@example.com,1990-01-01,T-6\n"
+		report, err := h.SyncEmployees("hris.csv", []byte(broken), false, 1)
+		if !errors.Is(err, ErrSyncAborted) || len(report.Problems) != 1 || report.Applied {
+			t.Errorf("err = %v, ошибок %d, applied %v", err, len(report.Problems), report.Applied)
+		}
+		unchanged(t)
+	})
+
+	t.Run("слишком многие ушли", func(t *testing.T) {
+		// уходят двое из четырех: больше четверти
+		report, err := h.SyncEmployees("hris.csv", []byte(export), false, 0.25)
+		if !errors.Is(err, ErrSyncAborted) || len(report.Plan.Removed) != 2 || report.Applied {
+			t.Errorf("err = %v, деактивировали бы %d, applied %v", err, len(report.Plan.Removed), report.Applied)
+		}
+		unchanged(t)
+	})
+
+	t.Run("только отчет", func(t *testing.T) {
+		report, err := h.SyncEmployees("hris.csv", []byte(export), true, 0.5)
+		if err != nil || report.Applied || len(report.Plan.Added) != 1 || len(report.Plan.Removed) != 2 {
+			t.Errorf("err = %v, applied %v, план %+v", err, report.Applied, report.Plan)
+		}
+		unchanged(t)
+	})
+
+	t.Run("применение", func(t *testing.T) {
+		report, err := h.SyncEmployees("hris.csv", []byte(export), false, 0.5)
+		if err != nil || !report.Applied {
+			t.Fatalf("err = %v, applied %v", err, report.Applied)
+		}
+		for _, id := range []uuid.UUID{leaver.ID, another.ID} {
+			if e, _ := store.GetEmployee(db.Employee{ID: id}); e.Status != db.StatusTerminated {
+				t.Errorf("%s в статусе %s, ожидался %s", e.LastName, e.Status, db.StatusTerminated)
+			}
+		}
+		if e, err := store.GetEmployee(db.Employee{Email: "novikov@example.com"}); err != nil || e.Status != db.StatusActive {
+			t.Errorf("новый сотрудник: %+v, %v", e, err)
+		}
+
+		// подписки ушедшего и на него удалены, остальные на месте
+		subscriptions, err := store.GetSubscriptions(stayer.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(subscriptions) != 1 || subscriptions[0].TargetID != colleague.ID {
+			t.Errorf("подписки %s: %+v", stayer.LastName, subscriptions)
+		}
+		if subscriptions, _ = store.GetSubscriptions(leaver.ID); len(subscriptions) != 0 {
+			t.Errorf("подписки ушедшего: %+v", subscriptions)
+		}
+	})
+
+	t.Run("повторный запуск", func(t *testing.T) {
+		report, err := h.SyncEmployees("hris.csv", []byte(export), false, 0)
+		if err != nil || report.Applied || !report.Plan.IsEmpty() {
+			t.Errorf("err = %v, applied %v, план %+v", err, report.Applied, report.Plan)
+		}
+	})
+}
@@ -0,0 +1,578 @@
+package handle
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"birthdayGreetings/internal/calendar"
+	"birthdayGreetings/internal/db"
+
+	"github.com/gofrs/uuid"
+	tb "gopkg.in/telebot.v3"
+)
+
+// Уникальные имена inline-кнопок подписки на команды
+const (
+	btnTeams    = "team_list" // список команд
+	btnTeamCard = "team_card" // карточка команды: id
+	btnTeamOn   = "team_on"   // подписаться на команду: id|hours
+	btnTeamOff  = "team_off"  // отписаться от команды: id
+)
+
+// teamCardMembers сколько участников показывать в карточке команды
+const teamCardMembers = 30
+
+// teamKinds названия видов команд
+var teamKinds = map[db.TeamKind]string{db.TeamKindDepartment: "Отдел", db.TeamKindTeam: "Команда"}
+
+// Teams список отделов и команд с подпиской на всех участников сразу: /teams
+func (h *Handle) Teams(c tb.Context) error {
+	employee, err := h.authMiddleware(c, db.RoleEmployee)
+	if err != nil {
+		return h.denied(c, err)
+	}
+
+	text, markup, err := h.teamsPage(employee)
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+
+	return c.Send(text, markup)
+}
+
+// NewTeam создает команду: /newteam Название
+func (h *Handle) NewTeam(c tb.Context) error {
+	return h.createTeam(c, db.TeamKindTeam)
+}
+
+// NewDepartment создает отдел: /newdepartment Название
+func (h *Handle) NewDepartment(c tb.Context) error {
+	return h.createTeam(c, db.TeamKindDepartment)
+}
+
+// createTeam общая часть /newteam и /newdepartment
+func (h *Handle) createTeam(c tb.Context, kind db.TeamKind) error {
+	if _, err := h.authMiddleware(c, db.RoleHR); err != nil {
+		return h.denied(c, err)
+	}
+
+	name := strings.TrimSpace(c.Message().Payload)
+	if name == "" {
+		return c.Send("Укажите название, например:\n\n" + c.Message().Text + " Бэкенд")
+	}
+
+	team, err := h.db.CreateTeam(db.Team{Name: name, Kind: kind})
+	if errors.Is(err, db.ErrTeamExists) {
+		return c.Send(err.Error())
+	} else if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+
+	return c.Send(fmt.Sprintf("%s «%s» создан(а). Добавить участника: /teamadd <UUID> %s",
+		teamKinds[team.Kind], team.Name, team.Name))
+}
+
+// DeleteTeam удаляет команду вместе с подписками на нее: /deleteteam Название
+func (h *Handle) DeleteTeam(c tb.Context) error {
+	if _, err := h.authMiddleware(c, db.RoleHR); err != nil {
+		return h.denied(c, err)
+	}
+
+	team, err := h.db.GetTeam(db.Team{Name: strings.TrimSpace(c.Message().Payload)})
+	if err != nil {
+		return h.teamError(c, err)
+	}
+	subscribers, err := h.db.GetTeamSubscribers(team.ID)
+	if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+
+	if err = h.db.DeleteTeam(team.ID); err != nil {
+		return h.teamError(c, err)
+	}
+	// Участников могли покрывать и другие команды подписчиков
+	synced := make(map[uuid.UUID]bool, len(subscribers))
+	for _, s := range subscribers {
+		if synced[s.SubscriberID] {
+			continue // строка на каждое оповещание
+		}
+		synced[s.SubscriberID] = true
+		if err = h.syncTeamSubscriptions(s.SubscriberID); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return c.Send(fmt.Sprintf("%s «%s» удален(а)", teamKinds[team.Kind], team.Name))
+}
+
+// TeamAdd добавляет сотрудника в команду: /teamadd <UUID> Название
+func (h *Handle) TeamAdd(c tb.Context) error {
+	return h.teamMember(c, true)
+}
+
+// TeamRemove убирает сотрудника из команды: /teamremove <UUID> Название
+func (h *Handle) TeamRemove(c tb.Context) error {
+	return h.teamMember(c, false)
+}
+
+// teamMember общая часть /teamadd и /teamremove: после изменения состава подписки на команду
+// сразу следуют за ним
+func (h *Handle) teamMember(c tb.Context, add bool) error {
+	if _, err := h.authMiddleware(c, db.RoleHR); err != nil {
+		return h.denied(c, err)
+	}
+
+	arg, name, _ := strings.Cut(strings.TrimSpace(c.Message().Payload), " ")
+	if arg == "" || strings.TrimSpace(name) == "" {
+		return c.Send("Формат: " + strings.Fields(c.Message().Text)[0] + " <UUID сотрудника> Название команды")
+	}
+	employee, err := h.employeeArg(arg)
+	if err != nil {
+		return c.Send(err.Error())
+	}
+	team, err := h.db.GetTeam(db.Team{Name: strings.TrimSpace(name)})
+	if err != nil {
+		return h.teamError(c, err)
+	}
+
+	if add {
+		err = h.db.AddTeamMember(team.ID, employee.ID)
+	} else {
+		err = h.db.RemoveTeamMember(team.ID, employee.ID)
+	}
+	if errors.Is(err, db.ErrNotFound) && !add {
+		return c.Send("Сотрудник не состоит в этой команде")
+	} else if err != nil {
+		log.Println(err)
+		return c.Send("Ошибка, попробуйте еще раз")
+	}
+
+	if err = h.syncTeam(team.ID); err != nil {
+		log.Println(err)
+		return c.Send("Состав изменен, но подписки на команду обновить не удалось")
+	}
+
+	if add {
+		return c.Send(fmt.Sprintf("%s %s добавлен(а) в «%s»", employee.LastName, employee.FirstName, team.Name))
+	}
+	return c.Send(fmt.Sprintf("%s %s убран(а) из «%s»", employee.LastName, employee.FirstName, team.Name))
+}
+
+// teamError сообщит об ошибке поиска команды
+func (h *Handle) teamError(c tb.Context, err error) error {
+	if errors.Is(err, db.ErrTeamNotFound) {
+		return c.Send("Команда не найдена, список: /teams")
+	}
+
+	log.Println(err)
+	return c.Send("Ошибка, попробуйте еще раз")
+}
+
+// syncTeam обновит подписки всех, кто подписан на команду (после изменения ее состава)
+func (h *Handle) syncTeam(teamID uuid.UUID) error {
+	subscribers, err := h.db.GetTeamSubscribers(teamID)
+	if err != nil {
+		return err
+	}
+
+	synced := make(map[uuid.UUID]bool, len(subscribers))
+	for _, s := range subscribers {
+		if synced[s.SubscriberID] {
+			continue // строка на каждое оповещание
+		}
+		synced[s.SubscriberID] = true
+		if err = h.syncTeamSubscriptions(s.SubscriberID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// syncMemberTeams обновит подписки на все команды сотрудника (например, после его возвращения)
+func (h *Handle) syncMemberTeams(employeeID uuid.UUID) error {
+	teams, err := h.db.GetEmployeeTeams(employeeID)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range teams {
+		if err = h.syncTeam(t.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// syncTeamSubscriptions приведет заведенные по командам подписки сотрудника в соответствие
+// с его подписками на команды и их составом: новые участники добавляются, ушедшие и
+// деактивированные удаляются. Подписки на самих сотрудников не меняются и важнее командных
+func (h *Handle) syncTeamSubscriptions(subscriberID uuid.UUID) error {
+	subscriber, err := h.db.GetEmployee(db.Employee{ID: subscriberID})
+	if err != nil {
+		return err
+	}
+	teamSubscriptions, err := h.db.GetTeamSubscriptions(subscriberID)
+	if err != nil {
+		return err
+	}
+
+	// Участник нескольких команд подписчика получает оповещания первой из них
+	type member struct {
+		employee db.Employee
+		teamID   uuid.UUID
+		leads    map[time.Duration]bool
+	}
+	members := make(map[uuid.UUID]member)
+	for _, team := range groupTeamSubscriptions(teamSubscriptions) {
+		leads := make(map[time.Duration]bool, len(team))
+		for _, ts := range team {
+			leads[ts.LeadTime] = true
+		}
+		employees, err := h.db.GetTeamMembers(team[0].TeamID)
+		if err != nil {
+			return err
+		}
+		for _, e := range employees {
+			if _, ok := members[e.ID]; !ok && e.ID != subscriberID && e.Status.Listed() {
+				members[e.ID] = member{employee: e, teamID: team[0].TeamID, leads: leads}
+			}
+		}
+	}
+
+	subscriptions, err := h.db.GetSubscriptions(subscriberID)
+	if err != nil {
+		return err
+	}
+	personal := make(map[uuid.UUID]bool, len(subscriptions))
+	actual := make(map[uuid.UUID]map[time.Duration]bool, len(subscriptions))
+	for _, s := range subscriptions {
+		if s.Event != db.EventBirthday {
+			continue // команды подписывают только на Дни рождения
+		}
+		m, ok := members[s.TargetID]
+		switch {
+		case !s.TeamID.Valid:
+			personal[s.TargetID] = true
+		case ok && s.TeamID.UUID == m.teamID && m.leads[s.LeadTime]:
+			if actual[s.TargetID] == nil {
+				actual[s.TargetID] = make(map[time.Duration]bool)
+			}
+			actual[s.TargetID][s.LeadTime] = true
+		default:
+			// оповещание другой команды или с прежним временем заменится новым
+			err = h.db.RemoveSubscriptionLead(subscriberID, s.TargetID, db.EventBirthday, s.LeadTime)
+			if err != nil && !errors.Is(err, db.ErrSubscriptionNotFound) {
+				return err
+			}
+		}
+	}
+
+	for id, m := range members {
+		if personal[id] {
+			continue
+		}
+		for lead := range m.leads {
+			if actual[id][lead] {
+				continue
+			}
+			err = h.db.AddSubscription(db.Subscription{
+				SubscriberID: subscriberID,
+				TargetID:     id,
+				Event:        db.EventBirthday,
+				LeadTime:     lead,
+				NextFireAt: calendar.NextReminder(m.employee.BirthDate, lead, h.clock.Now(),
+					subscriber.Location(), h.leapPolicy),
+				TeamID: uuid.NullUUID{UUID: m.teamID, Valid: true},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// onTeams возвращает к списку команд
+func (h *Handle) onTeams(c tb.Context) error {
+	employee, err := h.callbackAuth(c)
+	if err != nil {
+		return err
+	}
+
+	text, markup, err := h.teamsPage(employee)
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+
+	c.Edit(text, markup)
+	return c.Respond()
+}
+
+// onTeamCard открывает карточку команды
+func (h *Handle) onTeamCard(c tb.Context) error {
+	employee, err := h.callbackAuth(c)
+	if err != nil {
+		return err
+	}
+
+	team, err := h.callbackTeam(c.Args())
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+
+	return h.editTeamCard(c, employee, team, "")
+}
+
+// onTeamSubscribe включает оповещание о команде за выбранное время (или выключает уже включенное)
+func (h *Handle) onTeamSubscribe(c tb.Context) error {
+	employee, err := h.callbackAuth(c)
+	if err != nil {
+		return err
+	}
+
+	args := c.Args()
+	team, err := h.callbackTeam(args)
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+	hours := 0
+	if len(args) > 1 {
+		hours, _ = strconv.Atoi(args[1])
+	}
+	lead := time.Duration(hours) * time.Hour
+
+	subscribed, err := h.teamSubscriptions(employee.ID)
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+	enabled := false
+	for _, s := range subscribed[team.ID] {
+		enabled = enabled || s.LeadTime == lead
+	}
+
+	notice := "Оповещание включено для всех участников"
+	if enabled {
+		err = h.db.RemoveTeamSubscriptionLead(employee.ID, team.ID, lead)
+		if err != nil && !errors.Is(err, db.ErrSubscriptionNotFound) {
+			return h.callbackError(c, err)
+		}
+		notice = "Оповещание выключено"
+	} else if err = h.db.AddTeamSubscription(db.TeamSubscription{
+		SubscriberID: employee.ID, TeamID: team.ID, LeadTime: lead}); err != nil {
+		return h.callbackError(c, err)
+	}
+	// Без оповещаний этой команды участников могут покрывать другие
+	if err = h.syncTeamSubscriptions(employee.ID); err != nil {
+		return h.callbackError(c, err)
+	}
+
+	return h.editTeamCard(c, employee, team, notice)
+}
+
+// onTeamUnsubscribe отписывает от команды
+func (h *Handle) onTeamUnsubscribe(c tb.Context) error {
+	employee, err := h.callbackAuth(c)
+	if err != nil {
+		return err
+	}
+
+	team, err := h.callbackTeam(c.Args())
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+
+	err = h.db.RemoveTeamSubscription(employee.ID, team.ID)
+	if err != nil && !errors.Is(err, db.ErrSubscriptionNotFound) {
+		return h.callbackError(c, err)
+	}
+	// Часть участников может остаться в подписке через другие команды
+	if err = h.syncTeamSubscriptions(employee.ID); err != nil {
+		return h.callbackError(c, err)
+	}
+
+	return h.editTeamCard(c, employee, team, "Вы отписаны от команды")
+}
+
+// editTeamCard перерисует сообщение карточкой команды и ответит на нажатие
+func (h *Handle) editTeamCard(c tb.Context, employee db.Employee, team db.Team, notice string) error {
+	text, markup, err := h.teamCard(employee, team)
+	if err != nil {
+		return h.callbackError(c, err)
+	}
+
+	c.Edit(text, markup)
+	return c.Respond(&tb.CallbackResponse{Text: notice})
+}
+
+// teamsPage текст и клавиатура списка команд
+func (h *Handle) teamsPage(employee db.Employee) (string, *tb.ReplyMarkup, error) {
+	teams, err := h.db.GetTeams()
+	if err != nil {
+		return "", nil, err
+	}
+	subscribed, err := h.teamSubscriptions(employee.ID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	markup := &tb.ReplyMarkup{}
+	var rows []tb.Row
+	for _, t := range teams {
+		text := fmt.Sprintf("%s «%s»", teamKinds[t.Kind], t.Name)
+		if s, ok := subscribed[t.ID]; ok {
+			text = "✅ " + text + " · " + teamLeadsText(s)
+		}
+		rows = append(rows, markup.Row(markup.Data(text, btnTeamCard, t.ID.String())))
+	}
+	markup.Inline(rows...)
+
+	if len(teams) == 0 {
+		return "Отделов и команд пока нет", markup, nil
+	}
+
+	return "Подписка на отдел или команду - это подписка на каждого участника, она следует за составом " +
+		"(✅ - вы уже подписаны):", markup, nil
+}
+
+// teamCard текст и клавиатура карточки команды
+func (h *Handle) teamCard(employee db.Employee, team db.Team) (string, *tb.ReplyMarkup, error) {
+	members, err := h.db.GetTeamMembers(team.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	subscribed, err := h.teamSubscriptions(employee.ID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var names []string
+	for _, e := range members {
+		if e.Status.Listed() {
+			names = append(names, e.LastName+" "+e.FirstName)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s «%s», участников: %d\n", teamKinds[team.Kind], team.Name, len(names))
+	for i := 0; i < len(names) && i < teamCardMembers; i++ {
+		b.WriteString(names[i] + "\n")
+	}
+	if len(names) > teamCardMembers {
+		fmt.Fprintf(&b, "... и еще %d\n", len(names)-teamCardMembers)
+	}
+
+	current, ok := subscribed[team.ID]
+	if ok {
+		fmt.Fprintf(&b, "\nПодписка: %s", teamLeadsText(current))
+	} else {
+		b.WriteString("\nПодписки нет. Когда оповещать о Днях рождения участников?")
+	}
+	b.WriteString("\nМожно выбрать несколько вариантов, повторное нажатие выключает оповещание")
+
+	enabled := make(map[time.Duration]bool, len(current))
+	for _, s := range current {
+		enabled[s.LeadTime] = true
+	}
+
+	id := team.ID.String()
+	markup := &tb.ReplyMarkup{}
+	var choices []tb.Btn
+	for _, choice := range leadChoices {
+		label := choice.text
+		if enabled[choice.lead] {
+			label = "• " + label
+		}
+		choices = append(choices, markup.Data(label, btnTeamOn, id, strconv.Itoa(int(choice.lead/time.Hour))))
+	}
+
+	rows := markup.Split(2, choices)
+	if ok {
+		rows = append(rows, markup.Row(markup.Data("Отписаться", btnTeamOff, id)))
+	}
+	rows = append(rows, markup.Row(markup.Data("« К командам", btnTeams)))
+	markup.Inline(rows...)
+
+	return b.String(), markup, nil
+}
+
+// teamSubscriptions оповещания подписок сотрудника на команды по ID команды
+func (h *Handle) teamSubscriptions(employeeID uuid.UUID) (map[uuid.UUID][]db.TeamSubscription, error) {
+	subscriptions, err := h.db.GetTeamSubscriptions(employeeID)
+	if err != nil {
+		return nil, err
+	}
+
+	subscribed := make(map[uuid.UUID][]db.TeamSubscription, len(subscriptions))
+	for _, s := range subscriptions {
+		subscribed[s.TeamID] = append(subscribed[s.TeamID], s)
+	}
+
+	return subscribed, nil
+}
+
+// groupTeamSubscriptions разобьет оповещания на подписки по командам, сохраняя порядок команд
+// из GetTeamSubscriptions
+func groupTeamSubscriptions(subscriptions []db.TeamSubscription) [][]db.TeamSubscription {
+	var teams [][]db.TeamSubscription
+	for i, s := range subscriptions {
+		if i == 0 || subscriptions[i-1].TeamID != s.TeamID {
+			teams = append(teams, nil)
+		}
+		teams[len(teams)-1] = append(teams[len(teams)-1], s)
+	}
+
+	return teams
+}
+
+// teamLeadsText времена оповещаний подписки на команду через запятую
+func teamLeadsText(subscriptions []db.TeamSubscription) string {
+	leads := make([]db.Subscription, 0, len(subscriptions))
+	for _, s := range subscriptions {
+		leads = append(leads, db.Subscription{LeadTime: s.LeadTime})
+	}
+
+	return leadsText(leads)
+}
+
+// callbackTeam команда из первого аргумента кнопки
+func (h *Handle) callbackTeam(args []string) (db.Team, error) {
+	if len(args) == 0 {
+		return db.Team{}, db.ErrTeamNotFound
+	}
+	id, err := uuid.FromString(args[0])
+	if err != nil {
+		return db.Team{}, err
+	}
+
+	return h.db.GetTeam(db.Team{ID: id})
+}
+
+// teamSubscriptionsText подписки сотрудника на команды словами (пусто, если их нет)
+func (h *Handle) teamSubscriptionsText(employeeID uuid.UUID) (string, error) {
+	subscriptions, err := h.db.GetTeamSubscriptions(employeeID)
+	if err != nil || len(subscriptions) == 0 {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString("Подписки на отделы и команды (/teams):\n")
+	for _, leads := range groupTeamSubscriptions(subscriptions) {
+		team, err := h.db.GetTeam(db.Team{ID: leads[0].TeamID})
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s «%s» · %s\n", teamKinds[team.Kind], team.Name, teamLeadsText(leads))
+	}
+
+	return b.String(), nil
+}
@@ -3,50 +3,38 @@ package mailer
 import (
 	"errors"
 	"fmt"
-	"math/rand"
 	"os"
 	"strconv"
+	"time"
 
 	"gopkg.in/gomail.v2"
 )
 
-// SendPasswordToEmail функция для отправки пароля на email
-func SendPasswordToEmail(email string) (string, error) {
-	// Генерируем случайный пароль
-	password := generateRandomPassword(5)
-
+// SendCodeToEmail функция для отправки кода входа на email
+func SendCodeToEmail(email, code string, ttl time.Duration) error {
 	mailer, err := makeMailer()
 	if err != nil {
-		return "", fmt.Errorf("не удалось создать mailer. %v", err)
+		return fmt.Errorf("не удалось создать mailer. %v", err)
 	}
 
 	recipient := "MAILER_RECIPIENT"
 	if recipient == "" {
-		return "", fmt.Errorf("пустой получатель")
+		return fmt.Errorf("пустой получатель")
 	}
 
 	m := gomail.NewMessage()
 	m.SetHeader("From", "your-email@example.com")
 	m.SetHeader("To", email)
-	m.SetHeader("Subject", "Временный пароль")
-	m.SetBody("text/plain", fmt.Sprintf("Ваш временный пароль: %s", password))
+	m.SetHeader("Subject", "Код для входа")
+	m.SetBody("text/plain", fmt.Sprintf("Ваш код для входа: %s\nКод действует %s. Никому его не сообщайте.",
+		code, ttl))
 
 	err = mailer.DialAndSend(m)
 	if err != nil {
-		return "", fmt.Errorf("не удалось отправить почту: %v", err)
+		return fmt.Errorf("не удалось отправить почту: %v", err)
 	}
 
-	return password, nil
-}
-
-// generateRandomPassword функция для генерации случайного пароля
-func generateRandomPassword(length int) string {
-	charset := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	password := make([]byte, length)
-	for i := range password {
-		password[i] = charset[rand.Intn(len(charset))]
-	}
-	return string(password)
+	return nil
 }
 
 // makeMailer функция создания mailer-а
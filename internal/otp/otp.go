@@ -0,0 +1,52 @@
+package otp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"os"
+	"time"
+)
+
+// Length число цифр в коде
+const Length = 6
+
+// Code одноразовый код входа, хранится только хэш
+type Code struct {
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Expired истек ли код с временем жизни ttl к моменту now
+func (c Code) Expired(now time.Time, ttl time.Duration) bool {
+	return !now.Before(c.CreatedAt.Add(ttl))
+}
+
+// Generate случайный цифровой код из crypto/rand
+func Generate() (string, error) {
+	code := make([]byte, Length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + n.Int64())
+	}
+
+	return string(code), nil
+}
+
+// Hash HMAC-SHA256 кода на ключе SECRET: без ключа короткий код не перебрать по утекшему хэшу
+func Hash(code string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("SECRET")))
+	mac.Write([]byte(code))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify сравнит введенный код с хэшем за постоянное время
+func Verify(hash, code string) bool {
+	return hmac.Equal([]byte(hash), []byte(Hash(code)))
+}
@@ -0,0 +1,66 @@
+package otp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerate(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		code, err := Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != Length || strings.Trim(code, "0123456789") != "" {
+			t.Fatalf("код %q: ожидалось %d цифр", code, Length)
+		}
+		seen[code] = true
+	}
+	if len(seen) < 2 {
+		t.Error("Generate выдает один и тот же код")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Setenv("SECRET", "secret")
+	hash := Hash("123456")
+	if hash == "123456" || len(hash) != 64 {
+		t.Errorf("Hash(123456) = %q, ожидался hex HMAC-SHA256", hash)
+	}
+
+	tests := []struct {
+		code string
+		ok   bool
+	}{
+		{"123456", true},
+		{"123457", false},
+		{"12345", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := Verify(hash, tt.code); got != tt.ok {
+			t.Errorf("Verify(%q) = %v, want %v", tt.code, got, tt.ok)
+		}
+	}
+
+	// с другим ключом тот же код дает другой хэш
+	t.Setenv("SECRET", "other")
+	if Verify(hash, "123456") {
+		t.Error("код принят с другим SECRET")
+	}
+}
+
+func TestExpired(t *testing.T) {
+	created := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+	c := Code{Hash: Hash("123456"), CreatedAt: created}
+	ttl := 10 * time.Minute
+
+	if c.Expired(created.Add(ttl-time.Second), ttl) {
+		t.Error("код истек раньше срока")
+	}
+	if !c.Expired(created.Add(ttl), ttl) {
+		t.Error("код действует после срока")
+	}
+}
@@ -0,0 +1,183 @@
+package roster
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"birthdayGreetings/internal/db"
+
+	"github.com/gofrs/uuid"
+)
+
+// Change сотрудник, данные которого в таблице отличаются от базы
+type Change struct {
+	Employee db.Employee `json:"employee"`
+	Row      Row         `json:"row"`
+	Fields   []string    `json:"fields"` // что изменилось, для предпросмотра
+}
+
+// Plan что изменится после импорта: сотрудники сопоставляются по табельному номеру,
+// а если его нет в таблице или в базе - по email. Отсутствующие в таблице деактивируются
+type Plan struct {
+	Added   []Row         `json:"added"`
+	Changed []Change      `json:"changed"`
+	Removed []db.Employee `json:"removed"`
+}
+
+// Diff сравнит сотрудников в базе с таблицей. Вернет ошибки по строкам, если двум строкам
+// соответствует один сотрудник (например, одной - по табельному номеру, другой - по email)
+func Diff(current []db.Employee, rows []Row) (Plan, []error) {
+	byEmail := make(map[string]db.Employee, len(current))
+	byExternalID := make(map[string]db.Employee, len(current))
+	for _, e := range current {
+		email := strings.ToLower(e.Email)
+		if _, ok := byEmail[email]; !ok {
+			byEmail[email] = e
+		}
+		if e.ExternalID != "" {
+			byExternalID[e.ExternalID] = e
+		}
+	}
+
+	var plan Plan
+	var problems []error
+	seen := make(map[uuid.UUID]int, len(rows)) // сотрудник - строка, которой он сопоставлен
+	for _, row := range rows {
+		e, ok := byExternalID[row.ExternalID]
+		if !ok || row.ExternalID == "" {
+			e, ok = byEmail[row.Email]
+		}
+		if !ok {
+			plan.Added = append(plan.Added, row)
+			continue
+		}
+		if first, ok := seen[e.ID]; ok {
+			problems = append(problems, fmt.Errorf("строка %d: сотрудник %s %s (%s) уже сопоставлен строке %d",
+				row.Line, e.LastName, e.FirstName, e.Email, first))
+			continue
+		}
+		seen[e.ID] = row.Line
+
+		if fields := changedFields(e, row); len(fields) > 0 {
+			plan.Changed = append(plan.Changed, Change{Employee: e, Row: row, Fields: fields})
+		}
+	}
+
+	for _, e := range current {
+		if _, ok := seen[e.ID]; e.Status.Listed() && !ok {
+			plan.Removed = append(plan.Removed, e)
+		}
+	}
+
+	return plan, problems
+}
+
+// changedFields чем строка таблицы отличается от сотрудника
+func changedFields(e db.Employee, row Row) []string {
+	var fields []string
+	if e.LastName != row.LastName {
+		fields = append(fields, "фамилия")
+	}
+	if e.FirstName != row.FirstName {
+		fields = append(fields, "имя")
+	}
+	if e.Patronymic != row.Patronymic {
+		fields = append(fields, "отчество")
+	}
+	if !strings.EqualFold(e.Email, row.Email) {
+		fields = append(fields, "email")
+	}
+	if !e.BirthDate.Equal(row.BirthDate) {
+		fields = append(fields, "дата рождения")
+	}
+	if row.ExternalID != "" && e.ExternalID != row.ExternalID {
+		fields = append(fields, "табельный номер")
+	}
+	if !row.HireDate.IsZero() && !(e.HireDate.Valid && e.HireDate.Time.Equal(row.HireDate)) {
+		fields = append(fields, "дата приема")
+	}
+	if e.Status == db.StatusTerminated {
+		fields = append(fields, "снова активен")
+	}
+
+	return fields
+}
+
+// IsEmpty нечего менять
+func (p Plan) IsEmpty() bool {
+	return len(p.Added) == 0 && len(p.Changed) == 0 && len(p.Removed) == 0
+}
+
+// Import изменения для db в одной транзакции
+func (p Plan) Import() db.EmployeeImport {
+	var im db.EmployeeImport
+	for _, row := range p.Added {
+		im.Add = append(im.Add, row.employee(db.Employee{}))
+	}
+	for _, c := range p.Changed {
+		im.Update = append(im.Update, c.Row.employee(c.Employee))
+	}
+	for _, e := range p.Removed {
+		im.Deactivate = append(im.Deactivate, e.ID)
+	}
+
+	return im
+}
+
+// employee сотрудник base с данными из строки таблицы
+func (row Row) employee(base db.Employee) db.Employee {
+	base.LastName, base.FirstName, base.Patronymic = row.LastName, row.FirstName, row.Patronymic
+	base.Email, base.BirthDate = row.Email, row.BirthDate
+	// об отпусках таблица не знает: деактивированный снова активен, отпуск сохраняется
+	if base.Status != db.StatusOnLeave {
+		base.Status = db.StatusActive
+	}
+	if row.ExternalID != "" {
+		base.ExternalID = row.ExternalID
+	}
+	// пустая дата приема в таблице не стирает известную
+	if !row.HireDate.IsZero() {
+		base.HireDate = sql.NullTime{Time: row.HireDate, Valid: true}
+	}
+
+	return base
+}
+
+// Preview текст предпросмотра, по каждому виду изменений не больше limit строк
+func (p Plan) Preview(limit int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Новых: %d, изменится: %d, будут деактивированы: %d\n",
+		len(p.Added), len(p.Changed), len(p.Removed))
+
+	section := func(title string, n int, line func(i int) string) {
+		if n == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n%s:\n", title)
+		for i := 0; i < n && i < limit; i++ {
+			b.WriteString(line(i) + "\n")
+		}
+		if n > limit {
+			fmt.Fprintf(&b, "... и еще %d\n", n-limit)
+		}
+	}
+
+	section("Новые", len(p.Added), func(i int) string {
+		r := p.Added[i]
+		return fmt.Sprintf("+ %s %s %s, %s, %s", r.LastName, r.FirstName, r.Patronymic, r.Email, r.BirthDate.Format(dateLayout))
+	})
+	section("Изменения", len(p.Changed), func(i int) string {
+		c := p.Changed[i]
+		return fmt.Sprintf("~ %s %s (%s): %s", c.Row.LastName, c.Row.FirstName, c.Row.Email, strings.Join(c.Fields, ", "))
+	})
+	section("Нет в таблице - будут деактивированы", len(p.Removed), func(i int) string {
+		e := p.Removed[i]
+		return fmt.Sprintf("- %s %s %s, %s", e.LastName, e.FirstName, e.Patronymic, e.Email)
+	})
+
+	return b.String()
+}
+
+// dateLayout формат дат в предпросмотре
+const dateLayout = "02.01.2006"
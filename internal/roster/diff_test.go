@@ -0,0 +1,113 @@
+package roster
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"birthdayGreetings/internal/db"
+
+	"github.com/gofrs/uuid"
+)
+
+// diffEmployee сотрудник в базе
+func diffEmployee(lastName, firstName, email, birthDate, externalID string, status db.Status) db.Employee {
+	date, err := time.Parse(time.DateOnly, birthDate)
+	if err != nil {
+		panic(err)
+	}
+
+	return db.Employee{
+		ID:         uuid.Must(uuid.NewV4()),
+		LastName:   lastName,
+		FirstName:  firstName,
+		Email:      email,
+		BirthDate:  date,
+		ExternalID: externalID,
+		Status:     status,
+	}
+}
+
+func TestDiff(t *testing.T) {
+	current := []db.Employee{
+		diffEmployee("Иванов", "Иван", "ivanov@example.com", "1990-05-12", "T-1", db.StatusActive),
+		diffEmployee("Петров", "Петр", "Petrov@Example.com", "1985-02-01", "", db.StatusActive),
+		diffEmployee("Кузнецова", "Светлана", "kuznetsova@example.com", "1988-08-08", "T-3", db.StatusActive),
+		diffEmployee("Федоров", "Федор", "fedorov@example.com", "1987-04-04", "", db.StatusTerminated),
+		diffEmployee("Орлов", "Олег", "orlov@example.com", "1993-03-03", "T-5", db.StatusActive),
+		diffEmployee("Ушедший", "Уно", "gone@example.com", "1980-01-01", "T-6", db.StatusActive),
+		diffEmployee("Отпускной", "Отто", "leave@example.com", "1981-01-01", "", db.StatusOnLeave),
+		diffEmployee("Уволенный", "Ульян", "fired@example.com", "1982-01-01", "", db.StatusTerminated),
+	}
+
+	data, err := os.ReadFile(filepath.Join("testdata", "sync.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, problems, err := Parse("sync.csv", data, rosterNow)
+	if err != nil || len(problems) > 0 {
+		t.Fatal(err, problems)
+	}
+
+	plan, problems := Diff(current, rows)
+
+	var added, changed, removed []string
+	for _, r := range plan.Added {
+		added = append(added, r.LastName)
+	}
+	for _, c := range plan.Changed {
+		changed = append(changed, fmt.Sprintf("%s->%s: %s", c.Employee.LastName, c.Row.LastName, strings.Join(c.Fields, ", ")))
+	}
+	for _, e := range plan.Removed {
+		removed = append(removed, e.LastName)
+	}
+
+	// Иванов сопоставлен по табельному номеру, Петров - по email без учета регистра, оба без изменений;
+	// Кузнецова - по табельному номеру, хотя email другой
+	wantChanged := []string{
+		"Кузнецова->Сидорова: фамилия, email",
+		"Федоров->Федоров: снова активен",
+		"Орлов->Орлов: email",
+	}
+	if fmt.Sprint(added) != "[Новиков]" {
+		t.Errorf("добавлены %v, ожидался [Новиков]", added)
+	}
+	if fmt.Sprintf("%q", changed) != fmt.Sprintf("%q", wantChanged) {
+		t.Errorf("изменены:\n got %q\nwant %q", changed, wantChanged)
+	}
+	// уволенного не деактивируем повторно, в отпуске - деактивируем, если его нет в таблице
+	if fmt.Sprint(removed) != "[Ушедший Отпускной]" {
+		t.Errorf("деактивированы %v, ожидалось [Ушедший Отпускной]", removed)
+	}
+
+	// по новому email Орлова нет, но по табельному номеру он уже взят строкой 7
+	wantProblems := "[строка 8: сотрудник Орлов Олег (orlov@example.com) уже сопоставлен строке 7]"
+	if fmt.Sprint(problems) != wantProblems {
+		t.Errorf("ошибки:\n got %v\nwant %s", problems, wantProblems)
+	}
+
+	// повторный импорт той же таблицы после применения ничего не меняет
+	var applied []db.Employee
+	for _, r := range plan.Added {
+		applied = append(applied, r.employee(db.Employee{ID: uuid.Must(uuid.NewV4())}))
+	}
+	for _, e := range current {
+		for _, c := range plan.Changed {
+			if c.Employee.ID == e.ID {
+				e = c.Row.employee(e)
+			}
+		}
+		for _, r := range plan.Removed {
+			if r.ID == e.ID {
+				e.Status = db.StatusTerminated
+			}
+		}
+		applied = append(applied, e)
+	}
+	if again, _ := Diff(applied, rows[:len(rows)-1]); !again.IsEmpty() {
+		t.Errorf("повторный импорт: %+v", again)
+	}
+}
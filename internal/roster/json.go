@@ -0,0 +1,60 @@
+package roster
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+)
+
+// readJSON прочитает выгрузку HR-системы: массив сотрудников или {"employees": [...]}.
+// Ключи объектов становятся заголовками, как в первой строке таблицы
+func readJSON(data []byte) ([][]string, error) {
+	var objects []map[string]interface{}
+	if err := json.Unmarshal(data, &objects); err != nil {
+		var wrapped struct {
+			Employees []map[string]interface{} `json:"employees"`
+		}
+		if json.Unmarshal(data, &wrapped) != nil || wrapped.Employees == nil {
+			return nil, errors.New("ожидается массив сотрудников или объект с полем employees")
+		}
+		objects = wrapped.Employees
+	}
+
+	keys := make(map[string]bool)
+	for _, o := range objects {
+		for k := range o {
+			keys[k] = true
+		}
+	}
+	header := make([]string, 0, len(keys))
+	for k := range keys {
+		header = append(header, k)
+	}
+	sort.Strings(header)
+
+	records := [][]string{header}
+	for _, o := range objects {
+		record := make([]string, len(header))
+		for i, k := range header {
+			record[i] = jsonValue(o[k])
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// jsonValue значение поля строкой (табельный номер может прийти числом)
+func jsonValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,234 @@
+package roster
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Row сотрудник из таблицы отдела кадров
+type Row struct {
+	Line       int       `json:"line"` // номер строки в файле (для сообщений об ошибках)
+	LastName   string    `json:"last_name"`
+	FirstName  string    `json:"first_name"`
+	Patronymic string    `json:"patronymic"`
+	Email      string    `json:"email"`
+	BirthDate  time.Time `json:"birth_date"`
+	ExternalID string    `json:"external_id"` // идентификатор в HR-системе, необязательный
+	HireDate   time.Time `json:"hire_date"`   // дата приема, необязательная (нулевая, если не указана)
+}
+
+// column колонки таблицы
+type column int
+
+const (
+	colLastName column = iota
+	colFirstName
+	colPatronymic
+	colEmail
+	colBirthDate
+	colExternalID
+	colHireDate
+	columnCount
+)
+
+// headers допустимые заголовки колонок (в нижнем регистре)
+var headers = map[string]column{
+	"last_name": colLastName, "фамилия": colLastName,
+	"first_name": colFirstName, "имя": colFirstName,
+	"patronymic": colPatronymic, "отчество": colPatronymic,
+	"email": colEmail, "e-mail": colEmail, "почта": colEmail,
+	"birth_date": colBirthDate, "дата рождения": colBirthDate, "день рождения": colBirthDate,
+	"external_id": colExternalID, "табельный номер": colExternalID,
+	"hire_date": colHireDate, "дата приема": colHireDate, "дата приёма": colHireDate,
+}
+
+// ErrFormat неподдерживаемый формат файла
+var ErrFormat = errors.New("поддерживаются только файлы .csv, .xlsx и .json")
+
+var emailRe = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+
+// Parse разберет таблицу .csv или .xlsx с заголовком (фамилия, имя, отчество, email, дата рождения,
+// табельный номер, дата приема) или выгрузку .json и проверит каждую строку (даты рождения - не позже now).
+// Вернет корректные строки и ошибки по остальным
+func Parse(name string, data []byte, now time.Time) ([]Row, []error, error) {
+	var records [][]string
+	var err error
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		records, err = readCSV(data)
+	case ".xlsx":
+		records, err = readXLSX(data)
+	case ".json":
+		records, err = readJSON(data)
+	default:
+		return nil, nil, ErrFormat
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, errors.New("файл пустой")
+	}
+
+	index, err := headerIndex(records[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []Row
+	var problems []error
+	emails, externalIDs := make(map[string]int), make(map[string]int)
+	// В таблице строки нумеруются с заголовком, в .json - записи с единицы
+	first := 2
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		first = 1
+	}
+	for i, record := range records[1:] {
+		line := i + first
+		if isBlank(record) {
+			continue
+		}
+
+		row, err := parseRow(record, index, line, now)
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		if first, ok := emails[row.Email]; ok {
+			problems = append(problems, fmt.Errorf("строка %d: email %s уже был в строке %d", line, row.Email, first))
+			continue
+		}
+		if first, ok := externalIDs[row.ExternalID]; ok && row.ExternalID != "" {
+			problems = append(problems, fmt.Errorf("строка %d: табельный номер %s уже был в строке %d", line, row.ExternalID, first))
+			continue
+		}
+		emails[row.Email], externalIDs[row.ExternalID] = line, line
+		rows = append(rows, row)
+	}
+
+	return rows, problems, nil
+}
+
+// readCSV прочитает csv с разделителем "," или ";" (так сохраняет Excel в русской локали)
+func readCSV(data []byte) ([][]string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	firstLine, _, _ := bytes.Cut(data, []byte("\n"))
+
+	r := csv.NewReader(bytes.NewReader(data))
+	if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		r.Comma = ';'
+	}
+	r.FieldsPerRecord = -1
+
+	return r.ReadAll()
+}
+
+// headerIndex номера колонок по заголовку
+func headerIndex(header []string) ([columnCount]int, error) {
+	var index [columnCount]int
+	for i := range index {
+		index[i] = -1
+	}
+	for i, h := range header {
+		if c, ok := headers[strings.ToLower(strings.TrimSpace(h))]; ok {
+			index[c] = i
+		}
+	}
+
+	for c, i := range index {
+		if i < 0 && column(c) != colPatronymic && column(c) != colExternalID && column(c) != colHireDate {
+			return index, errors.New("в первой строке нужны заголовки: фамилия, имя, отчество (необязательно), email, " +
+				"дата рождения, табельный номер (необязательно), дата приема (необязательно)")
+		}
+	}
+
+	return index, nil
+}
+
+// parseRow проверит и разберет одну строку
+func parseRow(record []string, index [columnCount]int, line int, now time.Time) (Row, error) {
+	cell := func(c column) string {
+		if i := index[c]; i >= 0 && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	row := Row{
+		Line:       line,
+		LastName:   cell(colLastName),
+		FirstName:  cell(colFirstName),
+		Patronymic: cell(colPatronymic),
+		Email:      strings.ToLower(cell(colEmail)),
+		ExternalID: cell(colExternalID),
+	}
+	if row.LastName == "" || row.FirstName == "" {
+		return row, fmt.Errorf("строка %d: фамилия и имя обязательны", line)
+	}
+	if !emailRe.MatchString(row.Email) {
+		return row, fmt.Errorf("строка %d: некорректный email %q", line, row.Email)
+	}
+
+	date, err := parseDate(cell(colBirthDate))
+	if err == nil {
+		date, err = checkDate(date, now)
+	}
+	if err != nil {
+		return row, fmt.Errorf("строка %d: некорректная дата рождения %q", line, cell(colBirthDate))
+	}
+	row.BirthDate = date
+
+	// дата приема может быть и в будущем: сотрудника заводят заранее
+	if hired := cell(colHireDate); hired != "" {
+		if row.HireDate, err = parseDate(hired); err != nil {
+			return row, fmt.Errorf("строка %d: некорректная дата приема %q", line, hired)
+		}
+	}
+
+	return row, nil
+}
+
+// excelEpoch от этой даты Excel отсчитывает дни
+var excelEpoch = time.Date(1899, time.December, 30, 0, 0, 0, 0, time.UTC)
+
+// parseDate дата в формате ГГГГ-ММ-ДД, ДД.ММ.ГГГГ или числом дней Excel
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{time.DateOnly, "02.01.2006"} {
+		if date, err := time.Parse(layout, s); err == nil {
+			return date, nil
+		}
+	}
+
+	if days, err := strconv.ParseFloat(s, 64); err == nil {
+		return excelEpoch.AddDate(0, 0, int(days)), nil
+	}
+
+	return time.Time{}, errors.New("неизвестный формат даты")
+}
+
+// checkDate отсеет заведомо невозможные даты рождения (раньше 1900 года или позже now)
+func checkDate(date, now time.Time) (time.Time, error) {
+	if date.Year() < 1900 || date.After(now) {
+		return time.Time{}, errors.New("дата вне допустимого диапазона")
+	}
+
+	return date, nil
+}
+
+// isBlank пустая строка таблицы
+func isBlank(record []string) bool {
+	for _, v := range record {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,116 @@
+package roster
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// rosterNow сегодня для проверки дат рождения
+var rosterNow = time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+// rowText строка таблицы одной строкой для сравнения
+func rowText(r Row) string {
+	hired := "-"
+	if !r.HireDate.IsZero() {
+		hired = r.HireDate.Format(time.DateOnly)
+	}
+
+	return fmt.Sprintf("%d %s %s %s %s %s %s %s", r.Line, r.LastName, r.FirstName, r.Patronymic, r.Email,
+		r.BirthDate.Format(time.DateOnly), r.ExternalID, hired)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		file     string
+		rows     []string
+		problems []string
+	}{
+		{
+			file: "employees.csv",
+			rows: []string{
+				"2 Иванов Иван Иванович ivanov@example.com 1990-05-12 T-1 2020-01-15",
+				"3 Петров Петр  petrov@example.com 1985-02-01 T-2 -",
+			},
+			problems: []string{
+				"строка 4: фамилия и имя обязательны",
+				`строка 5: некорректный email "kozlov@"`,
+				`строка 6: некорректная дата рождения "2025-03-02"`,
+				"строка 8: email ivanov@example.com уже был в строке 2",
+				`строка 9: некорректная дата рождения "1899-12-31"`,
+				"строка 10: табельный номер T-1 уже был в строке 2",
+				`строка 11: некорректная дата приема "32.13.2020"`,
+			},
+		},
+		{
+			// заголовки по-русски в любом регистре, разделитель ";", дата рождения числом дней Excel
+			file: "employees_semicolon.csv",
+			rows: []string{
+				"2 Иванов Иван Иванович ivanov@example.com 1990-05-12 T-1 2020-01-15",
+				"3 Петров Петр  petrov@example.com 1985-02-01  -",
+			},
+		},
+		{
+			// общие и встроенные строки, даты числом дней Excel, пропущенная ячейка
+			file: "employees.xlsx",
+			rows: []string{
+				"2 Иванов Иван Иванович ivanov@example.com 1990-05-12 T-1 2020-01-15",
+				"3 Петров Петр  petrov@example.com 1985-02-01  -",
+			},
+		},
+		{
+			// записи нумеруются с единицы, табельный номер числом
+			file: "employees.json",
+			rows: []string{
+				"1 Иванов Иван Иванович ivanov@example.com 1990-05-12 1 2020-01-15",
+				"2 Петров Петр  petrov@example.com 1985-02-01  -",
+			},
+			problems: []string{`строка 3: некорректный email ""`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows, problems, err := Parse(tt.file, data, rosterNow)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, r := range rows {
+				got = append(got, rowText(r))
+			}
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.rows) {
+				t.Errorf("строки:\n got %q\nwant %q", got, tt.rows)
+			}
+			if fmt.Sprint(problems) != fmt.Sprint(tt.problems) {
+				t.Errorf("ошибки:\n got %q\nwant %q", problems, tt.problems)
+			}
+		})
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"employees.txt", "last_name,first_name,email,birth_date"},
+		{"employees.csv", ""},
+		{"employees.csv", "фамилия,имя,email\nИванов,Иван,ivanov@example.com"},
+		{"employees.xlsx", "last_name,first_name,email,birth_date"},
+		{"employees.json", `{"staff": []}`},
+	}
+
+	for _, tt := range tests {
+		if _, _, err := Parse(tt.name, []byte(tt.data), rosterNow); err == nil {
+			t.Errorf("Parse(%s, %q): ожидалась ошибка", tt.name, tt.data)
+		}
+	}
+}
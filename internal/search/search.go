@@ -0,0 +1,90 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Threshold минимальная похожесть (0..1), при которой сотрудник попадает в результаты поиска
+// (в Postgres - pg_trgm.word_similarity_threshold)
+const Threshold = 0.4
+
+// translitTable кириллица в латиницу (так же считает translit в Postgres)
+var translitTable = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya",
+}
+
+// Normalize приведет строку к виду для поиска: нижний регистр, кириллица транслитерирована
+func Normalize(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if latin, ok := translitTable[r]; ok {
+			b.WriteString(latin)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// Score насколько text похож на запрос query (оба уже нормализованы): для каждого слова
+// запроса берется самое похожее слово текста по триграммам, как в pg_trgm
+func Score(query, text string) float64 {
+	queryWords, textWords := words(query), words(text)
+	if len(queryWords) == 0 {
+		return 0
+	}
+
+	total := 0.0
+	for _, q := range queryWords {
+		best := 0.0
+		for _, t := range textWords {
+			if strings.HasPrefix(t, q) {
+				best = 1
+				break
+			}
+			if s := similarity(q, t); s > best {
+				best = s
+			}
+		}
+		total += best
+	}
+
+	return total / float64(len(queryWords))
+}
+
+// words слова из букв и цифр
+func words(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// similarity доля общих триграмм двух слов
+func similarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	common := 0
+	for t := range ta {
+		if tb[t] {
+			common++
+		}
+	}
+
+	return float64(common) / float64(len(ta)+len(tb)-common)
+}
+
+// trigrams триграммы слова с отступами, как в pg_trgm ("  w", " wo", ..., "rd ")
+func trigrams(word string) map[string]bool {
+	r := []rune("  " + word + " ")
+	set := make(map[string]bool, len(r))
+	for i := 0; i+3 <= len(r); i++ {
+		set[string(r[i:i+3])] = true
+	}
+
+	return set
+}
@@ -0,0 +1,35 @@
+-- migrations/000002_create_subscriptions_table.up.sql
+CREATE TABLE IF NOT EXISTS subscriptions (
+    subscriber_id UUID NOT NULL REFERENCES employees (id) ON DELETE CASCADE,
+    target_id UUID NOT NULL REFERENCES employees (id) ON DELETE CASCADE,
+    lead_seconds BIGINT NOT NULL DEFAULT 0 CHECK (lead_seconds >= 0),
+    next_fire_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (subscriber_id, target_id)
+);
+
+-- обратный поиск (кто подписан на сотрудника) и выборка по времени оповещания
+CREATE INDEX IF NOT EXISTS subscriptions_target_id_idx ON subscriptions (target_id);
+CREATE INDEX IF NOT EXISTS subscriptions_next_fire_at_idx ON subscriptions (next_fire_at);
+
+-- перенос подписок из employees.subscribe: {"<uuid>": "<дата оповещания>"}
+-- за сколько оповещать восстанавливаем по ближайшему Дню рождения не раньше даты оповещания
+INSERT INTO subscriptions (subscriber_id, target_id, lead_seconds, next_fire_at)
+SELECT s.subscriber_id, s.target_id,
+       EXTRACT(EPOCH FROM (
+           CASE WHEN b.birthday < s.next_fire_at THEN b.birthday + INTERVAL '1 year' ELSE b.birthday END
+           - s.next_fire_at))::BIGINT,
+       s.next_fire_at
+FROM (
+    SELECT e.id AS subscriber_id, kv.key::UUID AS target_id, (kv.value #>> '{}')::TIMESTAMPTZ AS next_fire_at
+    FROM employees e, jsonb_each(e.subscribe) kv
+    WHERE kv.key ~* '^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$'
+) s
+JOIN employees t ON t.id = s.target_id
+CROSS JOIN LATERAL (
+    SELECT (t.birth_date + make_interval(years =>
+               EXTRACT(YEAR FROM s.next_fire_at AT TIME ZONE 'UTC')::INT - EXTRACT(YEAR FROM t.birth_date)::INT)
+           )::TIMESTAMP AT TIME ZONE 'UTC' AS birthday
+) b
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE employees DROP COLUMN IF EXISTS subscribe;
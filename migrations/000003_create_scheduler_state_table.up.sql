@@ -0,0 +1,6 @@
+-- migrations/000003_create_scheduler_state_table.up.sql
+-- до какого момента планировщик уже обработал оповещания
+CREATE TABLE IF NOT EXISTS scheduler_state (
+    name TEXT PRIMARY KEY,
+    processed_until TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,3 @@
+-- migrations/000005_add_employees_timezone.up.sql
+-- часовой пояс сотрудника (IANA), в нем считаются поздравления и напоминания
+ALTER TABLE employees ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT 'UTC';
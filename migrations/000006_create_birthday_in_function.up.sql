@@ -0,0 +1,12 @@
+-- migrations/000006_create_birthday_in_function.up.sql
+-- День рождения в году y; для 29 февраля в невисокосный год - по правилу policy:
+-- 'feb28' - 28 февраля, 'mar1' - 1 марта (см. calendar.BirthdayIn)
+CREATE OR REPLACE FUNCTION birthday_in(birth DATE, y INT, policy TEXT) RETURNS DATE
+LANGUAGE SQL IMMUTABLE AS $$
+    SELECT CASE
+        WHEN EXTRACT(MONTH FROM birth) = 2 AND EXTRACT(DAY FROM birth) = 29
+             AND NOT (y % 4 = 0 AND (y % 100 <> 0 OR y % 400 = 0))
+        THEN CASE WHEN policy = 'mar1' THEN make_date(y, 3, 1) ELSE make_date(y, 2, 28) END
+        ELSE make_date(y, EXTRACT(MONTH FROM birth)::INT, EXTRACT(DAY FROM birth)::INT)
+    END
+$$;
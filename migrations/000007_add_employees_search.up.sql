@@ -0,0 +1,21 @@
+-- migrations/000007_add_employees_search.up.sql
+-- Нечеткий поиск сотрудников по ФИО и email (/find)
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Кириллица в латиницу, чтобы "Иванов" и "Ivanov" искались одинаково (см. search.Normalize)
+CREATE OR REPLACE FUNCTION translit(s TEXT) RETURNS TEXT
+LANGUAGE SQL IMMUTABLE AS $$
+    SELECT translate(
+        replace(replace(replace(replace(replace(replace(replace(replace(replace(replace(lower(s),
+            'щ', 'shch'), 'ж', 'zh'), 'х', 'kh'), 'ц', 'ts'), 'ч', 'ch'),
+            'ш', 'sh'), 'ю', 'yu'), 'я', 'ya'), 'ъ', ''), 'ь', ''),
+        'абвгдеёзийклмнопрстуфыэ',
+        'abvgdeeziyklmnoprstufye')
+$$;
+
+ALTER TABLE employees ADD COLUMN IF NOT EXISTS search_text TEXT
+    GENERATED ALWAYS AS (translit(
+        coalesce(last_name, '') || ' ' || coalesce(first_name, '') || ' ' ||
+        coalesce(patronymic, '') || ' ' || coalesce(email, ''))) STORED;
+
+CREATE INDEX IF NOT EXISTS employees_search_text_idx ON employees USING GIN (search_text gin_trgm_ops);
@@ -0,0 +1,15 @@
+-- migrations/000008_create_conversations_table.up.sql
+-- Диалоги с пользователями telegram (см. internal/dialog) вместо флагов wait_* у сотрудника
+CREATE TABLE IF NOT EXISTS conversations (
+    telegram_id BIGINT PRIMARY KEY,
+    state TEXT NOT NULL,
+    employee_id UUID REFERENCES employees (id) ON DELETE CASCADE,
+    data TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- незавершенные по старым флагам диалоги не переносим: пользователь просто повторит команду
+ALTER TABLE employees
+    DROP COLUMN IF EXISTS wait_login,
+    DROP COLUMN IF EXISTS wait_subscribe,
+    DROP COLUMN IF EXISTS wait_unsubscribe;
@@ -0,0 +1,19 @@
+-- migrations/000009_create_login_codes_table.up.sql
+-- Одноразовые коды входа: хранится только HMAC кода и время создания (см. internal/otp)
+CREATE TABLE IF NOT EXISTS login_codes (
+    employee_id UUID PRIMARY KEY REFERENCES employees (id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+-- Сколько раз за текущее окно отправляли код и вводили его (по сотруднику и по telegram ID)
+CREATE TABLE IF NOT EXISTS login_limits (
+    key TEXT NOT NULL,
+    action TEXT NOT NULL,
+    window_start TIMESTAMPTZ NOT NULL,
+    count INT NOT NULL,
+    PRIMARY KEY (key, action)
+);
+
+-- временные пароли в открытом виде больше не храним
+ALTER TABLE employees DROP COLUMN IF EXISTS temp_password;
@@ -0,0 +1,15 @@
+-- migrations/000010_create_sessions_table.up.sql
+-- Сессии входа: ID сессии - jti JWT-токена, отозванная сессия делает токен недействительным
+CREATE TABLE IF NOT EXISTS sessions (
+    id UUID PRIMARY KEY,
+    employee_id UUID NOT NULL REFERENCES employees (id) ON DELETE CASCADE,
+    telegram_id BIGINT NOT NULL,
+    issued_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS sessions_employee_id_idx ON sessions (employee_id) WHERE revoked_at IS NULL;
+
+-- старые токены без jti и telegram_id больше не принимаются: пользователи войдут заново
+UPDATE employees SET token = '';
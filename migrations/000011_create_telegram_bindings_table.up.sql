@@ -0,0 +1,12 @@
+-- migrations/000011_create_telegram_bindings_table.up.sql
+-- Аудит привязок сотрудников к telegram: каждая смена telegram_id
+CREATE TABLE IF NOT EXISTS telegram_bindings (
+    id BIGSERIAL PRIMARY KEY,
+    employee_id UUID NOT NULL REFERENCES employees (id) ON DELETE CASCADE,
+    old_telegram_id BIGINT NOT NULL,
+    new_telegram_id BIGINT NOT NULL,
+    reason TEXT NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS telegram_bindings_employee_id_idx ON telegram_bindings (employee_id, changed_at);
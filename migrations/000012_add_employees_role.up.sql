@@ -0,0 +1,8 @@
+-- migrations/000012_add_employees_role.up.sql
+-- Роли в боте (см. db.Role) и деактивация сотрудников вместо удаления.
+-- Первого администратора назначают вручную:
+--   UPDATE employees SET role = 'admin' WHERE email = '...';
+ALTER TABLE employees
+    ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'employee'
+        CHECK (role IN ('employee', 'hr', 'admin')),
+    ADD COLUMN IF NOT EXISTS active BOOLEAN NOT NULL DEFAULT TRUE;
@@ -0,0 +1,5 @@
+-- migrations/000013_add_employees_external_id.up.sql
+-- Идентификатор сотрудника в HR-системе: по нему сверяется ночная выгрузка (cmd/hris)
+ALTER TABLE employees ADD COLUMN IF NOT EXISTS external_id TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS employees_external_id_idx ON employees (external_id) WHERE external_id <> '';
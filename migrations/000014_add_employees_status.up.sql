@@ -0,0 +1,14 @@
+-- migrations/000014_add_employees_status.up.sql
+-- Статус занятости (см. db.Status) вместо флага active
+ALTER TABLE employees
+    ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active'
+        CHECK (status IN ('active', 'on_leave', 'terminated'));
+
+UPDATE employees SET status = 'terminated' WHERE NOT active;
+
+-- деактивированные до этой миграции могли остаться в чужих подписках
+DELETE FROM subscriptions s
+USING employees e
+WHERE e.status = 'terminated' AND (s.subscriber_id = e.id OR s.target_id = e.id);
+
+ALTER TABLE employees DROP COLUMN IF EXISTS active;
@@ -0,0 +1,31 @@
+-- migrations/000015_create_teams_tables.up.sql
+-- Отделы и команды, участники и подписки на всю команду
+CREATE TABLE IF NOT EXISTS teams (
+    id UUID PRIMARY KEY,
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL DEFAULT 'team' CHECK (kind IN ('department', 'team'))
+);
+
+-- названия не различаются по регистру: команды ищутся по названию (/teamadd, /team)
+CREATE UNIQUE INDEX IF NOT EXISTS teams_name_idx ON teams (lower(name));
+
+CREATE TABLE IF NOT EXISTS team_members (
+    team_id UUID NOT NULL REFERENCES teams (id) ON DELETE CASCADE,
+    employee_id UUID NOT NULL REFERENCES employees (id) ON DELETE CASCADE,
+    PRIMARY KEY (team_id, employee_id)
+);
+
+CREATE INDEX IF NOT EXISTS team_members_employee_id_idx ON team_members (employee_id);
+
+CREATE TABLE IF NOT EXISTS team_subscriptions (
+    subscriber_id UUID NOT NULL REFERENCES employees (id) ON DELETE CASCADE,
+    team_id UUID NOT NULL REFERENCES teams (id) ON DELETE CASCADE,
+    lead_seconds BIGINT NOT NULL DEFAULT 0 CHECK (lead_seconds >= 0),
+    PRIMARY KEY (subscriber_id, team_id)
+);
+
+CREATE INDEX IF NOT EXISTS team_subscriptions_team_id_idx ON team_subscriptions (team_id);
+
+-- подписка на команду разворачивается в подписки на каждого участника с team_id этой команды
+-- (NULL - подписка на самого сотрудника, она важнее командной)
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS team_id UUID REFERENCES teams (id) ON DELETE CASCADE;
@@ -0,0 +1,10 @@
+-- migrations/000016_add_hire_date_and_subscription_event.up.sql
+-- Годовщины работы: дата приема и вид события подписки (см. db.EventKind)
+ALTER TABLE employees ADD COLUMN IF NOT EXISTS hire_date DATE;
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS event TEXT NOT NULL DEFAULT 'birthday'
+    CHECK (event IN ('birthday', 'anniversary'));
+
+-- на коллегу можно подписаться отдельно на День рождения и на годовщину
+ALTER TABLE subscriptions DROP CONSTRAINT IF EXISTS subscriptions_pkey;
+ALTER TABLE subscriptions ADD PRIMARY KEY (subscriber_id, target_id, event);
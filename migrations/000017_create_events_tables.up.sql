@@ -0,0 +1,43 @@
+-- migrations/000017_create_events_tables.up.sql
+-- Произвольные события сотрудника или всей компании (см. db.CustomEvent) и подписки на них
+CREATE TABLE IF NOT EXISTS events (
+    id UUID PRIMARY KEY,
+    employee_id UUID REFERENCES employees (id) ON DELETE CASCADE, -- NULL - событие всей компании
+    title TEXT NOT NULL,
+    rule TEXT NOT NULL CHECK (rule IN ('yearly', 'once', 'weekday')),
+    day DATE,                       -- yearly: ежегодно в этот день, once: однократно
+    month INT NOT NULL DEFAULT 0,   -- weekday: месяц 1-12
+    week INT NOT NULL DEFAULT 0,    -- weekday: неделя месяца 1-4 или -1 (последняя)
+    weekday INT NOT NULL DEFAULT 0, -- weekday: день недели, 0 - воскресенье (как EXTRACT(DOW))
+    CHECK ((rule = 'weekday') = (day IS NULL)),
+    CHECK (rule <> 'weekday' OR (month BETWEEN 1 AND 12 AND (week BETWEEN 1 AND 4 OR week = -1)
+        AND weekday BETWEEN 0 AND 6))
+);
+
+CREATE INDEX IF NOT EXISTS events_employee_id_idx ON events (employee_id);
+
+CREATE TABLE IF NOT EXISTS event_subscriptions (
+    subscriber_id UUID NOT NULL REFERENCES employees (id) ON DELETE CASCADE,
+    event_id UUID NOT NULL REFERENCES events (id) ON DELETE CASCADE,
+    lead_seconds BIGINT NOT NULL DEFAULT 0 CHECK (lead_seconds >= 0),
+    next_fire_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (subscriber_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS event_subscriptions_event_id_idx ON event_subscriptions (event_id);
+CREATE INDEX IF NOT EXISTS event_subscriptions_next_fire_at_idx ON event_subscriptions (next_fire_at);
+
+-- День события в году y (NULL, если в этом году его нет; см. db.CustomEvent.In)
+CREATE OR REPLACE FUNCTION event_in(rule TEXT, day DATE, month INT, week INT, weekday INT, y INT, policy TEXT)
+RETURNS DATE LANGUAGE SQL IMMUTABLE AS $$
+    SELECT CASE rule
+        WHEN 'yearly' THEN birthday_in(day, y, policy)
+        WHEN 'once' THEN CASE WHEN EXTRACT(YEAR FROM day)::INT = y THEN day END
+        WHEN 'weekday' THEN CASE
+            WHEN week > 0 THEN make_date(y, month, 1)
+                + (weekday - EXTRACT(DOW FROM make_date(y, month, 1))::INT + 7) % 7 + (week - 1) * 7
+            ELSE (make_date(y, month, 1) + INTERVAL '1 month - 1 day')::DATE
+                - (EXTRACT(DOW FROM make_date(y, month, 1) + INTERVAL '1 month - 1 day')::INT - weekday + 7) % 7
+            END
+    END
+$$;
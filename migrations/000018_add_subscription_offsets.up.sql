@@ -0,0 +1,8 @@
+-- migrations/000018_add_subscription_offsets.up.sql
+-- Несколько оповещаний по одной подписке (например, за неделю, за день и в день): каждое смещение -
+-- отдельная строка со своим next_fire_at, планировщик срабатывает и переносит их независимо
+ALTER TABLE subscriptions DROP CONSTRAINT IF EXISTS subscriptions_pkey;
+ALTER TABLE subscriptions ADD PRIMARY KEY (subscriber_id, target_id, event, lead_seconds);
+
+ALTER TABLE event_subscriptions DROP CONSTRAINT IF EXISTS event_subscriptions_pkey;
+ALTER TABLE event_subscriptions ADD PRIMARY KEY (subscriber_id, event_id, lead_seconds);
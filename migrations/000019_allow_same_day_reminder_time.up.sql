@@ -0,0 +1,8 @@
+-- migrations/000019_allow_same_day_reminder_time.up.sql
+-- Оповещание в день события в заданное время («в день в 09:00») хранится отрицательным смещением:
+-- lead_seconds - насколько раньше полуночи дня события, поэтому разрешаем до минус суток
+ALTER TABLE subscriptions DROP CONSTRAINT IF EXISTS subscriptions_lead_seconds_check;
+ALTER TABLE subscriptions ADD CONSTRAINT subscriptions_lead_seconds_check CHECK (lead_seconds > -86400);
+
+ALTER TABLE event_subscriptions DROP CONSTRAINT IF EXISTS event_subscriptions_lead_seconds_check;
+ALTER TABLE event_subscriptions ADD CONSTRAINT event_subscriptions_lead_seconds_check CHECK (lead_seconds > -86400);
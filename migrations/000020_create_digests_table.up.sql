@@ -0,0 +1,13 @@
+-- migrations/000020_create_digests_table.up.sql
+-- Дайджест: по понедельникам или 1-го числа - список предстоящих Дней рождения подписок,
+-- всей компании или одной команды (см. db.Digest). У сотрудника не больше одного дайджеста
+CREATE TABLE IF NOT EXISTS digests (
+    employee_id UUID PRIMARY KEY REFERENCES employees (id) ON DELETE CASCADE,
+    schedule TEXT NOT NULL CHECK (schedule IN ('weekly', 'monthly')),
+    scope TEXT NOT NULL DEFAULT 'subscriptions' CHECK (scope IN ('subscriptions', 'company', 'team')),
+    team_id UUID REFERENCES teams (id) ON DELETE CASCADE, -- только для scope = 'team'
+    next_fire_at TIMESTAMPTZ NOT NULL,
+    CHECK ((scope = 'team') = (team_id IS NOT NULL))
+);
+
+CREATE INDEX IF NOT EXISTS digests_next_fire_at_idx ON digests (next_fire_at);
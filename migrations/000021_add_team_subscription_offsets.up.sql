@@ -0,0 +1,5 @@
+-- migrations/000021_add_team_subscription_offsets.up.sql
+-- Несколько оповещаний по подписке на команду, как в 000018: каждое смещение - отдельная строка,
+-- по каждой у участников заводится своя подписка
+ALTER TABLE team_subscriptions DROP CONSTRAINT IF EXISTS team_subscriptions_pkey;
+ALTER TABLE team_subscriptions ADD PRIMARY KEY (subscriber_id, team_id, lead_seconds);
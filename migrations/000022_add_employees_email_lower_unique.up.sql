@@ -0,0 +1,5 @@
+-- migrations/000022_add_employees_email_lower_unique.up.sql
+-- Email сравнивается без учета регистра (вход, проверка занятости, импорт):
+-- адреса, различающиеся только регистром, считаются одним. Если индекс не создается,
+-- такие дубли уже есть - их нужно объединить вручную
+CREATE UNIQUE INDEX IF NOT EXISTS employees_email_lower_idx ON employees (lower(email)) WHERE email <> '';